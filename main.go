@@ -6,6 +6,8 @@ import (
 	"slices"
 
 	"InferenceProfiler/pkg/cmd"
+	"InferenceProfiler/pkg/collecting"
+	"InferenceProfiler/pkg/utils"
 )
 
 func main() {
@@ -38,6 +40,8 @@ Commands:
   continuous, c   Collect on a fixed interval until Ctrl+C / SIGTERM (default)
   snapshot, s     Single collection pass, then exit
   server, ser     HTTP API server for remote control
+  controller, ctl Drive several servers at once:
+                  infpro controller start|stop|status|collect|run [flags]
 
 Output:
   Each run writes a static line (config + system info) followed by dynamic
@@ -51,23 +55,29 @@ Output:
     GET    /snapshot         Live state: {"static": {...}, "tick": {...}}
                              (tick is non-empty only while collecting)
     GET    /collect          Current state and run info
-    PUT    /collect          Start a continuous run (body: {"uuid": "..."})
+    PUT    /collect          Start a continuous run (body: run spec)
     DELETE /collect          Stop and flush
+    POST   /collect/markers  Add a marker to the active run
+    GET    /runs             Run catalogue, newest first (?state= &uuid=
+                             &host= &collector= &marker= &since= &until=
+                             &offset= &limit=)
+    POST   /runs             Start a run alongside others (body: run spec)
+    GET    /runs/{uuid}      One run
+    POST   /runs/{uuid}/stop Stop one run
+    DELETE /runs/{uuid}      Remove a run from the catalogue
+    GET    /collectors       Collector init results and health
+    GET    /alerts           Alert rule states
     GET    /files            List output files (optional ?uuid=xxx)
-    GET    /files/{uuid}     Stream the file whose name starts with {uuid}
-                             (supports Range header)
+    GET    /files/{uuid}     Stream the file named {uuid}, else {uuid}.jsonl,
+                             else the first starting with {uuid} (supports
+                             Range header; ?format=flat-jsonl, csv or parquet
+                             converts {uuid} or {uuid}.jsonl, &fields= selects)
+    DELETE /files/{uuid}     Delete a finished run's files
 
 Collection flags:
-  -no-vm           Disable VM metrics (cpu, mem, disk, net)
-  -no-container    Disable container/cgroup metrics
-  -no-procs        Disable process metrics
-  -no-nvidia       Disable NVIDIA GPU metrics
-  -no-vllm         Disable vLLM metrics
-  -no-vllm-hist    Disable vLLM histogram collection
-  -vllm-endpoint URL    vLLM metrics endpoint (default: http://localhost:8000/metrics)
-  -disabled LIST   Comma-separated collectors to disable
-                   (vm,container,process,nvidia,vllm,vllm-hist)
-
+`)
+	utils.WriteCollectorUsage(os.Stdout, collecting.Specs())
+	fmt.Print(`
 Output flags:
   -output DIR      Output directory (default: stdout)
   -flatten         Flatten nested structs to top-level keys
@@ -75,9 +85,94 @@ Output flags:
 
 Timing flags:
   -interval MS     Collection interval in milliseconds (default: 1000)
+  -intervals LIST  Per-collector overrides as name=ms, e.g.
+                   nvidia=100,process=5000; nvidia.<domain>=ms slows a
+                   single GPU domain (power, memory, utilization, clocks,
+                   thermal, pcie, violations, nvlink, ecc, events,
+                   processes, samples, mig)
+  -adaptive        Back off collectors whose poll time nears their interval
+  -align           Poll at wall-clock multiples of each interval and write
+                   records half an interval later, so hosts line up
+  -stale-policy P  Sections not refreshed since the last tick: skip (default)
+                   omits them, flag repeats them, drop repeats them until
+                   they are older than -stale-after intervals
+  -stale-after N   Intervals after which a section is stale (default: 3)
+  -poll-timeout MS Per-poll deadline (default: 0 = 5x the collector interval)
+  -degraded-after N
+                   Consecutive failed polls (timeouts or panics) before a
+                   collector is reported degraded (default: 3)
+  -init-retry MS   Retry failed collector init at this interval
+                   (default: 30000, 0 = never)
+  -queue N         Records buffered in front of the writer (default: 64)
+  -queue-policy P  When the queue is full: block (default) waits for the
+                   writer, drop-newest or drop-oldest discard a record
+
+Run limits (0 = none; the first one reached stops the run):
+  -duration S      Stop after S seconds
+  -max-samples N   Stop after N records
+  -max-bytes SIZE  Stop once the output (with trigger captures) reaches
+                   SIZE bytes; K, M, G and T suffixes, e.g. 2G
+  -idle-stop S     Stop once vLLM has had no running or waiting requests
+                   for S seconds, counted from its first busy record
+  -min-free SIZE   Refuse to start, or stop, a run while the output
+                   filesystem has less than SIZE free
+
+Markers:
+  -marker-fifo PATH
+                   Create a FIFO; each line written to it adds a marker
+                   labelled with the line to the next record
+  SIGUSR1          Adds a marker labelled signal-N
+
+Trigger flags:
+  -trigger [L:]EXPR
+                   Write -trigger-pre seconds before and -trigger-post
+                   seconds after records where EXPR starts to hold to
+                   {uuid}.trigger-L-N.jsonl (repeatable; needs -output).
+                   EXPR: FIELD OP NUMBER with flattened field names, *
+                   wildcards, delta(FIELD), rate(FIELD), joined by &&
+  -trigger-pre S   Seconds of history per capture (default: 30)
+  -trigger-post S  Seconds after the last trigger (default: 30)
+  -trigger-only    Write only the captures, not the continuous stream
+
+Alert flags:
+  -alerts FILE     JSON rules ({"webhook": URL, "rules": [{"name", "expr",
+                   "for", "severity"}]}) evaluated on every record
+  -alert-webhook URL
+                   POST alert events here (overrides the file's webhook)
 
 Server flags:
-  -port PORT       HTTP port (default: 8888, bound on 0.0.0.0)
+  -port PORT       HTTP port (default: 8888)
+  -bind ADDR       Listen address (default: 0.0.0.0)
+  -tls-cert FILE   Serve HTTPS with this PEM certificate (needs -tls-key)
+  -tls-key FILE    PEM private key for -tls-cert
+  -tls-client-ca FILE
+                   Require client certificates signed by this CA (mTLS)
+  -token TOKEN     Bearer token for read and control endpoints
+  -read-token TOKEN
+                   Bearer token for read-only endpoints (GETs)
+  -retain-age D    Delete runs that ended more than D ago (e.g. 168h)
+  -retain-bytes SIZE
+                   Delete the oldest runs while the output directory holds
+                   more than SIZE
+  -retain-runs N   Keep only the newest N runs
+
+Controller flags:
+  -hosts LIST      Servers as host, host:port or URL, comma-separated
+  -hosts-file FILE One server per line
+  -port PORT       Port for hosts without one (default: 8888)
+  -uuid ID         Run UUID (start/run default: random)
+  -spec FILE       JSON run spec sent to every host on start
+  -output DIR      collect/run: files go to DIR/{host}/ (default: .)
+  -format F        collect/run: fetch JSONL as flat-jsonl, csv or parquet
+  -token TOKEN     Bearer token sent to every host
+  -tls-ca FILE     Use HTTPS, trusting this CA
+  -tls-cert FILE, -tls-key FILE
+                   Client certificate for mTLS
+  -timeout D       Deadline per API call (default: 30s)
+  -poll D          Status interval for run and status -watch (default: 5s)
+  -partial         Keep hosts that started when others fail
+  -watch           status: repeat until the run has ended everywhere
+  -json            Print results as JSON
 
 Debug flags:
   -debug           Verbose debug logging to stderr
@@ -98,9 +193,14 @@ Examples:
   infpro -interval 100                      Continuous at 100ms
   infpro -no-nvidia -no-vllm                Skip GPU and vLLM collectors
   infpro -disabled vm,process               Same idea via -disabled
+  infpro -intervals nvidia=100,process=5000 GPU at 100ms, processes at 5s
   infpro -poll-stats                        Show timing stats on Ctrl+C
   infpro server -output ./data              Server mode on default port
   infpro ser -port 9090                     Server on a custom port
   infpro -debug -interval 500               Verbose debug output
+  infpro ctl run -hosts a,b -output ./res   Synchronized run on two servers,
+                                            files collected on Ctrl+C
+  infpro -nvml-fake 2 -nvml-fake-curve square:10s
+                                            Two simulated GPUs, no driver
 `)
 }
@@ -0,0 +1,180 @@
+// Package alerting evaluates -alerts rules against every record of a run.
+// Rules fire once their expression has held for the rule's duration and
+// resolve when it stops holding; each transition is written to the record
+// under "Alerts", logged, and POSTed to the webhook if one is configured.
+package alerting
+
+import (
+	"InferenceProfiler/pkg/utils"
+	"log"
+	"sync"
+	"time"
+)
+
+// Alert states. A rule whose expression holds but not yet for its full
+// duration is pending.
+const (
+	StateInactive = "inactive"
+	StatePending  = "pending"
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// Event is one firing or resolved transition.
+type Event struct {
+	Rule     string        `json:"Rule"`
+	Severity string        `json:"Severity"`
+	State    string        `json:"State"`
+	Expr     string        `json:"Expr"`
+	At       int64         `json:"At"`
+	Since    int64         `json:"Since"`
+	Matches  []utils.Match `json:"Matches,omitempty"`
+	UUID     string        `json:"UUID"`
+}
+
+// RuleState is a rule's current state as reported by the server API.
+type RuleState struct {
+	Rule       string        `json:"rule"`
+	Expr       string        `json:"expr"`
+	For        string        `json:"for"`
+	Severity   string        `json:"severity"`
+	State      string        `json:"state"`
+	Since      *time.Time    `json:"since,omitempty"`
+	FiredAt    *time.Time    `json:"fired_at,omitempty"`
+	ResolvedAt *time.Time    `json:"resolved_at,omitempty"`
+	Fired      int           `json:"fired"`
+	Matches    []utils.Match `json:"matches,omitempty"`
+}
+
+type rule struct {
+	utils.AlertRule
+	state    string
+	since    time.Time
+	firedAt  time.Time
+	resolved time.Time
+	fired    int
+	matches  []utils.Match
+}
+
+// Engine holds the rules of one run.
+type Engine struct {
+	uuid   string
+	notify *notifier
+
+	mu     sync.Mutex
+	rules  []*rule
+	prev   map[string]any
+	prevAt time.Time
+}
+
+// New returns nil when cfg has no alert rules.
+func New(cfg *utils.Config) *Engine {
+	if len(cfg.Alerts) == 0 {
+		return nil
+	}
+	e := &Engine{uuid: cfg.UUID, notify: newNotifier(cfg.AlertWebhook)}
+	for _, r := range cfg.Alerts {
+		e.rules = append(e.rules, &rule{AlertRule: r, state: StateInactive})
+	}
+	log.Printf("alerting: %d rules", len(e.rules))
+	return e
+}
+
+// Eval advances every rule with the flattened record flat taken at now and
+// returns the transitions.
+func (e *Engine) Eval(flat map[string]any, now time.Time) []Event {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var events []Event
+	for _, r := range e.rules {
+		matches, ok := r.Expr.Eval(flat, e.prev, now.Sub(e.prevAt))
+		switch {
+		case ok && r.state != StateFiring && r.state != StatePending:
+			r.state, r.since = StatePending, now
+			fallthrough
+		case ok && r.state == StatePending:
+			r.matches = matches
+			if now.Sub(r.since) >= r.For {
+				r.state, r.firedAt = StateFiring, now
+				r.fired++
+				events = append(events, e.event(r, now))
+			}
+		case ok:
+			r.matches = matches
+		case r.state == StateFiring:
+			r.state, r.resolved, r.matches = StateResolved, now, nil
+			events = append(events, e.event(r, now))
+		case r.state == StatePending:
+			r.state = StateInactive
+			r.matches = nil
+		}
+	}
+	e.prev, e.prevAt = flat, now
+
+	for _, ev := range events {
+		e.notify.send(ev)
+	}
+	return events
+}
+
+func (e *Engine) event(r *rule, now time.Time) Event {
+	return Event{
+		Rule:     r.Name,
+		Severity: r.Severity,
+		State:    r.state,
+		Expr:     r.Expr.String(),
+		At:       now.UnixNano(),
+		Since:    r.since.UnixNano(),
+		Matches:  r.matches,
+		UUID:     e.uuid,
+	}
+}
+
+// States reports every rule.
+func (e *Engine) States() []RuleState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]RuleState, 0, len(e.rules))
+	for _, r := range e.rules {
+		s := RuleState{
+			Rule:     r.Name,
+			Expr:     r.Expr.String(),
+			For:      r.For.String(),
+			Severity: r.Severity,
+			State:    r.state,
+			Fired:    r.fired,
+			Matches:  r.matches,
+		}
+		if r.state == StatePending || r.state == StateFiring {
+			s.Since = timePtr(r.since)
+		}
+		if !r.firedAt.IsZero() {
+			s.FiredAt = timePtr(r.firedAt)
+		}
+		if !r.resolved.IsZero() {
+			s.ResolvedAt = timePtr(r.resolved)
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// Close resolves alerts still firing, since nothing watches them once the
+// run ends, and waits for pending notifications.
+func (e *Engine) Close() {
+	e.mu.Lock()
+	now := time.Now()
+	for _, r := range e.rules {
+		if r.state == StateFiring {
+			r.state, r.resolved, r.matches = StateResolved, now, nil
+			ev := e.event(r, now)
+			log.Printf("alerting: %s resolved at end of run", r.Name)
+			e.notify.send(ev)
+		}
+	}
+	e.mu.Unlock()
+	e.notify.close()
+}
+
+func timePtr(t time.Time) *time.Time { return &t }
@@ -0,0 +1,207 @@
+package alerting
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"InferenceProfiler/pkg/utils"
+)
+
+// newEngine returns an engine for rules posting to webhook, if not empty.
+func newEngine(t *testing.T, webhook string, specs ...utils.AlertSpec) *Engine {
+	t.Helper()
+	rules, err := utils.ParseAlerts(specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(&utils.Config{UUID: "run", Alerts: rules, AlertWebhook: webhook})
+}
+
+// state returns the reported state of rule name.
+func state(t *testing.T, e *Engine, name string) RuleState {
+	t.Helper()
+	for _, s := range e.States() {
+		if s.Rule == name {
+			return s
+		}
+	}
+	t.Fatalf("no rule %s", name)
+	return RuleState{}
+}
+
+func TestEngineTransitions(t *testing.T) {
+	e := newEngine(t, "", utils.AlertSpec{Name: "hot", Expr: "GpuTemp > 80", For: "30s", Severity: "critical"})
+	defer e.Close()
+	start := time.Unix(1000, 0)
+
+	steps := []struct {
+		at    int // seconds
+		temp  float64
+		state string
+		event string // the transition, if any
+	}{
+		{0, 90, StatePending, ""},
+		{10, 90, StatePending, ""},
+		// Stops holding before for: back to inactive, never fired.
+		{20, 50, StateInactive, ""},
+		{30, 90, StatePending, ""},
+		{59, 90, StatePending, ""},
+		{60, 90, StateFiring, StateFiring},
+		{70, 95, StateFiring, ""},
+		{80, 50, StateResolved, StateResolved},
+		{90, 50, StateResolved, ""},
+		// Pending again, for the full duration.
+		{100, 90, StatePending, ""},
+	}
+	for _, step := range steps {
+		now := start.Add(time.Duration(step.at) * time.Second)
+		events := e.Eval(map[string]any{"GpuTemp": step.temp}, now)
+		s := state(t, e, "hot")
+		if s.State != step.state {
+			t.Fatalf("at %ds: state %s, want %s", step.at, s.State, step.state)
+		}
+		if step.event == "" {
+			if len(events) != 0 {
+				t.Fatalf("at %ds: events %+v, want none", step.at, events)
+			}
+			continue
+		}
+		if len(events) != 1 || events[0].State != step.event || events[0].At != now.UnixNano() ||
+			events[0].Rule != "hot" || events[0].Severity != "critical" || events[0].UUID != "run" {
+			t.Fatalf("at %ds: events %+v, want hot %s", step.at, events, step.event)
+		}
+		// Since is when the expression started to hold.
+		if want := start.Add(30 * time.Second).UnixNano(); events[0].Since != want {
+			t.Errorf("at %ds: Since %d, want %d", step.at, events[0].Since, want)
+		}
+		if step.event == StateFiring && (len(events[0].Matches) != 1 || events[0].Matches[0].Value != 90) {
+			t.Errorf("at %ds: matches %+v", step.at, events[0].Matches)
+		}
+	}
+
+	s := state(t, e, "hot")
+	if s.Fired != 1 || !s.Since.Equal(start.Add(100*time.Second)) ||
+		!s.FiredAt.Equal(start.Add(60*time.Second)) || !s.ResolvedAt.Equal(start.Add(80*time.Second)) {
+		t.Errorf("state = %+v", s)
+	}
+}
+
+func TestEngineWithoutFor(t *testing.T) {
+	e := newEngine(t, "",
+		utils.AlertSpec{Name: "down", Expr: "VllmAvailable == 0"},
+		utils.AlertSpec{Name: "preempting", Expr: "rate(VllmPreemptions) > 1"})
+	defer e.Close()
+	start := time.Unix(1000, 0)
+
+	// Fires on the first record it holds in; the rate needs a previous one.
+	events := e.Eval(map[string]any{"VllmAvailable": false, "VllmPreemptions": 0}, start)
+	if len(events) != 1 || events[0].Rule != "down" || events[0].State != StateFiring || events[0].Severity != "warning" {
+		t.Fatalf("events %+v, want down firing", events)
+	}
+	events = e.Eval(map[string]any{"VllmAvailable": true, "VllmPreemptions": 4}, start.Add(2*time.Second))
+	if len(events) != 2 || events[0].Rule != "down" || events[0].State != StateResolved ||
+		events[1].Rule != "preempting" || events[1].State != StateFiring || events[1].Matches[0].Value != 2 {
+		t.Fatalf("events %+v, want down resolved and preempting firing at 2/s", events)
+	}
+}
+
+// webhook records the events POSTed to it, answering with the statuses in
+// turn and 200 once they run out.
+type webhook struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	attempts int
+	got      []string // rule and state of each accepted event
+}
+
+func newWebhook(t *testing.T, statuses ...int) *webhook {
+	w := &webhook{statuses: statuses}
+	w.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var ev Event
+		if err := json.Unmarshal(body, &ev); err != nil || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("webhook got %s (%v)", body, err)
+		}
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.attempts++
+		status := http.StatusOK
+		if len(w.statuses) > 0 {
+			status, w.statuses = w.statuses[0], w.statuses[1:]
+		}
+		if status == http.StatusOK {
+			w.got = append(w.got, ev.Rule+" "+ev.State)
+		}
+		rw.WriteHeader(status)
+	}))
+	t.Cleanup(w.Close)
+	return w
+}
+
+// withBackoff shortens the retry backoff for the rest of the test.
+func withBackoff(t *testing.T, d time.Duration) {
+	saved := notifyBackoff
+	t.Cleanup(func() { notifyBackoff = saved })
+	notifyBackoff = d
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	withBackoff(t, 10*time.Millisecond)
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		want     []string
+	}{
+		{"delivered in order", nil, 3, []string{"down firing", "down resolved", "hot firing"}},
+		{"retries a 5xx and 429", []int{503, 429}, 5, []string{"down firing", "down resolved", "hot firing"}},
+		{"gives up after three attempts", []int{500, 502, 503}, 5, []string{"down resolved", "hot firing"}},
+		{"does not retry a 4xx", []int{404}, 3, []string{"down resolved", "hot firing"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := newWebhook(t, tt.statuses...)
+			e := newEngine(t, hook.URL,
+				utils.AlertSpec{Name: "down", Expr: "VllmAvailable == 0"},
+				utils.AlertSpec{Name: "hot", Expr: "GpuTemp > 80"})
+			start := time.Unix(1000, 0)
+			e.Eval(map[string]any{"VllmAvailable": false, "GpuTemp": 50}, start)
+			e.Eval(map[string]any{"VllmAvailable": true, "GpuTemp": 90}, start.Add(time.Second))
+			// Close waits for delivery. hot, still firing, is resolved
+			// then too.
+			e.Close()
+
+			hook.mu.Lock()
+			defer hook.mu.Unlock()
+			want := append(tt.want, "hot resolved")
+			if !slices.Equal(hook.got, want) || hook.attempts != tt.attempts+1 {
+				t.Errorf("webhook got %q in %d attempts, want %q in %d", hook.got, hook.attempts, want, tt.attempts+1)
+			}
+		})
+	}
+}
+
+func TestWebhookUnreachable(t *testing.T) {
+	withBackoff(t, 10*time.Millisecond)
+	hook := newWebhook(t)
+	url := hook.URL
+	hook.Close()
+
+	e := newEngine(t, url, utils.AlertSpec{Name: "down", Expr: "VllmAvailable == 0"})
+	start := time.Now()
+	if events := e.Eval(map[string]any{"VllmAvailable": false}, start); len(events) != 1 {
+		t.Fatalf("events %+v, want one", events)
+	}
+	// Close waits for the firing and resolved events' retries.
+	e.Close()
+	if elapsed := time.Since(start); elapsed < 2*(10+20)*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Close returned after %v, want two sets of retries", elapsed)
+	}
+}
@@ -0,0 +1,126 @@
+package alerting
+
+import (
+	"InferenceProfiler/pkg/utils"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	notifyQueue    = 64
+	notifyTimeout  = 5 * time.Second
+	notifyAttempts = 3
+)
+
+// notifyBackoff is the wait before the first retry of a failed POST; it
+// doubles for each further one.
+var notifyBackoff = time.Second
+
+// notifier logs every event and POSTs it as JSON to the webhook, one at a
+// time and in order, from its own goroutine so a slow endpoint never holds up
+// the writer. A POST that fails to connect or gets a 5xx or 429 is retried up
+// to notifyAttempts times. Events that do not fit in the queue are logged
+// only.
+type notifier struct {
+	webhook string
+	client  *http.Client
+	ch      chan Event
+	done    chan struct{}
+}
+
+func newNotifier(webhook string) *notifier {
+	n := &notifier{webhook: webhook, done: make(chan struct{})}
+	if webhook == "" {
+		close(n.done)
+		return n
+	}
+	n.client = utils.NewHTTPClient(notifyTimeout, 2*time.Second, notifyTimeout, 2)
+	n.ch = make(chan Event, notifyQueue)
+	go n.run()
+	return n
+}
+
+func (n *notifier) send(ev Event) {
+	log.Printf("alert: %s %s [%s] %s%s", ev.Rule, ev.State, ev.Severity, ev.Expr, describe(ev.Matches))
+	if n.ch == nil {
+		return
+	}
+	select {
+	case n.ch <- ev:
+	default:
+		log.Printf("alerting: webhook queue full, not posting %s %s", ev.Rule, ev.State)
+	}
+}
+
+func (n *notifier) run() {
+	defer close(n.done)
+	for ev := range n.ch {
+		n.deliver(ev)
+	}
+}
+
+func (n *notifier) deliver(ev Event) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("alerting: %s %s: %v", ev.Rule, ev.State, err)
+		return
+	}
+	backoff := notifyBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := n.post(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == notifyAttempts {
+			log.Printf("alerting: webhook %s: %s %s not delivered after %d attempts: %v", n.webhook, ev.Rule, ev.State, attempt, err)
+			return
+		}
+		utils.Debugf("alerting: webhook %s: %v, retrying in %v", n.webhook, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends one event and reports whether a failure is worth retrying.
+func (n *notifier) post(body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.webhook, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return false, nil
+}
+
+func (n *notifier) close() {
+	if n.ch != nil {
+		close(n.ch)
+	}
+	<-n.done
+}
+
+func describe(matches []utils.Match) string {
+	if len(matches) == 0 {
+		return ""
+	}
+	parts := make([]string, len(matches))
+	for i, m := range matches {
+		parts[i] = fmt.Sprintf("%s=%g", m.Field, m.Value)
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
@@ -0,0 +1,47 @@
+package alerting
+
+import (
+	"InferenceProfiler/pkg/collecting/base"
+	"InferenceProfiler/pkg/utils"
+	"time"
+)
+
+// Writer evaluates the engine's rules on every record before passing it on,
+// adding an "Alerts" section to records in which a rule fired or resolved.
+type Writer struct {
+	inner   base.Writer
+	engine  *Engine
+	pending map[string]any
+}
+
+// Wrap returns w unchanged when e is nil.
+func Wrap(w base.Writer, e *Engine) base.Writer {
+	if e == nil {
+		return w
+	}
+	return &Writer{inner: w, engine: e, pending: make(map[string]any)}
+}
+
+func (w *Writer) Static(name string, data any) error { return w.inner.Static(name, data) }
+
+func (w *Writer) Dynamic(name string, data any) error {
+	w.pending[name] = data
+	return w.inner.Dynamic(name, data)
+}
+
+func (w *Writer) Flush() error {
+	if len(w.pending) > 0 {
+		if events := w.engine.Eval(utils.Flatten(w.pending), time.Now()); len(events) > 0 {
+			w.inner.Dynamic("Alerts", events)
+		}
+		w.pending = make(map[string]any)
+	}
+	return w.inner.Flush()
+}
+
+func (w *Writer) Size() int64 { return base.SizeOf(w.inner) }
+
+func (w *Writer) Close() error {
+	w.engine.Close()
+	return w.inner.Close()
+}
@@ -0,0 +1,264 @@
+// Package client is a Go client for the infpro server API. Requests and
+// responses use the types pkg/serving encodes them from, so the two cannot
+// drift apart.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"InferenceProfiler/pkg/collecting"
+	"InferenceProfiler/pkg/serving"
+	"InferenceProfiler/pkg/utils"
+)
+
+// Client talks to one infpro server. It is safe for concurrent use.
+type Client struct {
+	base    string
+	http    *http.Client
+	token   string
+	retries int
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithToken sends token as a bearer token on every request.
+func WithToken(token string) Option { return func(c *Client) { c.token = token } }
+
+// WithHTTPClient sends requests through hc, e.g. one set up for TLS. The
+// default is http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option { return func(c *Client) { c.http = hc } }
+
+// WithRetries sets how many times DownloadFile resumes a transfer that
+// broke off (default 3).
+func WithRetries(n int) Option { return func(c *Client) { c.retries = n } }
+
+// New returns a client for the server at baseURL, e.g.
+// "http://10.0.0.5:8888".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("client: %q is not an http or https URL", baseURL)
+	}
+	c := &Client{base: strings.TrimSuffix(u.String(), "/"), http: http.DefaultClient, retries: 3}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// URL returns the server's base URL.
+func (c *Client) URL() string { return c.base }
+
+// Error is a response with a non-2xx status, carrying the server's
+// plain-text message.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// IsStatus reports whether err is an *Error with status code.
+func IsStatus(err error, code int) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == code
+}
+
+// Health checks GET /health.
+func (c *Client) Health(ctx context.Context) error {
+	return c.call(ctx, http.MethodGet, "/health", nil, nil)
+}
+
+// Snapshot polls every collector once through GET /snapshot.
+func (c *Client) Snapshot(ctx context.Context) (*serving.Snapshot, error) {
+	return do[serving.Snapshot](ctx, c, http.MethodGet, "/snapshot", nil)
+}
+
+// CollectStatus returns GET /collect: the latest run and the collectors.
+func (c *Client) CollectStatus(ctx context.Context) (*serving.CollectStatus, error) {
+	return do[serving.CollectStatus](ctx, c, http.MethodGet, "/collect", nil)
+}
+
+// StartCollect starts a run through PUT /collect, which refuses with 409
+// while another run is active.
+func (c *Client) StartCollect(ctx context.Context, spec utils.RunSpec) (*serving.RunStarted, error) {
+	return do[serving.RunStarted](ctx, c, http.MethodPut, "/collect", spec)
+}
+
+// StopCollect stops the latest running run through DELETE /collect.
+func (c *Client) StopCollect(ctx context.Context) (*serving.StateChange, error) {
+	return do[serving.StateChange](ctx, c, http.MethodDelete, "/collect", nil)
+}
+
+// Mark adds a marker through POST /collect/markers to run uuid, or to the
+// latest running run if uuid is empty.
+func (c *Client) Mark(ctx context.Context, uuid, label string) (*serving.MarkerResponse, error) {
+	req := serving.MarkerRequest{Label: label, UUID: uuid}
+	return do[serving.MarkerResponse](ctx, c, http.MethodPost, "/collect/markers", req)
+}
+
+// Runs lists the run catalogue through GET /runs.
+func (c *Client) Runs(ctx context.Context, q serving.RunQuery) (*serving.RunList, error) {
+	return do[serving.RunList](ctx, c, http.MethodGet, withQuery("/runs", q.Values()), nil)
+}
+
+// StartRun starts a run alongside any others through POST /runs.
+func (c *Client) StartRun(ctx context.Context, spec utils.RunSpec) (*serving.RunInfo, error) {
+	return do[serving.RunInfo](ctx, c, http.MethodPost, "/runs", spec)
+}
+
+// Run returns run uuid through GET /runs/{uuid}.
+func (c *Client) Run(ctx context.Context, uuid string) (*serving.RunInfo, error) {
+	return do[serving.RunInfo](ctx, c, http.MethodGet, "/runs/"+url.PathEscape(uuid), nil)
+}
+
+// StopRun stops run uuid and returns it once its output is flushed. A run
+// that has already ended gives 409.
+func (c *Client) StopRun(ctx context.Context, uuid string) (*serving.RunInfo, error) {
+	return do[serving.RunInfo](ctx, c, http.MethodPost, "/runs/"+url.PathEscape(uuid)+"/stop", nil)
+}
+
+// DeleteRun stops run uuid if needed and removes it from the catalogue,
+// keeping its files.
+func (c *Client) DeleteRun(ctx context.Context, uuid string) (*serving.StateChange, error) {
+	return do[serving.StateChange](ctx, c, http.MethodDelete, "/runs/"+url.PathEscape(uuid), nil)
+}
+
+// MarkRun adds a marker to run uuid through POST /runs/{uuid}/markers.
+func (c *Client) MarkRun(ctx context.Context, uuid, label string) (*serving.MarkerResponse, error) {
+	req := serving.MarkerRequest{Label: label}
+	return do[serving.MarkerResponse](ctx, c, http.MethodPost, "/runs/"+url.PathEscape(uuid)+"/markers", req)
+}
+
+// Collectors returns the collectors' init results and health.
+func (c *Client) Collectors(ctx context.Context) (*serving.CollectorList, error) {
+	return do[serving.CollectorList](ctx, c, http.MethodGet, "/collectors", nil)
+}
+
+// EnableCollector enables collector name.
+func (c *Client) EnableCollector(ctx context.Context, name string) (*collecting.InitResult, error) {
+	return c.collectorAction(ctx, name, "enable")
+}
+
+// DisableCollector disables collector name.
+func (c *Client) DisableCollector(ctx context.Context, name string) (*collecting.InitResult, error) {
+	return c.collectorAction(ctx, name, "disable")
+}
+
+// ReinitCollector closes and re-initializes collector name.
+func (c *Client) ReinitCollector(ctx context.Context, name string) (*collecting.InitResult, error) {
+	return c.collectorAction(ctx, name, "reinit")
+}
+
+func (c *Client) collectorAction(ctx context.Context, name, action string) (*collecting.InitResult, error) {
+	return do[collecting.InitResult](ctx, c, http.MethodPost, "/collectors/"+url.PathEscape(name)+"/"+action, nil)
+}
+
+// Alerts returns the alert rule states of run uuid, or of the latest run
+// if uuid is empty.
+func (c *Client) Alerts(ctx context.Context, uuid string) (*serving.AlertList, error) {
+	q := url.Values{}
+	if uuid != "" {
+		q.Set("uuid", uuid)
+	}
+	return do[serving.AlertList](ctx, c, http.MethodGet, withQuery("/alerts", q), nil)
+}
+
+// Files lists the output files whose names start with prefix, or all of
+// them if prefix is empty.
+func (c *Client) Files(ctx context.Context, prefix string) (*serving.FileList, error) {
+	q := url.Values{}
+	if prefix != "" {
+		q.Set("uuid", prefix)
+	}
+	return do[serving.FileList](ctx, c, http.MethodGet, withQuery("/files", q), nil)
+}
+
+// DeleteFiles deletes every file of the finished run uuid and removes it
+// from the catalogue.
+func (c *Client) DeleteFiles(ctx context.Context, uuid string) (*serving.StateChange, error) {
+	return do[serving.StateChange](ctx, c, http.MethodDelete, "/files/"+url.PathEscape(uuid), nil)
+}
+
+func withQuery(path string, q url.Values) string {
+	if len(q) == 0 {
+		return path
+	}
+	return path + "?" + q.Encode()
+}
+
+// do makes an API call and returns the response decoded as a T.
+func do[T any](ctx context.Context, c *Client, method, path string, body any) (*T, error) {
+	var out T
+	if err := c.call(ctx, method, path, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// call sends body as JSON, if not nil, and decodes the response into out,
+// if not nil.
+func (c *Client) call(ctx context.Context, method, path string, body, out any) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	resp, err := c.send(ctx, method, path, data, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("client: %s %s: decoding response: %w", method, path, err)
+	}
+	return nil
+}
+
+// send makes a request and turns non-2xx responses into an *Error. The
+// caller closes the body of the response returned.
+func (c *Client) send(ctx context.Context, method, path string, body []byte, header http.Header) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, r)
+	if err != nil {
+		return nil, err
+	}
+	for key, vals := range header {
+		req.Header[key] = vals
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return resp, &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
@@ -0,0 +1,254 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"InferenceProfiler/pkg/collecting"
+	"InferenceProfiler/pkg/serving"
+	"InferenceProfiler/pkg/utils"
+)
+
+// testServer serves the API over a manager with no collectors and records
+// the Range header of every file request. The next cuts file responses
+// break off after cutAt bytes of their body.
+type testServer struct {
+	api http.Handler
+	dir string
+
+	mu     sync.Mutex
+	ranges []string
+	cuts   int
+	cutAt  int
+}
+
+// testFile is the output file the tests download, as run "run1".
+var testFile = func() []byte {
+	var b bytes.Buffer
+	for i := range 200 {
+		fmt.Fprintf(&b, `{"Ticks":{"Seq":%d},"timestamp":%d}`+"\n", i, 1700000000000000000+i)
+	}
+	return b.Bytes()
+}()
+
+func newTestServer(t *testing.T) (*testServer, *Client) {
+	t.Helper()
+	cfg := &utils.Config{OutputDir: t.TempDir(), Interval: 100, Disabled: make(map[string]bool)}
+	if err := os.WriteFile(filepath.Join(cfg.OutputDir, "run1.jsonl"), testFile, 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := collecting.NewManager(cfg)
+	t.Cleanup(func() { m.Close() })
+
+	ts := &testServer{api: serving.NewServer(m).Handler(), dir: cfg.OutputDir}
+	hs := httptest.NewServer(ts)
+	t.Cleanup(hs.Close)
+	c, err := New(hs.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts, c
+}
+
+func (ts *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/files/") {
+		ts.api.ServeHTTP(w, r)
+		return
+	}
+	ts.mu.Lock()
+	ts.ranges = append(ts.ranges, r.Header.Get("Range"))
+	cut := ts.cuts > 0
+	if cut {
+		ts.cuts--
+	}
+	ts.mu.Unlock()
+	if !cut {
+		ts.api.ServeHTTP(w, r)
+		return
+	}
+
+	// Send the headers, Content-Length included, and only part of the
+	// body; the server then drops the connection.
+	rec := httptest.NewRecorder()
+	ts.api.ServeHTTP(rec, r)
+	maps.Copy(w.Header(), rec.Header())
+	w.WriteHeader(rec.Code)
+	w.Write(rec.Body.Bytes()[:min(ts.cutAt, rec.Body.Len())])
+}
+
+func (ts *testServer) requests() []string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return slices.Clone(ts.ranges)
+}
+
+func TestDownloadFile(t *testing.T) {
+	size := len(testFile)
+	tests := []struct {
+		name       string
+		local      []byte // copy already at the path; nil for none
+		cuts       int
+		wantRanges []string
+	}{
+		{"fresh", nil, 0, []string{""}},
+		{"resume a shorter copy", testFile[:1000], 0, []string{"bytes=1000-"}},
+		// 416 with "bytes */SIZE": nothing left to fetch.
+		{"complete copy", testFile, 0, []string{fmt.Sprintf("bytes=%d-", size)}},
+		{"longer local copy starts over", append(slices.Clone(testFile), "stale tail\n"...), 0,
+			[]string{fmt.Sprintf("bytes=%d-", size+11), ""}},
+		{"retry after a truncated body", nil, 1, []string{"", "bytes=4000-"}},
+		{"retry a truncated resume", testFile[:1000], 2, []string{"bytes=1000-", "bytes=5000-", "bytes=9000-"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, c := newTestServer(t)
+			ts.cuts, ts.cutAt = tt.cuts, 4000
+			path := filepath.Join(t.TempDir(), "run1.jsonl")
+			if tt.local != nil {
+				if err := os.WriteFile(path, tt.local, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			n, err := c.DownloadFile(context.Background(), "run1.jsonl", path, serving.FileOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != int64(size) {
+				t.Errorf("DownloadFile = %d bytes, want %d", n, size)
+			}
+			if got, _ := os.ReadFile(path); !bytes.Equal(got, testFile) {
+				t.Errorf("local copy differs from the file (%d bytes, want %d)", len(got), size)
+			}
+			if got := ts.requests(); !slices.Equal(got, tt.wantRanges) {
+				t.Errorf("Range headers %q, want %q", got, tt.wantRanges)
+			}
+		})
+	}
+}
+
+func TestDownloadFileGivesUp(t *testing.T) {
+	ts, c := newTestServer(t)
+	c.retries = 2
+	ts.cuts, ts.cutAt = 10, 1000
+	path := filepath.Join(t.TempDir(), "run1.jsonl")
+
+	n, err := c.DownloadFile(context.Background(), "run1.jsonl", path, serving.FileOptions{})
+	if err == nil {
+		t.Fatal("DownloadFile succeeded over a connection that keeps breaking")
+	}
+	if got := len(ts.requests()); got != 3 {
+		t.Errorf("%d requests, want the first and 2 retries", got)
+	}
+	// What did arrive is kept for the next attempt.
+	if got, _ := os.ReadFile(path); n != 3000 || !bytes.Equal(got, testFile[:3000]) {
+		t.Errorf("DownloadFile kept %d bytes (%d on disk), want the first 3000", n, len(got))
+	}
+}
+
+// TestDownloadFileRewritten checks that a file other than JSONL output is
+// fetched whole: the catalogue rewrites .run.json in place, so a local copy
+// is no prefix of it.
+func TestDownloadFileRewritten(t *testing.T) {
+	ts, c := newTestServer(t)
+	want, err := os.ReadFile(filepath.Join(ts.dir, "run1.run.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "run1.run.json")
+	// Earlier copies, taken while the run was going: shorter and longer.
+	for _, local := range [][]byte{bytes.Repeat([]byte("x"), len(want)/2), bytes.Repeat([]byte("x"), len(want)+10)} {
+		if err := os.WriteFile(path, local, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.DownloadFile(context.Background(), "run1.run.json", path, serving.FileOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := os.ReadFile(path); !bytes.Equal(got, want) {
+			t.Errorf("local copy %q, want %q", got, want)
+		}
+	}
+	if got := ts.requests(); !slices.Equal(got, []string{"", ""}) {
+		t.Errorf("Range headers %q, want none", got)
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	_, c := newTestServer(t)
+	ctx := context.Background()
+	size := int64(len(testFile))
+
+	tests := []struct {
+		name       string
+		offset     int64
+		wantOffset int64
+		wantBody   []byte
+	}{
+		{"whole file", 0, 0, testFile},
+		{"from an offset", 100, 100, testFile[100:]},
+		{"at the end", size, size, nil},
+		{"past the end", size + 50, size + 50, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := c.OpenFile(ctx, "run1", serving.FileOptions{}, tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Body.Close()
+			body, err := io.ReadAll(d.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Offset != tt.wantOffset || d.Size != size || !bytes.Equal(body, tt.wantBody) {
+				t.Errorf("Offset=%d Size=%d body %d bytes, want %d, %d, %d bytes",
+					d.Offset, d.Size, len(body), tt.wantOffset, size, len(tt.wantBody))
+			}
+		})
+	}
+
+	if _, err := c.OpenFile(ctx, "nope", serving.FileOptions{}, 0); !IsStatus(err, http.StatusNotFound) {
+		t.Errorf("OpenFile of a missing run: %v, want 404", err)
+	}
+}
+
+func TestClientAPI(t *testing.T) {
+	_, c := newTestServer(t)
+	ctx := context.Background()
+
+	if err := c.Health(ctx); err != nil {
+		t.Fatal(err)
+	}
+	files, err := c.Files(ctx, "run1.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files.Count != 1 || files.Files[0].Name != "run1.jsonl" || files.Files[0].Size != int64(len(testFile)) {
+		t.Errorf("Files = %+v", files)
+	}
+	runs, err := c.Runs(ctx, serving.RunQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The catalogue picks up the output file it finds at startup.
+	if runs.Total != 1 || runs.Runs[0].UUID != "run1" || runs.Runs[0].File != "run1.jsonl" {
+		t.Errorf("Runs = %+v, want run1", runs)
+	}
+	if _, err := c.StopCollect(ctx); !IsStatus(err, http.StatusConflict) {
+		t.Errorf("StopCollect without a run: %v, want 409", err)
+	}
+	if _, err := c.StartRun(ctx, utils.RunSpec{UUID: "../x"}); !IsStatus(err, http.StatusBadRequest) {
+		t.Errorf("StartRun with a bad uuid: %v, want 400", err)
+	}
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"InferenceProfiler/pkg/serving"
+)
+
+// Download is a file being streamed from GET /files/{uuid}. Body holds the
+// file from Offset on; Size is the whole file's length, or -1 when the
+// server did not say (converted downloads).
+type Download struct {
+	Body   io.ReadCloser
+	Offset int64
+	Size   int64
+}
+
+// OpenFile starts streaming the first file whose name starts with name,
+// from offset on. The server may ignore offset and send the whole file,
+// e.g. for a conversion; Offset tells where Body starts. An offset at or
+// past the end gives an empty Body. The caller closes Body; cancelling ctx
+// ends the transfer.
+func (c *Client) OpenFile(ctx context.Context, name string, opts serving.FileOptions, offset int64) (*Download, error) {
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := c.send(ctx, http.MethodGet, withQuery("/files/"+url.PathEscape(name), opts.Values()), nil, header)
+	if IsStatus(err, http.StatusRequestedRangeNotSatisfiable) {
+		// "bytes */SIZE": nothing left from offset on.
+		_, size := contentRange(resp.Header.Get("Content-Range"))
+		return &Download{Body: http.NoBody, Offset: offset, Size: size}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d := &Download{Body: resp.Body, Size: resp.ContentLength}
+	if resp.StatusCode == http.StatusPartialContent {
+		d.Offset, d.Size = contentRange(resp.Header.Get("Content-Range"))
+	}
+	return d, nil
+}
+
+// contentRange parses "bytes START-END/SIZE" or "bytes */SIZE" into START
+// (0 for the latter) and SIZE (-1 if unknown).
+func contentRange(s string) (int64, int64) {
+	spec, ok := strings.CutPrefix(s, "bytes ")
+	if !ok {
+		return 0, -1
+	}
+	rng, total, _ := strings.Cut(spec, "/")
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		size = -1
+	}
+	first, _, _ := strings.Cut(rng, "-")
+	start, _ := strconv.ParseInt(first, 10, 64)
+	return start, size
+}
+
+// Download streams a file to w, as OpenFile with offset 0, and returns the
+// bytes written.
+func (c *Client) Download(ctx context.Context, name string, opts serving.FileOptions, w io.Writer) (int64, error) {
+	d, err := c.OpenFile(ctx, name, opts, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer d.Body.Close()
+	return io.Copy(w, d.Body)
+}
+
+// DownloadFile saves a file to path and returns its size. For JSONL output
+// (a name ending in .jsonl) a shorter copy already at path is taken as an
+// earlier, interrupted download and resumed with a Range request, as is a
+// transfer that breaks off, up to the client's retries; a complete copy
+// costs one request. Resuming relies on output files only ever growing, so
+// other files, such as the .run.json the catalogue rewrites in place, and
+// converted downloads always start over.
+func (c *Client) DownloadFile(ctx context.Context, name, path string, opts serving.FileOptions) (int64, error) {
+	resume := !opts.Converted() && strings.HasSuffix(name, ".jsonl")
+	flags := os.O_WRONLY | os.O_CREATE
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	offset := st.Size()
+	for attempt := 0; ; attempt++ {
+		if !resume {
+			offset = 0
+		}
+		n, err := c.downloadFrom(ctx, f, name, opts, offset)
+		offset = n
+		if err == nil {
+			return offset, f.Close()
+		}
+		var status *Error
+		if ctx.Err() != nil || errors.As(err, &status) || attempt >= c.retries {
+			return offset, err
+		}
+	}
+}
+
+// downloadFrom writes the file from offset on into f and returns how much
+// of it f then holds.
+func (c *Client) downloadFrom(ctx context.Context, f *os.File, name string, opts serving.FileOptions, offset int64) (int64, error) {
+	d, err := c.OpenFile(ctx, name, opts, offset)
+	if err != nil {
+		return offset, err
+	}
+	defer d.Body.Close()
+	if d.Size >= 0 && offset > d.Size {
+		// The local copy is longer than the file: not an earlier
+		// download of it. Start over.
+		d.Body.Close()
+		if d, err = c.OpenFile(ctx, name, opts, 0); err != nil {
+			return 0, err
+		}
+		defer d.Body.Close()
+	}
+	if err := f.Truncate(d.Offset); err != nil {
+		return offset, err
+	}
+	if _, err := f.Seek(d.Offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+	n, err := io.Copy(f, d.Body)
+	return d.Offset + n, err
+}
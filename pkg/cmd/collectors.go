@@ -0,0 +1,13 @@
+package cmd
+
+// Collectors register themselves with pkg/collecting when imported; this is
+// the list built into infpro.
+import (
+	_ "InferenceProfiler/pkg/collecting/container"
+	_ "InferenceProfiler/pkg/collecting/exec"
+	_ "InferenceProfiler/pkg/collecting/ntp"
+	_ "InferenceProfiler/pkg/collecting/nvidia"
+	_ "InferenceProfiler/pkg/collecting/process"
+	_ "InferenceProfiler/pkg/collecting/vllm"
+	_ "InferenceProfiler/pkg/collecting/vm"
+)
@@ -0,0 +1,343 @@
+package cmd
+
+import (
+	"InferenceProfiler/pkg/controlling"
+	"InferenceProfiler/pkg/serving"
+	"InferenceProfiler/pkg/utils"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// controllerActions are the controller's subcommands.
+var controllerActions = []string{"start", "stop", "status", "collect", "run"}
+
+// controllerConfig holds the controller's flags.
+type controllerConfig struct {
+	action    string
+	hosts     string
+	hostsFile string
+	port      int
+	uuid      string
+	spec      string
+	output    string
+	format    string
+	token     string
+	tlsCA     string
+	tlsCert   string
+	tlsKey    string
+	timeout   time.Duration
+	poll      time.Duration
+	partial   bool
+	watch     bool
+	json      bool
+}
+
+// runController drives several infpro servers: infpro controller ACTION
+// [flags]. It exits 1 if any host failed.
+func runController(args []string) {
+	cc := parseControllerArgs(args)
+
+	hosts, err := controllerHosts(cc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err := controllerClient(cc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fleet := controlling.New(hosts, client, cc.token, cc.timeout)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var results []controlling.Result
+	switch cc.action {
+	case "start":
+		results = startFleet(ctx, fleet, cc)
+	case "stop":
+		results = fleet.Stop(ctx, cc.uuid)
+	case "status":
+		results = fleet.Status(ctx, cc.uuid)
+		for cc.watch && running(results) > 0 {
+			printResults(results, cc)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(cc.poll):
+			}
+			results = fleet.Status(ctx, cc.uuid)
+		}
+	case "collect":
+		results = fleet.Collect(ctx, cc.uuid, cc.output, cc.format)
+	case "run":
+		results = startFleet(ctx, fleet, cc)
+		if failed(results) && !cc.partial {
+			break
+		}
+		started := fleet.With(controlling.Succeeded(results))
+		waitForFleet(ctx, started, cc)
+		// Ctrl+C ended the wait; a second one should not cut the stop and
+		// collect short.
+		stop()
+		stopped := started.Stop(context.Background(), cc.uuid)
+		printResults(stopped, cc)
+		collected := started.With(controlling.Succeeded(stopped)).Collect(context.Background(), cc.uuid, cc.output, cc.format)
+		// Hosts that failed earlier stay in the report.
+		results = append(collected, append(failures(results), failures(stopped)...)...)
+	}
+
+	printResults(results, cc)
+	if failed(results) {
+		os.Exit(1)
+	}
+}
+
+func parseControllerArgs(args []string) *controllerConfig {
+	cc := &controllerConfig{}
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		log.Fatalf("controller: missing action (%s)", strings.Join(controllerActions, "|"))
+	}
+	cc.action, args = strings.ToLower(args[0]), args[1:]
+
+	fs := flag.NewFlagSet("InferenceProfiler controller", flag.ExitOnError)
+	fs.StringVar(&cc.hosts, "hosts", "", "Comma-separated infpro servers: host, host:port or URL")
+	fs.StringVar(&cc.hostsFile, "hosts-file", "", "File with one infpro server per line")
+	fs.IntVar(&cc.port, "port", 8888, "Port for hosts given without one")
+	fs.StringVar(&cc.uuid, "uuid", "", "Run UUID (start/run: default random)")
+	fs.StringVar(&cc.spec, "spec", "", "JSON run spec file sent to every host on start")
+	fs.StringVar(&cc.output, "output", ".", "Directory files are collected into, one subdirectory per host")
+	fs.StringVar(&cc.format, "format", "", "Collect JSONL output converted to flat-jsonl, csv or parquet")
+	fs.StringVar(&cc.token, "token", "", "Bearer token sent to every host")
+	fs.StringVar(&cc.tlsCA, "tls-ca", "", "Verify the hosts' HTTPS certificates against this PEM CA")
+	fs.StringVar(&cc.tlsCert, "tls-cert", "", "PEM client certificate for hosts requiring mTLS (needs -tls-key)")
+	fs.StringVar(&cc.tlsKey, "tls-key", "", "PEM private key for -tls-cert")
+	fs.DurationVar(&cc.timeout, "timeout", 30*time.Second, "Deadline for each API call (downloads have none)")
+	fs.DurationVar(&cc.poll, "poll", 5*time.Second, "Status interval for run and status -watch")
+	fs.BoolVar(&cc.partial, "partial", false, "Keep going when some hosts fail instead of stopping the others")
+	fs.BoolVar(&cc.watch, "watch", false, "status: repeat until no host is running the run")
+	fs.BoolVar(&cc.json, "json", false, "Print results as JSON")
+	if err := fs.Parse(args); err != nil {
+		log.Fatalf("Failed to parse args: %v", err)
+	}
+	utils.ApplyEnv(fs)
+
+	switch cc.action {
+	case "start", "run", "status":
+	case "stop", "collect":
+		if cc.uuid == "" {
+			log.Fatalf("controller %s: -uuid is required", cc.action)
+		}
+	default:
+		log.Fatalf("controller: unknown action %q (must be %s)", cc.action, strings.Join(controllerActions, "|"))
+	}
+	if cc.format != "" && cc.format != serving.FormatJSONL && cc.format != serving.FormatFlatJSONL &&
+		cc.format != serving.FormatCSV && cc.format != serving.FormatParquet {
+		log.Fatalf("controller: unknown -format %q (must be jsonl, flat-jsonl, csv or parquet)", cc.format)
+	}
+	if (cc.tlsCert == "") != (cc.tlsKey == "") {
+		log.Fatal("controller: -tls-cert and -tls-key go together")
+	}
+	return cc
+}
+
+func controllerHosts(cc *controllerConfig) ([]controlling.Host, error) {
+	entries := strings.Split(cc.hosts, ",")
+	if cc.hostsFile != "" {
+		more, err := controlling.ReadHostsFile(cc.hostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("controller: %w", err)
+		}
+		entries = append(entries, more...)
+	}
+	scheme := "http"
+	if cc.tlsCA != "" || cc.tlsCert != "" {
+		scheme = "https"
+	}
+	hosts, err := controlling.ParseHosts(entries, cc.port, scheme)
+	if err != nil {
+		return nil, fmt.Errorf("controller: %w", err)
+	}
+	return hosts, nil
+}
+
+// controllerClient returns an HTTP client trusting -tls-ca and presenting
+// -tls-cert, if set.
+func controllerClient(cc *controllerConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cc.tlsCA != "" || cc.tlsCert != "" {
+		tc := &tls.Config{MinVersion: tls.VersionTLS12}
+		if cc.tlsCA != "" {
+			pem, err := os.ReadFile(cc.tlsCA)
+			if err != nil {
+				return nil, fmt.Errorf("controller: %w", err)
+			}
+			tc.RootCAs = x509.NewCertPool()
+			if !tc.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("controller: no certificates in %s", cc.tlsCA)
+			}
+		}
+		if cc.tlsCert != "" {
+			cert, err := tls.LoadX509KeyPair(cc.tlsCert, cc.tlsKey)
+			if err != nil {
+				return nil, fmt.Errorf("controller: %w", err)
+			}
+			tc.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tc
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// startFleet starts the run on every host. Unless -partial is set, a host
+// refusing stops the run on the others, so either all hosts record it or
+// none do.
+func startFleet(ctx context.Context, fleet *controlling.Fleet, cc *controllerConfig) []controlling.Result {
+	var spec utils.RunSpec
+	if cc.spec != "" {
+		data, err := os.ReadFile(cc.spec)
+		if err != nil {
+			log.Fatalf("controller: %v", err)
+		}
+		if err := json.Unmarshal(data, &spec); err != nil {
+			log.Fatalf("controller: %s: %v", cc.spec, err)
+		}
+	}
+	switch {
+	case cc.uuid != "":
+		spec.UUID = cc.uuid
+	case spec.UUID == "":
+		spec.UUID = utils.GenerateUUID()
+	}
+	cc.uuid = spec.UUID
+
+	log.Printf("controller: starting run %s on %d hosts", cc.uuid, len(fleet.Hosts))
+	if cc.partial {
+		return fleet.Start(ctx, spec)
+	}
+	results := fleet.StartAll(ctx, spec)
+	if failed(results) {
+		log.Printf("controller: %d of %d hosts failed to start, stopped the run on every host", len(failures(results)), len(results))
+	}
+	return results
+}
+
+// waitForFleet logs the run's progress every -poll until every host has
+// ended it or ctx is done.
+func waitForFleet(ctx context.Context, fleet *controlling.Fleet, cc *controllerConfig) {
+	log.Printf("controller: run %s started, press Ctrl+C to stop", cc.uuid)
+	ticker := time.NewTicker(cc.poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		results := fleet.Status(ctx, cc.uuid)
+		var records, bytes int64
+		for _, r := range results {
+			if r.Run != nil {
+				records += r.Run.Records
+				bytes += r.Run.Bytes
+			}
+		}
+		n := running(results)
+		log.Printf("controller: %d/%d hosts running, %d records, %s", n, len(results), records, utils.FormatSize(bytes))
+		if n == 0 && len(controlling.Succeeded(results)) == len(results) {
+			log.Printf("controller: run %s ended on every host", cc.uuid)
+			return
+		}
+	}
+}
+
+func running(results []controlling.Result) int {
+	n := 0
+	for _, r := range results {
+		if r.Run != nil && r.Run.State == serving.RunRunning {
+			n++
+		}
+	}
+	return n
+}
+
+func failed(results []controlling.Result) bool {
+	return len(controlling.Succeeded(results)) < len(results)
+}
+
+func failures(results []controlling.Result) []controlling.Result {
+	var out []controlling.Result
+	for _, r := range results {
+		if r.Failed() {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// printResults writes one line per host and a summary, or the results as
+// JSON with -json.
+func printResults(results []controlling.Result, cc *controllerConfig) {
+	if cc.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tSTATE\tUUID\tRECORDS\tBYTES\tELAPSED\tNOTE")
+	var records, bytes int64
+	var bad []string
+	files := 0
+	for _, r := range results {
+		files += len(r.Files)
+		state, uuid, count, size, elapsed, note := "-", "-", "-", "-", "-", ""
+		if run := r.Run; run != nil {
+			state, uuid, elapsed = run.State, run.UUID, run.Elapsed
+			count, size = fmt.Sprint(run.Records), utils.FormatSize(run.Bytes)
+			records += run.Records
+			bytes += run.Bytes
+			switch {
+			case run.Error != "":
+				note = run.Error
+			case run.Reason != "":
+				note = "stopped by " + run.Reason
+			}
+		} else if !r.Failed() && cc.action == "status" {
+			state = "idle"
+		}
+		switch {
+		case r.Failed():
+			state, note = "FAILED", r.Error
+			bad = append(bad, r.Host.Name)
+		case len(r.Files) > 0:
+			note = fmt.Sprintf("%d files", len(r.Files))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Host.Name, state, uuid, count, size, elapsed, note)
+	}
+	tw.Flush()
+
+	fmt.Printf("%d/%d hosts ok", len(results)-len(bad), len(results))
+	if records > 0 {
+		fmt.Printf(", %d running, %d records, %s", running(results), records, utils.FormatSize(bytes))
+	}
+	if files > 0 {
+		fmt.Printf(", %d files in %s", files, cc.output)
+	}
+	if len(bad) > 0 {
+		fmt.Printf("; failed: %s", strings.Join(bad, ", "))
+	}
+	fmt.Println()
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"InferenceProfiler/pkg/collecting"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// markOnSignal adds a marker labelled signal-N to r on every SIGUSR1.
+func markOnSignal(ctx context.Context, r *collecting.Run) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	defer signal.Stop(sig)
+	for n := 1; ; n++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			mark(ctx, r, fmt.Sprintf("signal-%d", n))
+		}
+	}
+}
+
+// markFromFIFO creates a FIFO at path, unless one is there already, and adds
+// a marker to r for every line written to it, labelled with the line. The
+// FIFO is opened read-write so it stays open between writers.
+func markFromFIFO(ctx context.Context, r *collecting.Run, path string) {
+	created := false
+	if err := syscall.Mkfifo(path, 0600); err == nil {
+		created = true
+	} else if !errors.Is(err, fs.ErrExist) {
+		log.Printf("markers: %v", err)
+		return
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode()&fs.ModeNamedPipe == 0 {
+		log.Printf("markers: %s is not a FIFO", path)
+		return
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		log.Printf("markers: %v", err)
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		f.Close()
+		if created {
+			os.Remove(path)
+		}
+	}()
+	log.Printf("markers: reading labels from %s", path)
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		label := strings.TrimSpace(sc.Text())
+		if label == "" {
+			label = fmt.Sprintf("fifo-%d", n)
+		}
+		mark(ctx, r, label)
+	}
+}
+
+func mark(ctx context.Context, r *collecting.Run, label string) {
+	m, err := r.Mark(ctx, label)
+	if err != nil {
+		log.Printf("markers: %s: %v", label, err)
+		return
+	}
+	log.Printf("markers: %s at tick %d", m.Label, m.Seq)
+}
@@ -1,21 +1,30 @@
 package cmd
 
 import (
+	"InferenceProfiler/pkg/alerting"
 	"InferenceProfiler/pkg/collecting"
 	"InferenceProfiler/pkg/serving"
+	"InferenceProfiler/pkg/triggering"
 	"InferenceProfiler/pkg/utils"
 	"context"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 )
 
 func Run(args []string) {
-	cfg := utils.ParseArgs(args)
+	if len(args) > 0 && (args[0] == "controller" || args[0] == "ctl") {
+		runController(args[1:])
+		return
+	}
+	cfg := utils.ParseArgs(args, collecting.Specs())
 
 	if cfg.Pprof != "" {
 		go func() {
@@ -27,9 +36,10 @@ func Run(args []string) {
 	}
 
 	manager := collecting.NewManager(cfg)
+	defer manager.Close()
 	switch cfg.Mode {
 	case "server":
-		runServer(manager, cfg.ServerPort)
+		runServer(manager, cfg)
 	case "snapshot":
 		runSnapshot(manager, cfg)
 	default:
@@ -37,18 +47,23 @@ func Run(args []string) {
 	}
 }
 
-func runServer(manager *collecting.Manager, port int) {
+func runServer(manager *collecting.Manager, cfg *utils.Config) {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
 	server := serving.NewServer(manager)
 
 	go func() {
-		if err := server.ListenAndServe(port); err != nil {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatal(err)
 		}
 	}()
-	log.Printf("server: listening on 0.0.0.0:%d", port)
+	scheme := "http"
+	if cfg.TLSCert != "" {
+		scheme = "https"
+	}
+	log.Printf("server: listening on %s://%s (auth=%v mtls=%v)", scheme,
+		net.JoinHostPort(cfg.Bind, strconv.Itoa(cfg.ServerPort)), cfg.Token != "" || cfg.ReadToken != "", cfg.TLSClientCA != "")
 
 	<-ctx.Done()
 	log.Println("shutting down...")
@@ -70,10 +85,20 @@ func runContinuous(manager *collecting.Manager, cfg *utils.Config) {
 		cancel()
 	}()
 
-	w := utils.NewWriter(cfg)
+	r, err := manager.Acquire(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := alerting.Wrap(triggering.Wrap(utils.NewWriter(cfg), cfg), alerting.New(cfg))
 	defer w.Close()
 
-	manager.Continuous(ctx, w)
+	go markOnSignal(ctx, r)
+	if cfg.MarkerFIFO != "" {
+		go markFromFIFO(ctx, r, cfg.MarkerFIFO)
+	}
+
+	manager.Record(ctx, w, r)
 }
 
 func runSnapshot(manager *collecting.Manager, cfg *utils.Config) {
@@ -12,6 +12,20 @@ type Writer interface {
 	Close() error
 }
 
+// Sizer is implemented by writers that track how many bytes they have
+// written.
+type Sizer interface {
+	Size() int64
+}
+
+// SizeOf returns the bytes w has written, or 0 if it does not track them.
+func SizeOf(w Writer) int64 {
+	if s, ok := w.(Sizer); ok {
+		return s.Size()
+	}
+	return 0
+}
+
 type Collector interface {
 	Name() string
 	Init(cfg *utils.Config) error
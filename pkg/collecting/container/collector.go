@@ -1,6 +1,7 @@
 package container
 
 import (
+	"InferenceProfiler/pkg/collecting"
 	"InferenceProfiler/pkg/collecting/base"
 	"InferenceProfiler/pkg/utils"
 	"context"
@@ -36,6 +37,18 @@ type Collector struct {
 
 func New() *Collector { return &Collector{} }
 
+func init() {
+	collecting.Register(collecting.Registration{
+		CollectorSpec: utils.CollectorSpec{
+			Name:        "container",
+			Description: "container/cgroup metrics",
+			Default:     true,
+		},
+		New:   func() base.Collector { return New() },
+		Order: 20,
+	})
+}
+
 func (c *Collector) Name() string { return "Container" }
 
 func (c *Collector) Init(_ *utils.Config) error {
@@ -0,0 +1,282 @@
+// Package exec runs external commands and reports their output as a section,
+// keyed by command name, for probes that have no collector of their own.
+package exec
+
+import (
+	"InferenceProfiler/pkg/collecting"
+	"InferenceProfiler/pkg/collecting/base"
+	"InferenceProfiler/pkg/utils"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	osexec "os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	modeInterval = "interval"
+	modeStream   = "stream"
+
+	maxStderr = 1024
+)
+
+// Options are the exec collector's flags.
+type Options struct {
+	Commands  []string `flag:"exec-command" arg:"NAME=CMD" json:"commands" help:"Command run through sh -c, its output under NAME in the section (repeatable)"`
+	Section   string   `flag:"exec-name" arg:"NAME" json:"name" help:"Section name for the commands' output"`
+	Mode      string   `flag:"exec-mode" arg:"MODE" json:"mode" help:"interval runs the commands every poll; stream keeps one child per command running and reads a JSON or key=value record per line"`
+	Format    string   `flag:"exec-format" arg:"FMT" json:"format" help:"Output format: auto|json|kv"`
+	Timeout   int      `flag:"exec-timeout" arg:"MS" json:"timeout" help:"Kill a command if it runs longer than this (interval mode)"`
+	MaxOutput int      `flag:"exec-max-output" arg:"BYTES" json:"max_output" help:"Largest output (interval mode) or line (stream mode) accepted"`
+}
+
+// commandName is what NAME in -exec-command NAME=CMD may be.
+var commandName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// command is one -exec-command, with its child in stream mode.
+type command struct {
+	name   string
+	line   string
+	stream *stream
+}
+
+type Collector struct {
+	opts     Options
+	commands []*command
+}
+
+func New() *Collector { return &Collector{} }
+
+func init() {
+	collecting.Register(collecting.Registration{
+		CollectorSpec: utils.CollectorSpec{
+			Name:        "exec",
+			Description: "external command collector (needs -exec-command)",
+			Default:     false,
+			Options: func() any {
+				return &Options{
+					Section:   "Exec",
+					Mode:      modeInterval,
+					Format:    formatAuto,
+					Timeout:   5000,
+					MaxOutput: 1 << 20,
+				}
+			},
+		},
+		New:   func() base.Collector { return New() },
+		Order: 60,
+	})
+}
+
+// Name is the configured section name once Init has run.
+func (c *Collector) Name() string {
+	if c.opts.Section != "" {
+		return c.opts.Section
+	}
+	return "Exec"
+}
+
+func (c *Collector) Init(cfg *utils.Config) error {
+	c.opts = *utils.CollectorOptions[Options](cfg, "exec")
+	commands, err := parseCommands(c.opts.Commands)
+	if err != nil {
+		return fmt.Errorf("exec: %w", err)
+	}
+	if c.opts.MaxOutput <= 0 {
+		return fmt.Errorf("exec: invalid max output %d", c.opts.MaxOutput)
+	}
+	switch c.opts.Format {
+	case formatAuto, formatJSON, formatKV:
+	default:
+		return fmt.Errorf("exec: unknown format %q", c.opts.Format)
+	}
+
+	switch c.opts.Mode {
+	case modeInterval:
+	case modeStream:
+		for _, cmd := range commands {
+			cmd.stream = newStream(c.opts, cmd.line)
+			if err := cmd.stream.start(); err != nil {
+				for _, started := range commands {
+					if started.stream != nil {
+						started.stream.stop()
+					}
+				}
+				return fmt.Errorf("exec: %s: %w", cmd.name, err)
+			}
+		}
+	default:
+		return fmt.Errorf("exec: unknown mode %q", c.opts.Mode)
+	}
+
+	c.commands = commands
+	for _, cmd := range commands {
+		log.Printf("exec: %s.%s mode=%s format=%s command=%q", c.Name(), cmd.name, c.opts.Mode, c.opts.Format, cmd.line)
+	}
+	return nil
+}
+
+// parseCommands splits -exec-command values into their names and command
+// lines.
+func parseCommands(values []string) ([]*command, error) {
+	if len(values) == 0 {
+		return nil, errors.New("no command configured (-exec-command NAME=CMD)")
+	}
+	var commands []*command
+	seen := make(map[string]bool)
+	for _, v := range values {
+		name, line, ok := strings.Cut(v, "=")
+		line = strings.TrimSpace(line)
+		switch {
+		case !ok || !commandName.MatchString(name) || line == "":
+			return nil, fmt.Errorf("expected NAME=CMD with NAME of letters, digits, _ or -, got %q", v)
+		case seen[name]:
+			return nil, fmt.Errorf("command name %q used twice", name)
+		}
+		seen[name] = true
+		commands = append(commands, &command{name: name, line: line})
+	}
+	return commands, nil
+}
+
+func (c *Collector) Static() any { return nil }
+
+// Poll returns each command's record under its name. In interval mode the
+// commands run side by side; in stream mode commands with no new line since
+// the previous poll are left out, and the section with them if none has one.
+func (c *Collector) Poll(ctx context.Context) any {
+	out := make(map[string]any, len(c.commands))
+	if c.opts.Mode == modeStream {
+		for _, cmd := range c.commands {
+			if rec := cmd.stream.poll(); rec != nil {
+				out[cmd.name] = rec
+			}
+		}
+		if len(out) == 0 {
+			return nil
+		}
+		return out
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, cmd := range c.commands {
+		wg.Go(func() {
+			rec := c.run(ctx, cmd)
+			mu.Lock()
+			out[cmd.name] = rec
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+	return out
+}
+
+// run executes a command once and returns its parsed output, or a record
+// holding only the error so failures stay visible in the stream.
+func (c *Collector) run(pollCtx context.Context, command *command) map[string]any {
+	ctx := pollCtx
+	if c.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(c.opts.Timeout)*time.Millisecond)
+		defer cancel()
+	}
+
+	cmd := newCommand(ctx, command.line)
+	var stderr bytes.Buffer
+	cmd.Stderr = &limitWriter{w: &stderr, n: maxStderr}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return c.failure(command, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return c.failure(command, err)
+	}
+
+	out, readErr := io.ReadAll(io.LimitReader(stdout, int64(c.opts.MaxOutput)+1))
+	if len(out) > c.opts.MaxOutput {
+		killGroup(cmd)
+		cmd.Wait()
+		return c.failure(command, fmt.Errorf("output exceeds %d bytes (-exec-max-output)", c.opts.MaxOutput))
+	}
+	waitErr := cmd.Wait()
+
+	// Whichever deadline fired killed the command; say which.
+	switch {
+	case errors.Is(pollCtx.Err(), context.DeadlineExceeded):
+		return c.failure(command, errors.New("killed at the poll deadline (-poll-timeout)"))
+	case pollCtx.Err() != nil:
+		return c.failure(command, errors.New("killed: poll cancelled"))
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return c.failure(command, fmt.Errorf("timed out after %dms (-exec-timeout)", c.opts.Timeout))
+	case waitErr != nil:
+		return c.failure(command, fmt.Errorf("%w: %s", waitErr, bytes.TrimSpace(stderr.Bytes())))
+	case readErr != nil:
+		return c.failure(command, readErr)
+	}
+
+	fields, err := parseOutput(out, c.opts.Format)
+	if err != nil {
+		return c.failure(command, err)
+	}
+	return withTimestamp(fields)
+}
+
+func (c *Collector) failure(command *command, err error) map[string]any {
+	utils.Debugf("exec: %s.%s: %v", c.Name(), command.name, err)
+	return map[string]any{"Error": err.Error(), "T": utils.GetTimestamp()}
+}
+
+// withTimestamp adds the read time under T unless the command set its own.
+func withTimestamp(fields map[string]any) map[string]any {
+	if _, ok := fields["T"]; !ok {
+		fields["T"] = utils.GetTimestamp()
+	}
+	return fields
+}
+
+func (c *Collector) Close() error {
+	for _, cmd := range c.commands {
+		if cmd.stream != nil {
+			cmd.stream.stop()
+		}
+	}
+	return nil
+}
+
+// newCommand runs command through sh in its own process group, so a timeout
+// or Close also kills anything the command spawned and does not leave a
+// grandchild holding stdout open.
+func newCommand(ctx context.Context, command string) *osexec.Cmd {
+	cmd := osexec.CommandContext(ctx, "sh", "-c", command)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return killGroup(cmd) }
+	cmd.WaitDelay = time.Second
+	return cmd
+}
+
+func killGroup(cmd *osexec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// limitWriter keeps the first n bytes written and discards the rest.
+type limitWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if l.n > 0 {
+		k := min(len(p), l.n)
+		l.w.Write(p[:k])
+		l.n -= k
+	}
+	return len(p), nil
+}
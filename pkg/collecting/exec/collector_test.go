@@ -0,0 +1,172 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"InferenceProfiler/pkg/utils"
+)
+
+// newTestCollector initialises a collector with the registered defaults
+// changed by set.
+func newTestCollector(t *testing.T, set func(*Options)) *Collector {
+	t.Helper()
+	opts := &Options{Section: "Exec", Mode: modeInterval, Format: formatAuto, Timeout: 5000, MaxOutput: 1 << 20}
+	set(opts)
+	c := New()
+	if err := c.Init(&utils.Config{Options: map[string]any{"exec": opts}}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// record returns the record of command name in a poll's section.
+func record(t *testing.T, section any, name string) map[string]any {
+	t.Helper()
+	m, _ := section.(map[string]any)
+	rec, ok := m[name].(map[string]any)
+	if !ok {
+		t.Fatalf("section %v has no record %s", section, name)
+	}
+	return rec
+}
+
+// alive reports whether pid is a process that has not exited; zombies
+// waiting for a reaper count as exited.
+func alive(pid int) bool {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// The state follows the parenthesised command name.
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestPollKeysByCommand(t *testing.T) {
+	c := newTestCollector(t, func(o *Options) {
+		o.Commands = []string{"Nic=echo rx=1 tx=2", `Meter=echo '{"watts": 250, "T": 7}'`, "Broken=exit 3"}
+	})
+	section := c.Poll(context.Background())
+
+	if nic := record(t, section, "Nic"); nic["rx"] != 1.0 || nic["tx"] != 2.0 || nic["T"] == nil {
+		t.Errorf("Nic = %v", nic)
+	}
+	if meter := record(t, section, "Meter"); meter["watts"] != 250.0 || meter["T"] != 7.0 {
+		t.Errorf("Meter = %v, want the command's own T", meter)
+	}
+	if broken := record(t, section, "Broken"); !strings.Contains(fmt.Sprint(broken["Error"]), "exit status 3") {
+		t.Errorf("Broken = %v, want its exit status", broken)
+	}
+}
+
+func TestRunTimeoutKillsGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	tests := []struct {
+		name      string
+		timeout   int
+		pollAfter time.Duration // 0: no poll deadline
+		want      string
+	}{
+		{"exec timeout", 200, 0, "timed out after 200ms (-exec-timeout)"},
+		{"poll deadline", 10000, 200 * time.Millisecond, "killed at the poll deadline (-poll-timeout)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The grandchild holds stdout open; only killing the group
+			// ends the read.
+			c := newTestCollector(t, func(o *Options) {
+				o.Commands = []string{"Slow=sleep 30 & echo $! >" + pidFile + "; wait"}
+				o.Timeout = tt.timeout
+			})
+			ctx := context.Background()
+			if tt.pollAfter > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.pollAfter)
+				defer cancel()
+			}
+
+			start := time.Now()
+			rec := record(t, c.Poll(ctx), "Slow")
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("poll took %v", elapsed)
+			}
+			if rec["Error"] != tt.want {
+				t.Errorf("Error = %v, want %q", rec["Error"], tt.want)
+			}
+			data, err := os.ReadFile(pidFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+			for range 50 {
+				if !alive(pid) {
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			t.Errorf("grandchild %d survived the kill", pid)
+		})
+	}
+}
+
+func TestRunMaxOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    string // Error, or "" for a record
+	}{
+		{"at the limit", "printf a=1234567", ""},
+		{"one byte over", "printf a=12345678", "output exceeds 9 bytes (-exec-max-output)"},
+		// Killed once the limit is read, not left running.
+		{"endless", "yes a=1", "output exceeds 9 bytes (-exec-max-output)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCollector(t, func(o *Options) {
+				o.Commands = []string{"Out=" + tt.command}
+				o.MaxOutput = 9
+			})
+			rec := record(t, c.Poll(context.Background()), "Out")
+			if tt.want == "" {
+				if rec["Error"] != nil || rec["a"] == nil {
+					t.Errorf("record = %v, want a", rec)
+				}
+				return
+			}
+			if rec["Error"] != tt.want || len(rec) != 2 {
+				t.Errorf("record = %v, want only Error %q and T", rec, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamKeysByCommand(t *testing.T) {
+	c := newTestCollector(t, func(o *Options) {
+		o.Mode = modeStream
+		o.Commands = []string{"A=echo a=1; sleep 30", "B=sleep 0.2; echo b=2; sleep 30"}
+	})
+	got := make(map[string]any)
+	for deadline := time.Now().Add(3 * time.Second); len(got) < 2 && time.Now().Before(deadline); {
+		if section, ok := c.Poll(context.Background()).(map[string]any); ok {
+			for name, rec := range section {
+				got[name] = rec
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if record(t, got, "A")["a"] != 1.0 || record(t, got, "B")["b"] != 2.0 {
+		t.Errorf("records = %v", got)
+	}
+	// Nothing new since: no section.
+	if section := c.Poll(context.Background()); section != nil {
+		t.Errorf("poll without new lines = %v, want nil", section)
+	}
+}
@@ -0,0 +1,72 @@
+package exec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatAuto = "auto"
+	formatJSON = "json"
+	formatKV   = "kv"
+)
+
+// parseOutput turns command output into section fields. JSON output must be
+// a single object; key=value output may put pairs on separate lines or
+// several per line separated by whitespace. Numeric values become float64.
+func parseOutput(out []byte, format string) (map[string]any, error) {
+	out = bytes.TrimSpace(out)
+	if len(out) == 0 {
+		return nil, fmt.Errorf("empty output")
+	}
+	if format == formatAuto {
+		format = formatKV
+		if out[0] == '{' {
+			format = formatJSON
+		}
+	}
+
+	switch format {
+	case formatJSON:
+		var m map[string]any
+		if err := json.Unmarshal(out, &m); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return m, nil
+	case formatKV:
+		return parseKV(out)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func parseKV(out []byte) (map[string]any, error) {
+	m := make(map[string]any)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(nil, len(out)+1)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, pair := range strings.Fields(line) {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok || key == "" {
+				return nil, fmt.Errorf("expected key=value, got %q", pair)
+			}
+			m[key] = kvValue(value)
+		}
+	}
+	return m, scanner.Err()
+}
+
+func kvValue(s string) any {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
@@ -0,0 +1,86 @@
+package exec
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		out    string
+		format string
+		want   map[string]any // nil: an error
+	}{
+		{"auto picks JSON", ` {"rx": 1, "dev": "eth0"}` + "\n", formatAuto, map[string]any{"rx": 1.0, "dev": "eth0"}},
+		{"auto picks kv", "rx=1 tx=2.5\n", formatAuto, map[string]any{"rx": 1.0, "tx": 2.5}},
+		{"kv over lines with comments", "# counters\nrx=1\n\n  tx=-2  state=up\n", formatKV,
+			map[string]any{"rx": 1.0, "tx": -2.0, "state": "up"}},
+		{"kv keeps the last of a key", "a=1 a=2", formatKV, map[string]any{"a": 2.0}},
+		{"kv value with =", "q=a=b", formatKV, map[string]any{"q": "a=b"}},
+		{"kv empty value", "a=", formatKV, map[string]any{"a": ""}},
+		{"kv without =", "rx=1 oops", formatKV, nil},
+		{"kv without key", "=1", formatKV, nil},
+		{"JSON must be an object", "[1,2]", formatJSON, nil},
+		{"invalid JSON", `{"a":`, formatAuto, nil},
+		{"kv read as JSON", "a=1", formatJSON, nil},
+		{"empty", " \n", formatAuto, nil},
+		{"unknown format", "a=1", "xml", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOutput([]byte(tt.out), tt.format)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("parseOutput = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseOutput = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCommands(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   [][2]string // name, line; nil: an error
+	}{
+		{"several", []string{"Nic=./nic.sh", "sched_2=echo a=1 | tr a b"},
+			[][2]string{{"Nic", "./nic.sh"}, {"sched_2", "echo a=1 | tr a b"}}},
+		{"trims the command", []string{"a= true "}, [][2]string{{"a", "true"}}},
+		{"none", nil, nil},
+		{"no name", []string{"./nic.sh"}, nil},
+		{"empty name", []string{"=./nic.sh"}, nil},
+		{"name with a space", []string{"FOO BAR=x"}, nil},
+		{"empty command", []string{"a= "}, nil},
+		{"duplicate", []string{"a=x", "a=y"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCommands(tt.values)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("parseCommands = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var pairs [][2]string
+			for _, c := range got {
+				pairs = append(pairs, [2]string{c.name, c.line})
+			}
+			if !reflect.DeepEqual(pairs, tt.want) {
+				t.Errorf("parseCommands = %q, want %q", pairs, tt.want)
+			}
+		})
+	}
+}
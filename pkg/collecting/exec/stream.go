@@ -0,0 +1,164 @@
+package exec
+
+import (
+	"InferenceProfiler/pkg/utils"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	osexec "os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	minRestart = time.Second
+	maxRestart = 30 * time.Second
+)
+
+// stream keeps one long-lived child whose stdout carries one record per
+// line. Poll returns the newest record since the previous poll, or nil if
+// none arrived. A child that exits is restarted with backoff.
+type stream struct {
+	opts Options
+	line string
+
+	mu        sync.Mutex
+	cmd       *osexec.Cmd
+	done      chan struct{}
+	latest    map[string]any
+	fresh     bool
+	failed    error
+	backoff   time.Duration
+	nextStart time.Time
+	closed    bool
+}
+
+func newStream(opts Options, line string) *stream {
+	return &stream{opts: opts, line: line, backoff: minRestart}
+}
+
+// start launches the child. Called without s.mu held.
+func (s *stream) start() error {
+	cmd := newCommand(context.Background(), s.line)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	s.mu.Lock()
+	s.cmd, s.done = cmd, done
+	s.mu.Unlock()
+
+	var stderrRead sync.WaitGroup
+	stderrRead.Go(func() { s.logStderr(stderr) })
+	go s.read(cmd, stdout, &stderrRead, done)
+	utils.Debugf("exec: started stream child pid=%d", cmd.Process.Pid)
+	return nil
+}
+
+func (s *stream) read(cmd *osexec.Cmd, stdout io.Reader, stderrRead *sync.WaitGroup, done chan struct{}) {
+	defer close(done)
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), s.opts.MaxOutput)
+	for scanner.Scan() {
+		fields, err := parseOutput(scanner.Bytes(), s.opts.Format)
+		if err != nil {
+			utils.Debugf("exec: skipping line: %v", err)
+			continue
+		}
+		s.mu.Lock()
+		s.latest, s.fresh = withTimestamp(fields), true
+		s.backoff = minRestart
+		s.mu.Unlock()
+	}
+
+	readErr := scanner.Err()
+	if errors.Is(readErr, bufio.ErrTooLong) {
+		readErr = fmt.Errorf("line exceeds %d bytes", s.opts.MaxOutput)
+		killGroup(cmd)
+	}
+	// Wait closes the pipes, so every read from them has to be done first.
+	stderrRead.Wait()
+	waitErr := cmd.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cmd = nil
+	if s.closed {
+		return
+	}
+	err := readErr
+	switch {
+	case err != nil:
+	case waitErr != nil:
+		err = fmt.Errorf("child exited: %w", waitErr)
+	default:
+		err = errors.New("child exited")
+	}
+	s.failed = err
+	s.nextStart = time.Now().Add(s.backoff)
+	log.Printf("exec: stream child stopped, restarting in %v: %v", s.backoff, err)
+	s.backoff = min(s.backoff*2, maxRestart)
+}
+
+func (s *stream) logStderr(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		utils.Debugf("exec: stderr: %s", scanner.Text())
+	}
+}
+
+func (s *stream) poll() any {
+	s.mu.Lock()
+	restart := s.cmd == nil && !s.closed && !time.Now().Before(s.nextStart)
+	if restart {
+		// Block concurrent polls from restarting too.
+		s.nextStart = time.Now().Add(s.backoff)
+	}
+	s.mu.Unlock()
+
+	if restart {
+		if err := s.start(); err != nil {
+			s.mu.Lock()
+			s.failed = err
+			s.mu.Unlock()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.failed != nil:
+		err := s.failed
+		s.failed = nil
+		return map[string]any{"Error": err.Error(), "T": utils.GetTimestamp()}
+	case s.fresh:
+		s.fresh = false
+		return s.latest
+	}
+	return nil
+}
+
+func (s *stream) stop() {
+	s.mu.Lock()
+	s.closed = true
+	cmd, done := s.cmd, s.done
+	s.mu.Unlock()
+
+	if cmd != nil {
+		killGroup(cmd)
+		<-done
+	}
+}
@@ -0,0 +1,479 @@
+package collecting
+
+import (
+	"InferenceProfiler/pkg/collecting/base"
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"InferenceProfiler/pkg/utils"
+)
+
+// entry is one known collector, whether or not it is currently running. A
+// fresh instance is built from newFn for every init attempt so a failed or
+// closed collector never carries state into the next one.
+type entry struct {
+	id       string // registry name, used by -intervals
+	name     string // section name; options such as -exec-name set it at Init
+	interval int    // registered default interval in ms, 0 = -interval
+	newFn    func() base.Collector
+	enabled  bool
+	pinned   bool // enabled or disabled through the API since the runs began
+	initing  bool // an Init is in progress outside m.mu
+	attempts int
+	lastErr  string
+	poller   *poller
+}
+
+func (e *entry) result() InitResult {
+	return InitResult{
+		Name:      e.name,
+		Enabled:   e.enabled,
+		Available: e.poller != nil,
+		Error:     e.lastErr,
+		Attempts:  e.attempts,
+	}
+}
+
+func (m *Manager) register(r Registration) {
+	e := &entry{
+		id:       r.Name,
+		name:     r.New().Name(),
+		interval: r.Interval,
+		newFn:    r.New,
+		enabled:  m.cfg.Enabled(r.Name),
+	}
+	m.entries = append(m.entries, e)
+	if e.enabled && m.claimInit(e) {
+		m.initEntry(e)
+	}
+}
+
+// claimInit marks e for initEntry unless it is running or being
+// initialized already. Called with m.mu held.
+func (m *Manager) claimInit(e *entry) bool {
+	if e.poller != nil || e.initing {
+		return false
+	}
+	e.initing = true
+	return true
+}
+
+// initEntry builds and initializes a collector for e, starting its poll loop
+// if a continuous run is active. Init can be slow (connecting to a driver or
+// endpoint), so it runs without m.mu, which is only taken to swap the
+// collector in. The caller claims e first with claimInit and must not hold
+// m.mu.
+func (m *Manager) initEntry(e *entry) error {
+	for {
+		m.mu.RLock()
+		cfg := m.cfg
+		m.mu.RUnlock()
+
+		c := e.newFn()
+		err := c.Init(cfg)
+		if done, err := m.installEntry(e, c, cfg, err); done {
+			return err
+		}
+	}
+}
+
+// installEntry makes c, initialized from cfg with result err, e's
+// collector. A collector no longer wanted, because e was disabled or has
+// been initialized meanwhile, is closed. It reports false if the
+// collectors were reconfigured during Init, so c has to be built again.
+func (m *Manager) installEntry(e *entry, c base.Collector, cfg *utils.Config, err error) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil && e.enabled && e.poller == nil && !sharedSettingsEqual(cfg, m.cfg) {
+		closeCollector(e.name, c)
+		return false, nil
+	}
+	e.initing = false
+	e.attempts++
+	if err != nil {
+		e.lastErr = err.Error()
+		utils.Debugf("manager: %s init failed (attempt %d): %v", e.name, e.attempts, err)
+		return true, err
+	}
+	if !e.enabled || e.poller != nil {
+		utils.Debugf("manager: %s no longer wanted after init, closing it", e.name)
+		closeCollector(e.name, c)
+		return true, nil
+	}
+	e.lastErr = ""
+	e.name = c.Name()
+	e.poller = newPoller(c, m.pollInterval(e), cfg)
+	if m.runCtx != nil {
+		e.poller.startLoop(m.runCtx)
+	}
+	return true, nil
+}
+
+func closeCollector(name string, c base.Collector) {
+	if err := c.Close(); err != nil {
+		log.Printf("manager: %s close failed: %v", name, err)
+	}
+}
+
+// closeEntry stops e's poll loop and closes its collector. Called with m.mu
+// held.
+func (m *Manager) closeEntry(e *entry) {
+	p := e.poller
+	if p == nil {
+		return
+	}
+	e.poller = nil
+	p.stop()
+	if p.busy.Load() {
+		// Closing under a hung poll would race with it; leave the
+		// collector to process exit.
+		log.Printf("manager: %s still polling, not closing it", e.name)
+		return
+	}
+	closeCollector(e.name, p.collector)
+}
+
+func (m *Manager) lookup(name string) (*entry, error) {
+	for _, e := range m.entries {
+		if strings.EqualFold(e.id, name) || strings.EqualFold(e.name, name) {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownCollector, name)
+}
+
+// Enable turns a collector on and initializes it if it is not running.
+func (m *Manager) Enable(name string) (InitResult, error) {
+	m.mu.Lock()
+	e, err := m.lookup(name)
+	if err != nil {
+		m.mu.Unlock()
+		return InitResult{}, err
+	}
+	e.enabled, e.pinned = true, true
+	claimed := m.claimInit(e)
+	m.mu.Unlock()
+
+	if claimed {
+		if err := m.initEntry(e); err == nil {
+			log.Printf("manager: %s enabled", e.name)
+		}
+	}
+	return m.result(e), nil
+}
+
+// Disable stops and closes a collector and keeps it off until re-enabled.
+func (m *Manager) Disable(name string) (InitResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, err := m.lookup(name)
+	if err != nil {
+		return InitResult{}, err
+	}
+	e.enabled, e.pinned = false, true
+	m.closeEntry(e)
+	log.Printf("manager: %s disabled", e.name)
+	return e.result(), nil
+}
+
+// Reinit closes a collector and initializes a fresh instance, enabling it if
+// it was disabled.
+func (m *Manager) Reinit(name string) (InitResult, error) {
+	m.mu.Lock()
+	e, err := m.lookup(name)
+	if err != nil {
+		m.mu.Unlock()
+		return InitResult{}, err
+	}
+	m.closeEntry(e)
+	e.enabled, e.pinned = true, true
+	claimed := m.claimInit(e)
+	m.mu.Unlock()
+
+	if claimed {
+		if err := m.initEntry(e); err == nil {
+			log.Printf("manager: %s re-initialized", e.name)
+		}
+	}
+	return m.result(e), nil
+}
+
+func (m *Manager) result(e *entry) InitResult {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return e.result()
+}
+
+// configure switches the collectors to cfg, a run's or the startup config,
+// when no run is active: the
+// enabled set follows cfg, dropping runtime toggles, and running collectors
+// are closed for re-initialization if cfg changes what they were built
+// with. It returns the entries claimed for initEntry. Called with m.mu held.
+func (m *Manager) configure(cfg *utils.Config) []*entry {
+	old := m.cfg
+	m.cfg = cfg
+	reinit := !sharedSettingsEqual(old, cfg)
+	var claimed []*entry
+	for _, e := range m.entries {
+		e.enabled, e.pinned = cfg.Enabled(e.id), false
+		if !e.enabled || reinit {
+			m.closeEntry(e)
+		}
+		if e.enabled && m.claimInit(e) {
+			claimed = append(claimed, e)
+		}
+	}
+	if reinit {
+		log.Printf("manager: collectors re-initialized for new run settings")
+	}
+	return claimed
+}
+
+// sharedSettingsEqual reports whether two configs build the same collectors
+// and pollers, so runs using them can share them. Per-collector intervals
+// may differ; GPU domain intervals are read at init and may not.
+func sharedSettingsEqual(a, b *utils.Config) bool {
+	return a.Adaptive == b.Adaptive && a.Align == b.Align &&
+		a.PollTimeout == b.PollTimeout && a.DegradedAfter == b.DegradedAfter &&
+		maps.Equal(domainIntervals(a), domainIntervals(b)) &&
+		reflect.DeepEqual(a.Options, b.Options)
+}
+
+func domainIntervals(cfg *utils.Config) map[string]int {
+	out := make(map[string]int)
+	for name, ms := range cfg.Intervals {
+		if strings.Contains(name, ".") {
+			out[name] = ms
+		}
+	}
+	return out
+}
+
+// Run is one continuous run reading the shared pollers.
+type Run struct {
+	cfg    *utils.Config
+	reason string
+	detail string
+
+	added []*entry // collectors this run enabled; see release
+
+	mu      sync.Mutex
+	pending []*markRequest
+	markers []Marker
+	ended   bool
+}
+
+// StopReason returns the limit that ended the run (StopDuration, ...), or ""
+// if it was stopped from outside or is still running. Only valid once
+// Record has returned.
+func (r *Run) StopReason() string { return r.reason }
+
+// StopDetail describes why the run hit its StopReason, e.g. the free space
+// left for StopDiskFull.
+func (r *Run) StopDetail() string { return r.detail }
+
+// uses reports whether r writes e's section: e is enabled in r's config, or
+// was enabled through the API during the runs.
+func (r *Run) uses(e *entry) bool {
+	return r.cfg.Enabled(e.id) || (e.pinned && e.enabled)
+}
+
+// Acquire registers a run. The first run configures the collectors and
+// starts their poll loops; later ones must agree with it on shared settings
+// (collector options, poll settings) and initialize any collectors they
+// add, which release turns off again. Poll intervals become the fastest any
+// active run asks for. A run is refused with utils.ErrLowDisk while its
+// output filesystem is below -min-free. Collectors are initialized after
+// m.mu is released, so active runs keep ticking meanwhile.
+func (m *Manager) Acquire(cfg *utils.Config) (*Run, error) {
+	if err := utils.CheckFreeSpace(cfg.OutputDir, cfg.MinFree); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	var claimed []*entry
+	if len(m.runs) == 0 {
+		claimed = m.configure(cfg)
+	} else if !sharedSettingsEqual(m.cfg, cfg) {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%w: collector options and poll settings (adaptive, align, poll_timeout, degraded_after, GPU domain intervals) must match the active runs", ErrRunConflict)
+	}
+
+	r := &Run{cfg: cfg}
+	m.runs = append(m.runs, r)
+	for _, e := range m.entries {
+		if r.uses(e) && !e.enabled {
+			e.enabled = true
+			r.added = append(r.added, e)
+			if m.claimInit(e) {
+				claimed = append(claimed, e)
+			}
+		}
+	}
+	m.updateIntervals()
+
+	if m.runCtx == nil {
+		m.runCtx, m.stopLoops = context.WithCancel(context.Background())
+		for _, e := range m.entries {
+			if e.poller != nil {
+				e.poller.startLoop(m.runCtx)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	for _, e := range claimed {
+		m.initEntry(e)
+	}
+	return r, nil
+}
+
+// release unregisters r, stopping the poll loops when it was the last run.
+// Collectors r enabled are turned off again unless another run or the
+// manager config enables them, or the API has pinned them since. The last
+// run hands the collectors back to the startup config.
+func (m *Manager) release(r *Run) {
+	m.mu.Lock()
+	m.runs = slices.DeleteFunc(m.runs, func(x *Run) bool { return x == r })
+	for _, e := range r.added {
+		if e.pinned || m.cfg.Enabled(e.id) || slices.ContainsFunc(m.runs, func(x *Run) bool { return x.cfg.Enabled(e.id) }) {
+			continue
+		}
+		e.enabled = false
+		m.closeEntry(e)
+		utils.Debugf("manager: %s disabled again with run %s", e.name, r.cfg.UUID)
+	}
+	if len(m.runs) > 0 {
+		m.updateIntervals()
+		m.mu.Unlock()
+		return
+	}
+	m.stopLoops()
+	m.runCtx, m.stopLoops = nil, nil
+	for _, e := range m.entries {
+		if e.poller != nil {
+			e.poller.stop()
+		}
+	}
+	claimed := m.configure(m.base)
+	m.mu.Unlock()
+
+	for _, e := range claimed {
+		m.initEntry(e)
+	}
+}
+
+// pollInterval is the fastest interval any active run writing e wants for
+// it, or the manager config's when none does. Called with m.mu held.
+func (m *Manager) pollInterval(e *entry) time.Duration {
+	var d time.Duration
+	for _, r := range m.runs {
+		if !r.uses(e) {
+			continue
+		}
+		if iv := intervalFor(r.cfg, e); d == 0 || iv < d {
+			d = iv
+		}
+	}
+	if d == 0 {
+		return intervalFor(m.cfg, e)
+	}
+	return d
+}
+
+// updateIntervals applies pollInterval to every running poller. Called with
+// m.mu held.
+func (m *Manager) updateIntervals() {
+	for _, e := range m.entries {
+		if e.poller != nil {
+			e.poller.setInterval(m.pollInterval(e))
+		}
+	}
+}
+
+// runPollers returns the pollers r writes.
+func (m *Manager) runPollers(r *Run) []*poller {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]*poller, 0, len(m.entries))
+	for _, e := range m.entries {
+		if e.poller != nil && r.uses(e) {
+			out = append(out, e.poller)
+		}
+	}
+	return out
+}
+
+// Collectors returns the names of the collectors r writes, as they appear
+// as record sections.
+func (m *Manager) Collectors(r *Run) []string {
+	pollers := m.runPollers(r)
+	out := make([]string, 0, len(pollers))
+	for _, p := range pollers {
+		out = append(out, p.collector.Name())
+	}
+	slices.Sort(out)
+	return out
+}
+
+// runIntervals returns the intervals r asked for, keyed by poller.
+func (m *Manager) runIntervals(r *Run) map[*poller]time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make(map[*poller]time.Duration, len(m.entries))
+	for _, e := range m.entries {
+		if e.poller != nil && r.uses(e) {
+			out[e.poller] = intervalFor(r.cfg, e)
+		}
+	}
+	return out
+}
+
+// retryLoop re-attempts Init for enabled collectors that are not running,
+// so services that start after the profiler are picked up.
+func (m *Manager) retryLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+
+		m.mu.Lock()
+		var claimed []*entry
+		for _, e := range m.entries {
+			if e.enabled && m.claimInit(e) {
+				claimed = append(claimed, e)
+			}
+		}
+		m.mu.Unlock()
+
+		for _, e := range claimed {
+			if err := m.initEntry(e); err == nil {
+				log.Printf("manager: %s available after %d attempts", e.name, m.result(e).Attempts)
+			}
+		}
+	}
+}
+
+// active returns the pollers of running collectors.
+func (m *Manager) active() []*poller {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]*poller, 0, len(m.entries))
+	for _, e := range m.entries {
+		if e.poller != nil {
+			out = append(out, e.poller)
+		}
+	}
+	return out
+}
@@ -0,0 +1,155 @@
+package collecting
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"InferenceProfiler/pkg/collecting/base"
+	"InferenceProfiler/pkg/utils"
+)
+
+// testCollector polls a counter. Init waits for gate, if set, to stand in
+// for a slow driver or endpoint.
+type testCollector struct {
+	name   string
+	gate   chan struct{}
+	closed *atomic.Int32
+	polls  atomic.Int64
+}
+
+func (c *testCollector) Name() string { return c.name }
+func (c *testCollector) Init(*utils.Config) error {
+	if c.gate != nil {
+		<-c.gate
+	}
+	return nil
+}
+func (c *testCollector) Static() any              { return nil }
+func (c *testCollector) Poll(context.Context) any { return c.polls.Add(1) }
+func (c *testCollector) Close() error             { c.closed.Add(1); return nil }
+
+// The test collectors' gate and close counts, reset by each test.
+var (
+	slowGate   chan struct{}
+	slowClosed atomic.Int32
+	fastClosed atomic.Int32
+)
+
+func init() {
+	Register(Registration{
+		CollectorSpec: utils.CollectorSpec{Name: "fast", Default: true},
+		New:           func() base.Collector { return &testCollector{name: "Fast", closed: &fastClosed} },
+	})
+	Register(Registration{
+		CollectorSpec: utils.CollectorSpec{Name: "slow"},
+		New:           func() base.Collector { return &testCollector{name: "Slow", gate: slowGate, closed: &slowClosed} },
+	})
+}
+
+func testConfig(disabled ...string) *utils.Config {
+	cfg := &utils.Config{Interval: 20, StaleAfter: 3, DegradedAfter: 3, Disabled: make(map[string]bool)}
+	for _, name := range disabled {
+		cfg.Disabled[name] = true
+	}
+	return cfg
+}
+
+func TestAcquireInitsOutsideLockAndReleaseUndoes(t *testing.T) {
+	slowGate = make(chan struct{})
+	slowClosed.Store(0)
+	fastClosed.Store(0)
+	m := NewManager(testConfig("slow"))
+	defer m.Close()
+
+	first, err := m.Acquire(testConfig("slow"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.release(first)
+
+	// A second run adding the slow collector blocks in its Init.
+	acquired := make(chan *Run)
+	go func() {
+		r, err := m.Acquire(testConfig())
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- r
+	}()
+
+	// Meanwhile the first run's ticks still get at their pollers.
+	deadline := time.After(time.Second)
+	for range 5 {
+		got := make(chan []string, 1)
+		go func() { got <- m.Collectors(first) }()
+		select {
+		case names := <-got:
+			if len(names) != 1 || names[0] != "Fast" {
+				t.Fatalf("first run collectors = %v, want [Fast]", names)
+			}
+		case <-deadline:
+			t.Fatal("runPollers blocked behind a collector's Init")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(slowGate)
+	second := <-acquired
+	if names := m.Collectors(second); len(names) != 2 {
+		t.Fatalf("second run collectors = %v, want Fast and Slow", names)
+	}
+
+	m.release(second)
+	for _, res := range m.InitResults() {
+		if res.Name == "Slow" && (res.Enabled || res.Available) {
+			t.Errorf("slow collector still %+v after the run that added it ended", res)
+		}
+	}
+	if slowClosed.Load() != 1 {
+		t.Errorf("slow collector closed %d times, want 1", slowClosed.Load())
+	}
+	if fastClosed.Load() != 0 {
+		t.Error("fast collector closed while the first run still uses it")
+	}
+}
+
+func TestReleaseRestoresStartupConfig(t *testing.T) {
+	slowGate = nil
+	slowClosed.Store(0)
+	fastClosed.Store(0)
+	base := testConfig("slow")
+	m := NewManager(base)
+	defer m.Close()
+
+	// The run changes a shared setting, so the collectors are rebuilt for it.
+	cfg := testConfig("slow")
+	cfg.Adaptive = true
+	r, err := m.Acquire(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Config() != base {
+		t.Error("Config returns the run's config while it runs")
+	}
+	if fastClosed.Load() != 1 {
+		t.Errorf("fast collector closed %d times for the run, want 1", fastClosed.Load())
+	}
+
+	m.release(r)
+	m.mu.RLock()
+	restored := m.cfg == base
+	m.mu.RUnlock()
+	if !restored {
+		t.Error("collectors still configured by the run after it ended")
+	}
+	if fastClosed.Load() != 2 {
+		t.Errorf("fast collector closed %d times, want again when the run ended", fastClosed.Load())
+	}
+	for _, res := range m.InitResults() {
+		if res.Name == "Fast" && !res.Available {
+			t.Errorf("fast collector %+v, want it rebuilt from the startup config", res)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package collecting
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"InferenceProfiler/pkg/utils"
+)
+
+// Reasons a run stopped by itself, reported by Run.StopReason.
+const (
+	StopDuration   = "duration"
+	StopMaxSamples = "max-samples"
+	StopMaxBytes   = "max-bytes"
+	StopIdle       = "idle"
+	StopDiskFull   = "disk-full"
+)
+
+// limits decides when a run has reached its -max-samples, -max-bytes,
+// -idle-stop or -min-free limit. -duration is a timer in Record.
+type limits struct {
+	cfg     *utils.Config
+	samples int
+	diskAt  time.Time
+
+	// Idle tracking only starts once vLLM has had requests, so a run
+	// started ahead of its benchmark is not stopped before it begins.
+	busy      bool
+	idleSince time.Time
+}
+
+// check counts one record rec written at now, with the run's output at size
+// bytes, and returns the limit reached, if any, with a description for the
+// log.
+func (l *limits) check(rec map[string]any, size int64, now time.Time) (string, string) {
+	l.samples++
+	if l.cfg.MaxSamples > 0 && l.samples >= l.cfg.MaxSamples {
+		return StopMaxSamples, fmt.Sprintf("%d records written", l.samples)
+	}
+	if l.cfg.MaxBytes > 0 && size >= l.cfg.MaxBytes {
+		return StopMaxBytes, fmt.Sprintf("output reached %d bytes", size)
+	}
+	if l.cfg.IdleStop > 0 && l.idle(rec, now) {
+		return StopIdle, fmt.Sprintf("vLLM idle for %ds", l.cfg.IdleStop)
+	}
+	// statfs is cheap, but not worth doing at every 10 ms tick.
+	if l.cfg.MinFree > 0 && now.Sub(l.diskAt) >= time.Second {
+		l.diskAt = now
+		if err := utils.CheckFreeSpace(l.cfg.OutputDir, l.cfg.MinFree); errors.Is(err, utils.ErrLowDisk) {
+			return StopDiskFull, err.Error()
+		}
+	}
+	return "", ""
+}
+
+// idle updates the idle state from rec's vLLM section and reports whether
+// vLLM has been idle for -idle-stop. Records without the section, or taken
+// while vLLM was unreachable, leave the state as it was.
+func (l *limits) idle(rec map[string]any, now time.Time) bool {
+	section, ok := rec["Vllm"]
+	if !ok {
+		return false
+	}
+	flat := utils.Flatten(map[string]any{"Vllm": section})
+	if up, _ := utils.ToFloat(flat["VllmAvailable"]); up == 0 {
+		return false
+	}
+	running, _ := utils.ToFloat(flat["VllmNumRequestsRunning"])
+	waiting, _ := utils.ToFloat(flat["VllmNumRequestsWaiting"])
+	if running+waiting > 0 {
+		l.busy, l.idleSince = true, time.Time{}
+		return false
+	}
+	if !l.busy {
+		return false
+	}
+	if l.idleSince.IsZero() {
+		l.idleSince = now
+	}
+	return now.Sub(l.idleSince) >= time.Duration(l.cfg.IdleStop)*time.Second
+}
@@ -3,66 +3,89 @@ package collecting
 import (
 	"InferenceProfiler/pkg/collecting/base"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"slices"
+	"sync"
 	"time"
 
-	"InferenceProfiler/pkg/collecting/container"
-	"InferenceProfiler/pkg/collecting/nvidia"
-	"InferenceProfiler/pkg/collecting/process"
-	"InferenceProfiler/pkg/collecting/vllm"
-	"InferenceProfiler/pkg/collecting/vm"
 	"InferenceProfiler/pkg/utils"
 )
 
+var (
+	ErrUnknownCollector = errors.New("unknown collector")
+	ErrRunConflict      = errors.New("run conflicts with active runs")
+)
+
 type InitResult struct {
 	Name      string `json:"name"`
 	Enabled   bool   `json:"enabled"`
 	Available bool   `json:"available"`
 	Error     string `json:"error,omitempty"`
+	Attempts  int    `json:"attempts"`
 }
 
-type Manager struct {
-	cfg         *utils.Config
-	pollers     []*poller
-	initResults []InitResult
+// SectionMeta describes the poll behind one section of a dynamic record. It
+// is written under the record's "Meta" key, keyed by section name.
+type SectionMeta struct {
+	Seq       int64 `json:"Seq"`
+	PollStart int64 `json:"PollStart"`
+	PollEnd   int64 `json:"PollEnd"`
+	Age       int64 `json:"Age"`
+	Stale     bool  `json:"Stale"`
 }
 
-func NewManager(cfg *utils.Config) *Manager {
-	m := &Manager{cfg: cfg}
+// Clock timestamps one dynamic record. Wall is the tick's wall-clock time and
+// Monotonic the time since the run started on the monotonic clock, which
+// does not jump when the wall clock is stepped.
+type Clock struct {
+	Wall      int64 `json:"Wall"`
+	Monotonic int64 `json:"Monotonic"`
+}
 
-	m.tryInit(vm.New(), cfg.DisableVM, cfg)
-	m.tryInit(container.New(), cfg.DisableContainer, cfg)
-	m.tryInit(process.New(), cfg.DisableProcess, cfg)
-	m.tryInit(nvidia.New(), cfg.DisableNvidia, cfg)
-	m.tryInit(vllm.New(), cfg.DisableVLLM, cfg)
+type Manager struct {
+	base *utils.Config // startup config, restored when the last run ends
+	cfg  *utils.Config // config the collectors are built with
+	done chan struct{}
 
-	log.Printf("manager: initialized %d collectors", len(m.pollers))
-	return m
+	mu        sync.RWMutex
+	entries   []*entry
+	runs      []*Run
+	runCtx    context.Context // poll loop context while any run is active
+	stopLoops context.CancelFunc
 }
 
-func (m *Manager) tryInit(c base.Collector, disabled bool, cfg *utils.Config) {
-	r := InitResult{Name: c.Name(), Enabled: !disabled}
+func NewManager(cfg *utils.Config) *Manager {
+	m := &Manager{base: cfg, cfg: cfg, done: make(chan struct{})}
 
-	if disabled {
-		m.initResults = append(m.initResults, r)
-		return
+	for _, r := range Registered() {
+		m.register(r)
 	}
 
-	if err := c.Init(cfg); err != nil {
-		r.Error = err.Error()
-		utils.Debugf("manager: %s init failed: %v", c.Name(), err)
-		m.initResults = append(m.initResults, r)
-		return
+	if cfg.InitRetry > 0 {
+		go m.retryLoop(time.Duration(cfg.InitRetry) * time.Millisecond)
 	}
 
-	r.Available = true
-	m.initResults = append(m.initResults, r)
-	m.pollers = append(m.pollers, &poller{collector: c})
+	log.Printf("manager: initialized %d collectors", len(m.active()))
+	return m
+}
+
+// intervalFor returns the -intervals override for a collector, falling back
+// to its registered default and then the global interval.
+func intervalFor(cfg *utils.Config, e *entry) time.Duration {
+	ms := cfg.Interval
+	if e.interval > 0 {
+		ms = e.interval
+	}
+	if v, ok := cfg.Intervals[e.id]; ok {
+		ms = v
+	}
+	return time.Duration(ms) * time.Millisecond
 }
 
-func (m *Manager) writeStatic(w base.Writer) {
-	for _, p := range m.pollers {
+func (m *Manager) writeStatic(w base.Writer, pollers []*poller) {
+	for _, p := range pollers {
 		if s := p.collector.Static(); s != nil {
 			w.Static(p.collector.Name(), s)
 		}
@@ -71,90 +94,345 @@ func (m *Manager) writeStatic(w base.Writer) {
 }
 
 func (m *Manager) Snapshot(ctx context.Context, w base.Writer) int {
-	m.writeStatic(w)
+	m.writeStatic(w, m.active())
 
+	for name, data := range m.pollAll(ctx) {
+		w.Dynamic(name, data)
+	}
+
+	w.Flush()
+	return len(m.active())
+}
+
+// pollAll polls every collector once in parallel, each under its poll
+// deadline. Collectors that fail or time out are logged and left out.
+func (m *Manager) pollAll(ctx context.Context) map[string]any {
 	type result struct {
 		name string
 		data any
 	}
-
-	ch := make(chan result, len(m.pollers))
-	for _, p := range m.pollers {
+	pollers := m.active()
+	ch := make(chan result, len(pollers))
+	for _, p := range pollers {
 		go func() {
-			ch <- result{name: p.collector.Name(), data: p.collector.Poll(ctx)}
+			data, err := p.guardedPoll(ctx)
+			switch {
+			case errors.Is(err, errPollBusy):
+				// A continuous run is mid-poll; its last result will do.
+				data = p.latest().data
+			case err != nil:
+				log.Printf("manager: %s poll failed: %v", p.collector.Name(), err)
+			}
+			ch <- result{name: p.collector.Name(), data: data}
 		}()
 	}
-
-	for range m.pollers {
+	out := make(map[string]any, len(pollers))
+	for range pollers {
 		if r := <-ch; r.data != nil {
-			w.Dynamic(r.name, r.data)
+			out[r.name] = r.data
 		}
 	}
-
-	w.Flush()
-	return len(m.pollers)
+	return out
 }
 
-func (m *Manager) Continuous(ctx context.Context, w base.Writer) (int, error) {
-	interval := time.Duration(m.cfg.Interval) * time.Millisecond
+// tickInterval is a run's writer tick: its interval, or the fastest of its
+// collectors' when its intervals set one below it.
+func (m *Manager) tickInterval(r *Run) time.Duration {
+	interval := time.Duration(r.cfg.Interval) * time.Millisecond
+	for _, iv := range m.runIntervals(r) {
+		interval = min(interval, iv)
+	}
+	return interval
+}
 
-	for _, p := range m.pollers {
-		p.startLoop(ctx, interval)
+// Continuous writes records for the collectors enabled in cfg until ctx is
+// done. Several runs may be active at once; they share the pollers, which
+// poll at the fastest interval any of them asks for. A run whose collector
+// options or poll settings differ from the active runs' fails with
+// ErrRunConflict.
+func (m *Manager) Continuous(ctx context.Context, w base.Writer, cfg *utils.Config) (int, error) {
+	r, err := m.Acquire(cfg)
+	if err != nil {
+		return 0, err
 	}
-	defer func() {
-		for _, p := range m.pollers {
-			p.stop()
-		}
-	}()
+	return m.Record(ctx, w, r)
+}
 
-	m.writeStatic(w)
+// Record writes r's records until ctx is done or the run reaches one of its
+// limits, and then releases r.
+func (m *Manager) Record(ctx context.Context, w base.Writer, r *Run) (int, error) {
+	defer m.release(r)
+	cfg := r.cfg
 
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	m.writeStatic(w, m.runPollers(r))
+	if cfg.IdleStop > 0 && !slices.ContainsFunc(m.runPollers(r), func(p *poller) bool { return p.collector.Name() == "Vllm" }) {
+		log.Printf("manager: idle-stop set but the vllm collector is not running; it applies once it is")
+	}
 
-	count := 0
+	var deadline <-chan time.Time
+	if cfg.Duration > 0 {
+		d := time.NewTimer(time.Duration(cfg.Duration) * time.Second)
+		defer d.Stop()
+		deadline = d.C
+	}
+	lim := &limits{cfg: cfg}
+
+	interval := m.tickInterval(r)
 	start := time.Now()
+	next := nextTick(cfg, start, start, interval)
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+
+	tick := newTickState(m.runPollers(r), start)
+	q := newRecordQueue(w, cfg.QueueSize, cfg.QueuePolicy, cfg.MaxBytes > 0)
+
+	finish := func() (int, error) {
+		r.end()
+		q.close()
+		count := int(q.written.Load())
+		log.Printf("manager: collected %d records in %v", count, time.Since(start))
+		log.Printf("manager: %s", tick.jitterStats(q))
+		for _, p := range m.runPollers(r) {
+			log.Printf("%-12s %s", p.collector.Name()+":", p.pollStats())
+		}
+		return count, nil
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
-			elapsed := time.Since(start)
-			log.Printf("manager: collected %d records in %v", count, elapsed)
-			for _, p := range m.pollers {
-				log.Printf("%-12s %s", p.collector.Name()+":", p.pollStats())
-			}
-			return count, nil
+			return finish()
+
+		case <-deadline:
+			r.reason, r.detail = StopDuration, fmt.Sprintf("%ds elapsed", cfg.Duration)
+			log.Printf("manager: stopping run %s: %s", cfg.UUID, r.detail)
+			return finish()
 
-		case <-ticker.C:
+		case <-timer.C:
 			t := utils.DebugTimer()
-			for _, p := range m.pollers {
-				if data := p.latest(); data != nil {
-					w.Dynamic(p.collector.Name(), data)
+			now := time.Now()
+			tick.observe(now, next, interval)
+			rec := m.buildRecord(r, tick, now)
+			interval = m.tickInterval(r)
+			next = nextTick(cfg, next, time.Now(), interval)
+			timer.Reset(time.Until(next))
+			if marks := r.takeMarkers(now, tick.count); len(marks) > 0 {
+				// A marker gets a record even on a tick with nothing
+				// fresh, so it is never lost or moved to a later tick.
+				if rec == nil {
+					rec = make(map[string]any)
+				}
+				if _, ok := rec["Clock"]; !ok {
+					rec["Clock"] = Clock{Wall: now.UnixNano(), Monotonic: now.Sub(tick.start).Nanoseconds()}
 				}
+				rec["Markers"] = marks
+			}
+			if rec == nil {
+				continue
+			}
+			rec["Ticks"] = tick.counters(q)
+			q.push(ctx, rec)
+			utils.DebugDuration("manager", fmt.Sprintf("tick #%d (%d sections)", tick.count, len(rec)), t)
+			if reason, why := lim.check(rec, q.size(), now); reason != "" {
+				r.reason, r.detail = reason, why
+				log.Printf("manager: stopping run %s: %s", cfg.UUID, why)
+				return finish()
+			}
+		}
+	}
+}
+
+// nextTick schedules the writer. Without -align it runs at a fixed rate from
+// the previous tick. With -align collectors poll at wall-clock multiples of
+// their interval, and the writer ticks half an interval after each boundary
+// so a record holds the polls started at the boundary before it.
+func nextTick(cfg *utils.Config, prev, now time.Time, interval time.Duration) time.Time {
+	if !cfg.Align {
+		if next := prev.Add(interval); next.After(now) {
+			return next
+		}
+		return now
+	}
+	next := now.Truncate(interval).Add(interval / 2)
+	for !next.After(now) {
+		next = next.Add(interval)
+	}
+	return next
+}
+
+// TickCounters is written under "Ticks" in every dynamic record. Counts are
+// cumulative for the run.
+type TickCounters struct {
+	Seq        int64 `json:"Seq"`
+	Jitter     int64 `json:"Jitter"`
+	Late       int64 `json:"Late"`
+	Dropped    int64 `json:"Dropped"`
+	QueueDepth int   `json:"QueueDepth"`
+}
+
+// tickState is what a continuous run remembers between ticks.
+type tickState struct {
+	start   time.Time
+	lastSeq map[*poller]int64
+	known   map[*poller]bool
+
+	count     int64
+	late      int64
+	jitter    time.Duration
+	jitterSum time.Duration
+	jitterMax time.Duration
+}
+
+// observe records how far a tick fired from its schedule. A tick that fires
+// a whole interval or more behind has skipped slots, counted as late.
+func (t *tickState) observe(now, scheduled time.Time, interval time.Duration) {
+	t.count++
+	t.jitter = now.Sub(scheduled)
+	t.jitterSum += t.jitter
+	t.jitterMax = max(t.jitterMax, t.jitter)
+	if interval > 0 && t.jitter >= interval {
+		t.late += int64(t.jitter / interval)
+	}
+}
+
+func (t *tickState) counters(q *recordQueue) TickCounters {
+	return TickCounters{
+		Seq:        t.count,
+		Jitter:     t.jitter.Nanoseconds(),
+		Late:       t.late,
+		Dropped:    q.dropped.Load(),
+		QueueDepth: q.depth(),
+	}
+}
+
+func (t *tickState) jitterStats(q *recordQueue) string {
+	if t.count == 0 {
+		return "no ticks"
+	}
+	return fmt.Sprintf("ticks=%d  jitter avg=%v max=%v  late=%d  dropped=%d",
+		t.count, (t.jitterSum / time.Duration(t.count)).Round(time.Microsecond),
+		t.jitterMax.Round(time.Microsecond), t.late, q.dropped.Load())
+}
+
+func newTickState(pollers []*poller, start time.Time) *tickState {
+	t := &tickState{start: start, lastSeq: make(map[*poller]int64), known: make(map[*poller]bool)}
+	for _, p := range pollers {
+		t.known[p] = true
+	}
+	return t
+}
+
+// buildRecord collects the latest poll of each collector according to the
+// stale policy, together with a Meta section describing every poll
+// considered and a Clock section. Collectors that came up after the run
+// started get their static data added once under a "Static" section. It
+// returns nil when there is nothing to write.
+func (m *Manager) buildRecord(r *Run, tick *tickState, now time.Time) map[string]any {
+	pollers := m.runPollers(r)
+	rec := make(map[string]any, len(pollers)+4)
+	meta := make(map[string]SectionMeta, len(pollers))
+	static := make(map[string]any)
+
+	for _, p := range pollers {
+		if !tick.known[p] {
+			tick.known[p] = true
+			if st := p.collector.Static(); st != nil {
+				static[p.collector.Name()] = st
 			}
-			if err := w.Flush(); err != nil {
-				log.Printf("manager: flush error: %v", err)
+		}
+
+		s := p.latest()
+		if s.data == nil {
+			continue
+		}
+		fresh := s.seq != tick.lastSeq[p]
+		tick.lastSeq[p] = s.seq
+
+		age := now.Sub(s.end)
+		stale := age > time.Duration(r.cfg.StaleAfter)*p.effectiveInterval()
+
+		switch r.cfg.StalePolicy {
+		case utils.StaleSkip:
+			// Only sections refreshed since the previous tick are
+			// written, so slower collectors are not repeated.
+			if !fresh {
+				continue
+			}
+		case utils.StaleDrop:
+			if stale {
+				utils.Debugf("manager: dropping stale %s (seq=%d age=%v)", p.collector.Name(), s.seq, age)
+				meta[p.collector.Name()] = newSectionMeta(s, age, stale)
+				continue
 			}
-			count++
-			utils.DebugDuration("manager", fmt.Sprintf("tick #%d", count), t)
 		}
+
+		rec[p.collector.Name()] = s.data
+		meta[p.collector.Name()] = newSectionMeta(s, age, stale)
+	}
+
+	if len(static) > 0 {
+		rec["Static"] = static
+	}
+	if len(meta) == 0 {
+		if len(rec) == 0 {
+			return nil
+		}
+		return rec
+	}
+	rec["Meta"] = meta
+	rec["Clock"] = Clock{Wall: now.UnixNano(), Monotonic: now.Sub(tick.start).Nanoseconds()}
+	return rec
+}
+
+func newSectionMeta(s sample, age time.Duration, stale bool) SectionMeta {
+	return SectionMeta{
+		Seq:       s.seq,
+		PollStart: s.start.UnixNano(),
+		PollEnd:   s.end.UnixNano(),
+		Age:       age.Nanoseconds(),
+		Stale:     stale,
 	}
 }
 
 func (m *Manager) Close() error {
-	for _, p := range m.pollers {
-		p.stop()
-		p.collector.Close()
+	close(m.done)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.entries {
+		m.closeEntry(e)
 	}
 	return nil
 }
 
-func (m *Manager) InitResults() []InitResult { return m.initResults }
-func (m *Manager) Config() *utils.Config     { return m.cfg }
+// Health reports the watchdog state of every running collector.
+func (m *Manager) Health() []CollectorHealth {
+	pollers := m.active()
+	out := make([]CollectorHealth, 0, len(pollers))
+	for _, p := range pollers {
+		out = append(out, p.healthSnapshot())
+	}
+	return out
+}
+
+func (m *Manager) InitResults() []InitResult {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]InitResult, 0, len(m.entries))
+	for _, e := range m.entries {
+		out = append(out, e.result())
+	}
+	return out
+}
+
+// Config returns the startup config, which run specs are applied over.
+func (m *Manager) Config() *utils.Config {
+	return m.base
+}
 
 func (m *Manager) StaticData() map[string]any {
-	out := make(map[string]any, len(m.pollers))
-	for _, p := range m.pollers {
+	pollers := m.active()
+	out := make(map[string]any, len(pollers))
+	for _, p := range pollers {
 		if s := p.collector.Static(); s != nil {
 			out[p.collector.Name()] = s
 		}
@@ -163,22 +441,5 @@ func (m *Manager) StaticData() map[string]any {
 }
 
 func (m *Manager) SnapshotTick() map[string]any {
-	type result struct {
-		name string
-		data any
-	}
-	ctx := context.Background()
-	ch := make(chan result, len(m.pollers))
-	for _, p := range m.pollers {
-		go func() {
-			ch <- result{name: p.collector.Name(), data: p.collector.Poll(ctx)}
-		}()
-	}
-	out := make(map[string]any, len(m.pollers))
-	for range m.pollers {
-		if r := <-ch; r.data != nil {
-			out[r.name] = r.data
-		}
-	}
-	return out
+	return m.pollAll(context.Background())
 }
@@ -0,0 +1,84 @@
+package collecting
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrRunEnded is returned by Mark once the run has stopped.
+var ErrRunEnded = errors.New("run has ended")
+
+// Marker labels a point in a run, e.g. the end of warmup. It is written
+// under "Markers" in the record of the tick it was attached to; At and Seq
+// are that tick's Clock.Wall and Ticks.Seq.
+type Marker struct {
+	Label string `json:"Label"`
+	At    int64  `json:"At"`
+	Seq   int64  `json:"Seq"`
+}
+
+type markRequest struct {
+	label string
+	done  chan Marker
+}
+
+// Mark attaches a marker to r's next tick and returns it once that tick
+// has been taken. If ctx ends first the marker is still written.
+func (r *Run) Mark(ctx context.Context, label string) (Marker, error) {
+	req := &markRequest{label: label, done: make(chan Marker, 1)}
+	r.mu.Lock()
+	if r.ended {
+		r.mu.Unlock()
+		return Marker{}, ErrRunEnded
+	}
+	r.pending = append(r.pending, req)
+	r.mu.Unlock()
+
+	select {
+	case m, ok := <-req.done:
+		if !ok {
+			return Marker{}, ErrRunEnded
+		}
+		return m, nil
+	case <-ctx.Done():
+		return Marker{}, ctx.Err()
+	}
+}
+
+// Markers returns the markers written so far, never nil, so a run without
+// any lists them as [].
+func (r *Run) Markers() []Marker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Marker{}, r.markers...)
+}
+
+// takeMarkers stamps the pending markers with the tick taken at now.
+func (r *Run) takeMarkers(now time.Time, seq int64) []Marker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) == 0 {
+		return nil
+	}
+	out := make([]Marker, 0, len(r.pending))
+	for _, req := range r.pending {
+		m := Marker{Label: req.label, At: now.UnixNano(), Seq: seq}
+		req.done <- m
+		out = append(out, m)
+	}
+	r.pending = nil
+	r.markers = append(r.markers, out...)
+	return out
+}
+
+// end fails markers that did not make it into a tick and refuses new ones.
+func (r *Run) end() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ended = true
+	for _, req := range r.pending {
+		close(req.done)
+	}
+	r.pending = nil
+}
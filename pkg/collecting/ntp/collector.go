@@ -0,0 +1,95 @@
+// Package ntp measures the local clock's offset from an NTP server on a slow
+// schedule, so records from different hosts can be aligned after the fact.
+package ntp
+
+import (
+	"InferenceProfiler/pkg/collecting"
+	"InferenceProfiler/pkg/collecting/base"
+	"InferenceProfiler/pkg/utils"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+const queryTimeout = 5 * time.Second
+
+// Options are the ntp collector's flags.
+type Options struct {
+	Server string `flag:"ntp-server" arg:"HOST" json:"server" spec:"safe" help:"NTP server queried for clock offset"`
+}
+
+// Dynamic is one offset measurement. Offset is what must be added to local
+// wall-clock timestamps to get the server's time.
+type Dynamic struct {
+	Server       string         `json:"Server"`
+	Offset       base.MetricInt `json:"Offset"`
+	RTT          base.MetricInt `json:"RTT"`
+	RootDistance base.MetricInt `json:"RootDistance"`
+	Stratum      base.MetricInt `json:"Stratum"`
+	Leap         base.MetricInt `json:"Leap"`
+	Error        string         `json:"Error,omitempty"`
+}
+
+type Collector struct {
+	server string
+}
+
+func New() *Collector { return &Collector{} }
+
+func init() {
+	collecting.Register(collecting.Registration{
+		CollectorSpec: utils.CollectorSpec{
+			Name:        "ntp",
+			Description: "periodic NTP clock offset measurement",
+			Interval:    60000,
+			Options:     func() any { return &Options{Server: "pool.ntp.org"} },
+		},
+		New:   func() base.Collector { return New() },
+		Order: 70,
+	})
+}
+
+func (c *Collector) Name() string { return "Ntp" }
+
+func (c *Collector) Init(cfg *utils.Config) error {
+	c.server = utils.CollectorOptions[Options](cfg, "ntp").Server
+	if c.server == "" {
+		return errors.New("ntp: no server configured (-ntp-server)")
+	}
+	log.Printf("ntp: measuring clock offset against %s", c.server)
+	return nil
+}
+
+func (c *Collector) Static() any { return nil }
+
+func (c *Collector) Poll(ctx context.Context) any {
+	timeout := queryTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = min(timeout, time.Until(deadline))
+	}
+
+	d := Dynamic{Server: c.server}
+	r, err := ntp.QueryWithOptions(c.server, ntp.QueryOptions{Timeout: timeout})
+	ts := utils.GetTimestamp()
+	if err == nil {
+		err = r.Validate()
+	}
+	if err != nil {
+		utils.Debugf("ntp: query %s failed: %v", c.server, err)
+		d.Error = err.Error()
+		return d
+	}
+
+	d.Offset = base.MetricInt{V: r.ClockOffset.Nanoseconds(), T: ts}
+	d.RTT = base.MetricInt{V: r.RTT.Nanoseconds(), T: ts}
+	d.RootDistance = base.MetricInt{V: r.RootDistance.Nanoseconds(), T: ts}
+	d.Stratum = base.MetricInt{V: int64(r.Stratum), T: ts}
+	d.Leap = base.MetricInt{V: int64(r.Leap), T: ts}
+	utils.Debugf("ntp: offset=%v rtt=%v stratum=%d", r.ClockOffset, r.RTT, r.Stratum)
+	return d
+}
+
+func (c *Collector) Close() error { return nil }
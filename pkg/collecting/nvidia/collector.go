@@ -1,10 +1,17 @@
 package nvidia
 
 import (
+	"InferenceProfiler/pkg/collecting"
+	"InferenceProfiler/pkg/collecting/base"
 	"InferenceProfiler/pkg/collecting/nvidia/domains"
 	"InferenceProfiler/pkg/utils"
 	"context"
 	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
 )
 
 type Static struct {
@@ -14,6 +21,9 @@ type Static struct {
 	Clocks  domains.ClocksStatic
 	Thermal domains.ThermalStatic
 	PCIe    domains.PCIeStatic
+	NvLink  domains.NvLinkStatic
+	ECC     domains.EccStatic
+	MIG     domains.MigStatic
 }
 
 type Dynamic struct {
@@ -25,37 +35,100 @@ type Dynamic struct {
 	Thermal     domains.ThermalDynamic
 	PCIe        domains.PCIeDynamic
 	Violations  domains.Violations
+	NvLink      domains.NvLinkDynamic
+	ECC         domains.EccDynamic
+	Events      domains.Events
 	Processes   domains.Processes
+	MIG         []domains.MigInstanceDynamic `json:"MIG,omitempty"`
+	Samples     *domains.Samples             `json:"Samples,omitempty"`
+}
+
+// Options are the nvidia collector's flags.
+type Options struct {
+	Samples    bool   `flag:"nvidia-samples" json:"samples" spec:"safe" help:"Drain NVML sample buffers (power, utilization, clocks) on every poll"`
+	Fake       int    `flag:"nvml-fake" arg:"N" json:"fake" help:"Replace NVML with N simulated GPUs (0 = use the real driver)"`
+	FakeCurve  string `flag:"nvml-fake-curve" arg:"NAME[:PERIOD]" json:"fake_curve" help:"Load curve for simulated GPUs: constant|sine|square|ramp|idle[:period]"`
+	FakeErrors string `flag:"nvml-fake-errors" arg:"LIST" json:"fake_errors" help:"Comma-separated Method[=error] calls the simulated GPUs fail (default error: not_supported; hang and panic also accepted)"`
 }
 
 type Collector struct {
 	nvml             *NVML
+	events           *domains.EventWatcher
 	static           []Static
+	mig              [][]nvml.Device
+	samples          []*domains.SampleBuffer
 	collectProcesses bool
+
+	mu       sync.Mutex
+	schedule *domainSchedule
+	prev     []Dynamic
 }
 
 func New() *Collector { return &Collector{} }
 
+func init() {
+	collecting.Register(collecting.Registration{
+		CollectorSpec: utils.CollectorSpec{
+			Name:        "nvidia",
+			Description: "NVIDIA GPU metrics",
+			Default:     true,
+			Options:     func() any { return &Options{FakeCurve: "sine"} },
+		},
+		New:   func() base.Collector { return New() },
+		Order: 40,
+	})
+}
+
 func (c *Collector) Name() string { return "Nvidia" }
 
 func (c *Collector) Init(cfg *utils.Config) error {
-	c.collectProcesses = !cfg.DisableProcess
+	opts := utils.CollectorOptions[Options](cfg, "nvidia")
+	c.collectProcesses = cfg.Enabled("process")
+	c.schedule = newDomainSchedule(cfg.Intervals)
 
-	n, err := NewNVML()
+	lib, err := newLibrary(opts)
+	if err != nil {
+		return fmt.Errorf("nvidia init: %w", err)
+	}
+
+	n, err := NewNVML(lib)
 	if err != nil {
 		return fmt.Errorf("nvidia init: %w", err)
 	}
 	c.nvml = n
 	c.static = make([]Static, n.Count())
+	c.mig = make([][]nvml.Device, n.Count())
 
 	for i, device := range n.Devices() {
 		s := &c.static[i]
-		domains.CollectDeviceStatic(device, i, &s.Device)
+		domains.CollectDeviceStatic(lib, device, i, &s.Device)
 		domains.CollectPowerStatic(device, &s.Power)
 		domains.CollectMemoryStatic(device, &s.Memory)
 		domains.CollectClocksStatic(device, &s.Clocks)
 		domains.CollectThermalStatic(device, &s.Thermal)
 		domains.CollectPCIeStatic(device, &s.PCIe)
+		domains.CollectNvLinkStatic(device, &s.NvLink)
+		domains.CollectEccStatic(device, &s.ECC)
+		c.mig[i] = domains.CollectMigStatic(device, i, &s.MIG)
+	}
+
+	if opts.Samples {
+		c.samples = make([]*domains.SampleBuffer, n.Count())
+		for i := range c.samples {
+			c.samples[i] = domains.NewSampleBuffer()
+		}
+		log.Printf("nvidia: draining NVML sample buffers each poll")
+	}
+
+	if set, ret := lib.EventSetCreate(); ret == nvml.SUCCESS {
+		if w, err := domains.NewEventWatcher(set, n.Devices()); err == nil {
+			c.events = w
+		} else {
+			set.Free()
+			log.Printf("nvidia: event capture disabled: %v", err)
+		}
+	} else {
+		log.Printf("nvidia: event capture disabled: EventSetCreate: %v", ret)
 	}
 
 	return nil
@@ -64,31 +137,84 @@ func (c *Collector) Init(cfg *utils.Config) error {
 func (c *Collector) Static() any { return c.static }
 
 func (c *Collector) Poll(_ context.Context) any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	skip := c.schedule.skipped(time.Now())
+	due := func(domain string) bool { return !skip[domain] }
+
 	devices := c.nvml.Devices()
 	result := make([]Dynamic, len(devices))
 
 	for i, device := range devices {
 		d := &result[i]
+		// Domains that are not due keep their previous values; their T
+		// fields show when they were last read.
+		if c.prev != nil {
+			*d = c.prev[i]
+		}
 		d.Index = i
 
-		domains.CollectMemoryDynamic(device, &d.Memory)
-		domains.CollectUtilizationDynamic(device, &d.Utilization)
-		domains.CollectClocksDynamic(device, &d.Clocks)
-		domains.CollectThermalDynamic(device, &d.Thermal)
-		domains.CollectPowerDynamic(device, &d.Power)
-		domains.CollectViolationsDynamic(device, &d.Violations)
-		domains.CollectPCIeReplayCounter(device, &d.PCIe)
-		domains.CollectPCIeThroughput(device, &d.PCIe)
+		if due("memory") {
+			domains.CollectMemoryDynamic(device, &d.Memory)
+		}
+		if due("utilization") {
+			domains.CollectUtilizationDynamic(device, &d.Utilization)
+		}
+		if due("clocks") {
+			domains.CollectClocksDynamic(device, &d.Clocks)
+		}
+		if due("thermal") {
+			domains.CollectThermalDynamic(device, &d.Thermal)
+		}
+		if due("power") {
+			domains.CollectPowerDynamic(device, &d.Power)
+		}
+		if due("violations") {
+			domains.CollectViolationsDynamic(device, &d.Violations)
+		}
+		if due("pcie") {
+			domains.CollectPCIeReplayCounter(device, &d.PCIe)
+			domains.CollectPCIeThroughput(device, &d.PCIe)
+		}
+		if due("nvlink") {
+			domains.CollectNvLinkDynamic(device, c.static[i].NvLink.Links, &d.NvLink)
+		}
+		if due("ecc") {
+			domains.CollectEccDynamic(device, c.static[i].ECC.Enabled, &d.ECC)
+		}
+		d.Events.Recent = nil
+		if due("events") {
+			c.events.Collect(i, &d.Events)
+		}
 
-		if c.collectProcesses {
+		if c.collectProcesses && due("processes") {
+			d.Processes = domains.Processes{}
 			domains.CollectProcessesDynamic(device, &d.Processes)
 		}
+
+		d.Samples = nil
+		if c.samples != nil && due("samples") {
+			d.Samples = &domains.Samples{}
+			c.samples[i].Collect(device, d.Samples)
+		}
+
+		if len(c.mig[i]) > 0 && due("mig") {
+			d.MIG = make([]domains.MigInstanceDynamic, len(c.mig[i]))
+			for j, mig := range c.mig[i] {
+				domains.CollectMigDynamic(mig, &c.static[i].MIG.Instances[j], c.collectProcesses, &d.MIG[j])
+			}
+		}
 	}
 
+	c.prev = result
 	return result
 }
 
 func (c *Collector) Close() error {
+	if err := c.events.Close(); err != nil {
+		log.Printf("nvidia: event set free failed: %v", err)
+	}
 	if c.nvml != nil {
 		return c.nvml.Close()
 	}
@@ -15,9 +15,16 @@ type DeviceStatic struct {
 	ComputeCapabilityMinor int
 	DriverVersion          string
 	CudaVersion            int
+	VirtualizationMode     string
 }
 
-func CollectDeviceStatic(d nvml.Device, index int, s *DeviceStatic) {
+// System is the subset of NVML system queries used for device static info.
+type System interface {
+	SystemGetDriverVersion() (string, nvml.Return)
+	SystemGetCudaDriverVersion() (int, nvml.Return)
+}
+
+func CollectDeviceStatic(sys System, d nvml.Device, index int, s *DeviceStatic) {
 	s.Index = index
 
 	if name, ret := d.GetName(); ret == nvml.SUCCESS {
@@ -45,18 +52,24 @@ func CollectDeviceStatic(d nvml.Device, index int, s *DeviceStatic) {
 		utils.Debugf("nvidia/device[%d]: GetCudaComputeCapability failed: %v", index, ret)
 	}
 
-	if version, ret := nvml.SystemGetDriverVersion(); ret == nvml.SUCCESS {
+	if version, ret := sys.SystemGetDriverVersion(); ret == nvml.SUCCESS {
 		s.DriverVersion = version
 	} else {
 		utils.Debugf("nvidia/device[%d]: SystemGetDriverVersion failed: %v", index, ret)
 	}
 
-	if cuda, ret := nvml.SystemGetCudaDriverVersion(); ret == nvml.SUCCESS {
+	if cuda, ret := sys.SystemGetCudaDriverVersion(); ret == nvml.SUCCESS {
 		s.CudaVersion = cuda
 	} else {
 		utils.Debugf("nvidia/device[%d]: SystemGetCudaDriverVersion failed: %v", index, ret)
 	}
 
+	if mode, ret := d.GetVirtualizationMode(); ret == nvml.SUCCESS {
+		s.VirtualizationMode = VirtualizationModeName(mode)
+	} else {
+		utils.Debugf("nvidia/device[%d]: GetVirtualizationMode failed: %v", index, ret)
+	}
+
 	utils.Debugf("nvidia/device[%d]: name=%s uuid=%s cc=%d.%d driver=%s",
 		index, s.Name, s.UUID, s.ComputeCapabilityMajor, s.ComputeCapabilityMinor, s.DriverVersion)
 }
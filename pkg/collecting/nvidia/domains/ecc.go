@@ -0,0 +1,87 @@
+package domains
+
+import (
+	"InferenceProfiler/pkg/collecting/base"
+	"InferenceProfiler/pkg/utils"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+type EccStatic struct {
+	Enabled        bool
+	PendingEnabled bool
+}
+
+func CollectEccStatic(d nvml.Device, e *EccStatic) {
+	if current, pending, ret := d.GetEccMode(); ret == nvml.SUCCESS {
+		e.Enabled = current == nvml.FEATURE_ENABLED
+		e.PendingEnabled = pending == nvml.FEATURE_ENABLED
+	} else {
+		utils.Debugf("nvidia/ecc: GetEccMode failed: %v", ret)
+	}
+	utils.Debugf("nvidia/ecc: static enabled=%v pending=%v", e.Enabled, e.PendingEnabled)
+}
+
+type EccDynamic struct {
+	VolatileCorrected     base.MetricInt `json:"VolatileCorrected"`
+	VolatileUncorrected   base.MetricInt `json:"VolatileUncorrected"`
+	AggregateCorrected    base.MetricInt `json:"AggregateCorrected"`
+	AggregateUncorrected  base.MetricInt `json:"AggregateUncorrected"`
+	RetiredSingleBit      base.MetricInt `json:"RetiredSingleBit"`
+	RetiredDoubleBit      base.MetricInt `json:"RetiredDoubleBit"`
+	RetiredPending        base.MetricInt `json:"RetiredPending"`
+	RemappedCorrectable   base.MetricInt `json:"RemappedCorrectable"`
+	RemappedUncorrectable base.MetricInt `json:"RemappedUncorrectable"`
+	RemappingPending      base.MetricInt `json:"RemappingPending"`
+	RemappingFailed       base.MetricInt `json:"RemappingFailed"`
+}
+
+func CollectEccDynamic(d nvml.Device, enabled bool, e *EccDynamic) {
+	if enabled {
+		collectEccCounter(d, nvml.MEMORY_ERROR_TYPE_CORRECTED, nvml.VOLATILE_ECC, &e.VolatileCorrected)
+		collectEccCounter(d, nvml.MEMORY_ERROR_TYPE_UNCORRECTED, nvml.VOLATILE_ECC, &e.VolatileUncorrected)
+		collectEccCounter(d, nvml.MEMORY_ERROR_TYPE_CORRECTED, nvml.AGGREGATE_ECC, &e.AggregateCorrected)
+		collectEccCounter(d, nvml.MEMORY_ERROR_TYPE_UNCORRECTED, nvml.AGGREGATE_ECC, &e.AggregateUncorrected)
+	}
+
+	if pages, ret := d.GetRetiredPages(nvml.PAGE_RETIREMENT_CAUSE_MULTIPLE_SINGLE_BIT_ECC_ERRORS); ret == nvml.SUCCESS {
+		e.RetiredSingleBit = base.MetricInt{V: int64(len(pages)), T: utils.GetTimestamp()}
+	} else {
+		utils.Debugf("nvidia/ecc: GetRetiredPages(SINGLE_BIT) failed: %v", ret)
+	}
+	if pages, ret := d.GetRetiredPages(nvml.PAGE_RETIREMENT_CAUSE_DOUBLE_BIT_ECC_ERROR); ret == nvml.SUCCESS {
+		e.RetiredDoubleBit = base.MetricInt{V: int64(len(pages)), T: utils.GetTimestamp()}
+	} else {
+		utils.Debugf("nvidia/ecc: GetRetiredPages(DOUBLE_BIT) failed: %v", ret)
+	}
+	if v, ret := d.GetRetiredPagesPendingStatus(); ret == nvml.SUCCESS {
+		e.RetiredPending = base.MetricInt{V: boolToInt(v == nvml.FEATURE_ENABLED), T: utils.GetTimestamp()}
+	} else {
+		utils.Debugf("nvidia/ecc: GetRetiredPagesPendingStatus failed: %v", ret)
+	}
+
+	if corr, unc, pending, failed, ret := d.GetRemappedRows(); ret == nvml.SUCCESS {
+		now := utils.GetTimestamp()
+		e.RemappedCorrectable = base.MetricInt{V: int64(corr), T: now}
+		e.RemappedUncorrectable = base.MetricInt{V: int64(unc), T: now}
+		e.RemappingPending = base.MetricInt{V: boolToInt(pending), T: now}
+		e.RemappingFailed = base.MetricInt{V: boolToInt(failed), T: now}
+	} else {
+		utils.Debugf("nvidia/ecc: GetRemappedRows failed: %v", ret)
+	}
+}
+
+func collectEccCounter(d nvml.Device, errType nvml.MemoryErrorType, counter nvml.EccCounterType, m *base.MetricInt) {
+	if v, ret := d.GetTotalEccErrors(errType, counter); ret == nvml.SUCCESS {
+		*m = base.MetricInt{V: int64(v), T: utils.GetTimestamp()}
+	} else {
+		utils.Debugf("nvidia/ecc: GetTotalEccErrors(%d, %d) failed: %v", errType, counter, ret)
+	}
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,122 @@
+package domains
+
+import (
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock"
+)
+
+type eccKey struct {
+	errType nvml.MemoryErrorType
+	counter nvml.EccCounterType
+}
+
+// eccDevice returns a device reporting totals for ECC counters, missing
+// keys failing with ERROR_NOT_SUPPORTED, and otherwise fixed page and row
+// counts.
+func eccDevice(totals map[eccKey]uint64, remapRet nvml.Return) *mock.Device {
+	return &mock.Device{
+		GetTotalEccErrorsFunc: func(t nvml.MemoryErrorType, c nvml.EccCounterType) (uint64, nvml.Return) {
+			v, ok := totals[eccKey{t, c}]
+			if !ok {
+				return 0, nvml.ERROR_NOT_SUPPORTED
+			}
+			return v, nvml.SUCCESS
+		},
+		GetRetiredPagesFunc: func(cause nvml.PageRetirementCause) ([]uint64, nvml.Return) {
+			if cause == nvml.PAGE_RETIREMENT_CAUSE_DOUBLE_BIT_ECC_ERROR {
+				return []uint64{0x1000}, nvml.SUCCESS
+			}
+			return []uint64{0x2000, 0x3000}, nvml.SUCCESS
+		},
+		GetRetiredPagesPendingStatusFunc: func() (nvml.EnableState, nvml.Return) {
+			return nvml.FEATURE_ENABLED, nvml.SUCCESS
+		},
+		GetRemappedRowsFunc: func() (int, int, bool, bool, nvml.Return) {
+			return 4, 1, true, false, remapRet
+		},
+	}
+}
+
+func TestCollectEccDynamic(t *testing.T) {
+	all := map[eccKey]uint64{
+		{nvml.MEMORY_ERROR_TYPE_CORRECTED, nvml.VOLATILE_ECC}:    3,
+		{nvml.MEMORY_ERROR_TYPE_UNCORRECTED, nvml.VOLATILE_ECC}:  1,
+		{nvml.MEMORY_ERROR_TYPE_CORRECTED, nvml.AGGREGATE_ECC}:   30,
+		{nvml.MEMORY_ERROR_TYPE_UNCORRECTED, nvml.AGGREGATE_ECC}: 10,
+	}
+	volatileOnly := map[eccKey]uint64{
+		{nvml.MEMORY_ERROR_TYPE_CORRECTED, nvml.VOLATILE_ECC}:   7,
+		{nvml.MEMORY_ERROR_TYPE_UNCORRECTED, nvml.VOLATILE_ECC}: 2,
+	}
+
+	tests := []struct {
+		name     string
+		enabled  bool
+		totals   map[eccKey]uint64
+		remapRet nvml.Return
+		// volatile corrected/uncorrected, aggregate corrected/uncorrected
+		want      [4]int64
+		wantSet   [4]bool
+		wantRemap bool
+	}{
+		{"enabled", true, all, nvml.SUCCESS, [4]int64{3, 1, 30, 10}, [4]bool{true, true, true, true}, true},
+		{"disabled skips counters", false, all, nvml.SUCCESS, [4]int64{}, [4]bool{}, true},
+		{"unsupported counters stay unset", true, volatileOnly, nvml.SUCCESS, [4]int64{7, 2, 0, 0}, [4]bool{true, true, false, false}, true},
+		{"no row remapping", true, all, nvml.ERROR_NOT_SUPPORTED, [4]int64{3, 1, 30, 10}, [4]bool{true, true, true, true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e EccDynamic
+			CollectEccDynamic(eccDevice(tt.totals, tt.remapRet), tt.enabled, &e)
+
+			for i, m := range []struct{ V, T int64 }{
+				{e.VolatileCorrected.V, e.VolatileCorrected.T},
+				{e.VolatileUncorrected.V, e.VolatileUncorrected.T},
+				{e.AggregateCorrected.V, e.AggregateCorrected.T},
+				{e.AggregateUncorrected.V, e.AggregateUncorrected.T},
+			} {
+				if m.V != tt.want[i] || (m.T != 0) != tt.wantSet[i] {
+					t.Errorf("counter %d = %d (T=%d), want %d (set=%v)", i, m.V, m.T, tt.want[i], tt.wantSet[i])
+				}
+			}
+			if e.RetiredSingleBit.V != 2 || e.RetiredDoubleBit.V != 1 || e.RetiredPending.V != 1 {
+				t.Errorf("retired pages = %d/%d pending %d, want 2/1 pending 1",
+					e.RetiredSingleBit.V, e.RetiredDoubleBit.V, e.RetiredPending.V)
+			}
+			if got := e.RemappedCorrectable.T != 0; got != tt.wantRemap {
+				t.Fatalf("remapped rows set = %v, want %v", got, tt.wantRemap)
+			}
+			if tt.wantRemap && (e.RemappedCorrectable.V != 4 || e.RemappedUncorrectable.V != 1 ||
+				e.RemappingPending.V != 1 || e.RemappingFailed.V != 0) {
+				t.Errorf("remapped rows = %+v", e)
+			}
+		})
+	}
+}
+
+func TestCollectEccStatic(t *testing.T) {
+	tests := []struct {
+		name             string
+		current, pending nvml.EnableState
+		ret              nvml.Return
+		want             EccStatic
+	}{
+		{"enabled", nvml.FEATURE_ENABLED, nvml.FEATURE_ENABLED, nvml.SUCCESS, EccStatic{true, true}},
+		{"enabling on reboot", nvml.FEATURE_DISABLED, nvml.FEATURE_ENABLED, nvml.SUCCESS, EccStatic{false, true}},
+		{"unsupported", nvml.FEATURE_ENABLED, nvml.FEATURE_ENABLED, nvml.ERROR_NOT_SUPPORTED, EccStatic{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &mock.Device{GetEccModeFunc: func() (nvml.EnableState, nvml.EnableState, nvml.Return) {
+				return tt.current, tt.pending, tt.ret
+			}}
+			var e EccStatic
+			CollectEccStatic(d, &e)
+			if e != tt.want {
+				t.Errorf("got %+v, want %+v", e, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package domains
+
+import (
+	"InferenceProfiler/pkg/collecting/base"
+	"InferenceProfiler/pkg/utils"
+	"errors"
+	"sync"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+const watchedEvents = nvml.EventTypeXidCriticalError |
+	nvml.EventTypeSingleBitEccError |
+	nvml.EventTypeDoubleBitEccError
+
+type Event struct {
+	Type              string `json:"Type"`
+	Xid               int64  `json:"Xid"`
+	Timestamp         int64  `json:"Timestamp"`
+	GpuInstanceId     int64  `json:"GpuInstanceId"`
+	ComputeInstanceId int64  `json:"ComputeInstanceId"`
+}
+
+type Events struct {
+	XidCount          base.MetricInt `json:"XidCount"`
+	LastXid           base.MetricInt `json:"LastXid"`
+	SingleBitEccCount base.MetricInt `json:"SingleBitEccCount"`
+	DoubleBitEccCount base.MetricInt `json:"DoubleBitEccCount"`
+	Recent            []Event        `json:"Recent"`
+}
+
+type eventTotals struct {
+	xid, lastXid, sbe, dbe int64
+	lastXidTs              int64
+	pending                []Event
+}
+
+// EventWatcher registers every device on one NVML event set and drains it
+// without blocking on each poll. Counts are cumulative; Recent holds the
+// events seen since the previous drain.
+type EventWatcher struct {
+	mu      sync.Mutex
+	set     nvml.EventSet
+	devices []nvml.Device
+	totals  []eventTotals
+}
+
+func NewEventWatcher(set nvml.EventSet, devices []nvml.Device) (*EventWatcher, error) {
+	registered := 0
+	for i, d := range devices {
+		if ret := d.RegisterEvents(watchedEvents, set); ret != nvml.SUCCESS {
+			utils.Debugf("nvidia/events[%d]: RegisterEvents failed: %v", i, ret)
+			continue
+		}
+		registered++
+	}
+	if registered == 0 {
+		return nil, errors.New("no device supports event registration")
+	}
+	utils.Debugf("nvidia/events: watching %d/%d devices", registered, len(devices))
+
+	return &EventWatcher{
+		set:     set,
+		devices: devices,
+		totals:  make([]eventTotals, len(devices)),
+	}, nil
+}
+
+func (w *EventWatcher) drainLocked() {
+	for {
+		data, ret := w.set.Wait(0)
+		if ret != nvml.SUCCESS {
+			if ret != nvml.ERROR_TIMEOUT {
+				utils.Debugf("nvidia/events: Wait failed: %v", ret)
+			}
+			return
+		}
+		idx := w.indexOf(data.Device)
+		if idx < 0 {
+			utils.Debugf("nvidia/events: event for unknown device type=%d data=%d", data.EventType, data.EventData)
+			continue
+		}
+
+		t := &w.totals[idx]
+		ev := Event{
+			Timestamp:         utils.GetTimestamp(),
+			GpuInstanceId:     int64(data.GpuInstanceId),
+			ComputeInstanceId: int64(data.ComputeInstanceId),
+		}
+		switch data.EventType {
+		case nvml.EventTypeXidCriticalError:
+			ev.Type = "xid"
+			ev.Xid = int64(data.EventData)
+			t.xid++
+			t.lastXid = ev.Xid
+			t.lastXidTs = ev.Timestamp
+		case nvml.EventTypeSingleBitEccError:
+			ev.Type = "sbe"
+			t.sbe++
+		case nvml.EventTypeDoubleBitEccError:
+			ev.Type = "dbe"
+			t.dbe++
+		default:
+			continue
+		}
+		utils.Debugf("nvidia/events[%d]: %s xid=%d", idx, ev.Type, ev.Xid)
+		t.pending = append(t.pending, ev)
+	}
+}
+
+func (w *EventWatcher) indexOf(d nvml.Device) int {
+	for i, dev := range w.devices {
+		if dev == d {
+			return i
+		}
+	}
+	return -1
+}
+
+// Collect drains pending events and fills e for the device at index.
+func (w *EventWatcher) Collect(index int, e *Events) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.drainLocked()
+	if index < 0 || index >= len(w.totals) {
+		return
+	}
+	t := &w.totals[index]
+	now := utils.GetTimestamp()
+	e.XidCount = base.MetricInt{V: t.xid, T: now}
+	e.SingleBitEccCount = base.MetricInt{V: t.sbe, T: now}
+	e.DoubleBitEccCount = base.MetricInt{V: t.dbe, T: now}
+	if t.lastXidTs != 0 {
+		e.LastXid = base.MetricInt{V: t.lastXid, T: t.lastXidTs}
+	}
+	e.Recent = t.pending
+	t.pending = nil
+}
+
+func (w *EventWatcher) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if ret := w.set.Free(); ret != nvml.SUCCESS {
+		return ret
+	}
+	return nil
+}
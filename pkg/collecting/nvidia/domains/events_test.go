@@ -0,0 +1,141 @@
+package domains
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock"
+)
+
+// queueSet is an event set whose Wait(0) hands out queued events and then
+// times out, as NVML does once the set is drained. Unless fail is SUCCESS,
+// it is returned instead of ERROR_TIMEOUT once the queue is empty.
+func queueSet(t *testing.T, queue *[]nvml.EventData, fail nvml.Return) *mock.EventSet {
+	return &mock.EventSet{
+		WaitFunc: func(timeout uint32) (nvml.EventData, nvml.Return) {
+			if timeout != 0 {
+				t.Errorf("Wait(%d), want a non-blocking Wait(0)", timeout)
+			}
+			if len(*queue) == 0 {
+				if fail != nvml.SUCCESS {
+					return nvml.EventData{}, fail
+				}
+				return nvml.EventData{}, nvml.ERROR_TIMEOUT
+			}
+			ev := (*queue)[0]
+			*queue = (*queue)[1:]
+			return ev, nvml.SUCCESS
+		},
+		FreeFunc: func() nvml.Return { return nvml.SUCCESS },
+	}
+}
+
+func eventDevice(ret nvml.Return) *mock.Device {
+	return &mock.Device{RegisterEventsFunc: func(uint64, nvml.EventSet) nvml.Return { return ret }}
+}
+
+func TestEventWatcherDrain(t *testing.T) {
+	d0, d1, other := eventDevice(nvml.SUCCESS), eventDevice(nvml.SUCCESS), eventDevice(nvml.SUCCESS)
+
+	type counts struct{ xid, lastXid, sbe, dbe int64 }
+	tests := []struct {
+		name   string
+		queue  []nvml.EventData
+		fail   nvml.Return
+		want   [2]counts
+		recent [2][]string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "per device",
+			queue: []nvml.EventData{
+				{Device: d0, EventType: nvml.EventTypeXidCriticalError, EventData: 79},
+				{Device: d1, EventType: nvml.EventTypeSingleBitEccError},
+				{Device: d0, EventType: nvml.EventTypeXidCriticalError, EventData: 48},
+				{Device: d1, EventType: nvml.EventTypeDoubleBitEccError},
+			},
+			want:   [2]counts{{xid: 2, lastXid: 48}, {sbe: 1, dbe: 1}},
+			recent: [2][]string{{"xid", "xid"}, {"sbe", "dbe"}},
+		},
+		{
+			name: "unknown device and type skipped",
+			queue: []nvml.EventData{
+				{Device: other, EventType: nvml.EventTypeXidCriticalError, EventData: 13},
+				{Device: d0, EventType: nvml.EventTypePState},
+				{Device: d1, EventType: nvml.EventTypeXidCriticalError, EventData: 31},
+			},
+			want:   [2]counts{{}, {xid: 1, lastXid: 31}},
+			recent: [2][]string{nil, {"xid"}},
+		},
+		{
+			name: "error ends the drain",
+			queue: []nvml.EventData{
+				{Device: d0, EventType: nvml.EventTypeSingleBitEccError},
+			},
+			fail:   nvml.ERROR_UNKNOWN,
+			want:   [2]counts{{sbe: 1}, {}},
+			recent: [2][]string{{"sbe"}, nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := tt.queue
+			w, err := NewEventWatcher(queueSet(t, &queue, tt.fail), []nvml.Device{d0, d1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+
+			for i := range 2 {
+				var e Events
+				w.Collect(i, &e)
+				got := counts{e.XidCount.V, e.LastXid.V, e.SingleBitEccCount.V, e.DoubleBitEccCount.V}
+				if got != tt.want[i] {
+					t.Errorf("device %d: counts %+v, want %+v", i, got, tt.want[i])
+				}
+				var types []string
+				for _, ev := range e.Recent {
+					types = append(types, ev.Type)
+				}
+				if !slices.Equal(types, tt.recent[i]) {
+					t.Errorf("device %d: recent %v, want %v", i, types, tt.recent[i])
+				}
+			}
+			if len(queue) != 0 {
+				t.Errorf("%d events left in the set", len(queue))
+			}
+
+			// Counts are cumulative, Recent only covers the last drain.
+			var e Events
+			w.Collect(0, &e)
+			if e.XidCount.V != tt.want[0].xid || e.Recent != nil {
+				t.Errorf("second collect: xid %d recent %v, want %d and none", e.XidCount.V, e.Recent, tt.want[0].xid)
+			}
+		})
+	}
+}
+
+func TestNewEventWatcherUnsupported(t *testing.T) {
+	var queue []nvml.EventData
+	devices := []nvml.Device{eventDevice(nvml.ERROR_NOT_SUPPORTED), eventDevice(nvml.ERROR_NOT_SUPPORTED)}
+	if _, err := NewEventWatcher(queueSet(t, &queue, nvml.SUCCESS), devices); err == nil {
+		t.Fatal("want an error when no device registers")
+	}
+
+	devices[1] = eventDevice(nvml.SUCCESS)
+	if _, err := NewEventWatcher(queueSet(t, &queue, nvml.SUCCESS), devices); err != nil {
+		t.Fatalf("one device registering: %v", err)
+	}
+}
+
+func TestEventWatcherNil(t *testing.T) {
+	var w *EventWatcher
+	var e Events
+	w.Collect(0, &e)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,167 @@
+package domains
+
+import (
+	"InferenceProfiler/pkg/utils"
+	"bytes"
+	"fmt"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+type MigStatic struct {
+	Enabled        bool
+	PendingEnabled bool
+	Instances      []MigInstanceStatic
+}
+
+type MigInstanceStatic struct {
+	Index               int
+	ParentIndex         int
+	UUID                string
+	GpuInstanceId       int
+	ComputeInstanceId   int
+	ProfileId           int
+	ProfileName         string
+	SliceCount          int64
+	MultiprocessorCount int64
+	MemorySizeMB        int64
+}
+
+// MigInstanceDynamic has no utilization: GetUtilizationRates is
+// NOT_SUPPORTED on MIG handles, and per-instance activity is only available
+// through GPM sampling (Hopper and later), which the collector does not do.
+type MigInstanceDynamic struct {
+	Index             int           `json:"Index"`
+	ParentIndex       int           `json:"ParentIndex"`
+	GpuInstanceId     int           `json:"GpuInstanceId"`
+	ComputeInstanceId int           `json:"ComputeInstanceId"`
+	Memory            MemoryDynamic `json:"Memory"`
+	Processes         Processes     `json:"Processes"`
+}
+
+// CollectMigStatic reports the MIG mode of a physical device and, when MIG is
+// enabled, enumerates its MIG devices. The returned handles are in the same
+// order as m.Instances.
+func CollectMigStatic(d nvml.Device, parent int, m *MigStatic) []nvml.Device {
+	current, pending, ret := d.GetMigMode()
+	if ret != nvml.SUCCESS {
+		utils.Debugf("nvidia/mig[%d]: GetMigMode failed: %v", parent, ret)
+		return nil
+	}
+	m.Enabled = current == nvml.DEVICE_MIG_ENABLE
+	m.PendingEnabled = pending == nvml.DEVICE_MIG_ENABLE
+	if !m.Enabled {
+		utils.Debugf("nvidia/mig[%d]: disabled (pending=%v)", parent, m.PendingEnabled)
+		return nil
+	}
+
+	count, ret := d.GetMaxMigDeviceCount()
+	if ret != nvml.SUCCESS {
+		utils.Debugf("nvidia/mig[%d]: GetMaxMigDeviceCount failed: %v", parent, ret)
+		return nil
+	}
+
+	var handles []nvml.Device
+	for i := 0; i < count; i++ {
+		mig, ret := d.GetMigDeviceHandleByIndex(i)
+		if ret != nvml.SUCCESS {
+			// Slots without a configured instance return NOT_FOUND.
+			continue
+		}
+		s := MigInstanceStatic{Index: i, ParentIndex: parent}
+		collectMigInstanceStatic(d, mig, &s)
+		m.Instances = append(m.Instances, s)
+		handles = append(handles, mig)
+	}
+
+	utils.Debugf("nvidia/mig[%d]: enabled with %d instance(s)", parent, len(m.Instances))
+	return handles
+}
+
+func collectMigInstanceStatic(parent, mig nvml.Device, s *MigInstanceStatic) {
+	if uuid, ret := mig.GetUUID(); ret == nvml.SUCCESS {
+		s.UUID = uuid
+	} else {
+		utils.Debugf("nvidia/mig: GetUUID failed: %v", ret)
+	}
+	if id, ret := mig.GetGpuInstanceId(); ret == nvml.SUCCESS {
+		s.GpuInstanceId = id
+	} else {
+		utils.Debugf("nvidia/mig: GetGpuInstanceId failed: %v", ret)
+	}
+	if id, ret := mig.GetComputeInstanceId(); ret == nvml.SUCCESS {
+		s.ComputeInstanceId = id
+	} else {
+		utils.Debugf("nvidia/mig: GetComputeInstanceId failed: %v", ret)
+	}
+	if attr, ret := mig.GetAttributes(); ret == nvml.SUCCESS {
+		s.SliceCount = int64(attr.GpuInstanceSliceCount)
+		s.MultiprocessorCount = int64(attr.MultiprocessorCount)
+		s.MemorySizeMB = int64(attr.MemorySizeMB)
+	} else {
+		utils.Debugf("nvidia/mig: GetAttributes failed: %v", ret)
+	}
+
+	gi, ret := parent.GetGpuInstanceById(s.GpuInstanceId)
+	if ret != nvml.SUCCESS {
+		utils.Debugf("nvidia/mig: GetGpuInstanceById(%d) failed: %v", s.GpuInstanceId, ret)
+		return
+	}
+	info, ret := gi.GetInfo()
+	if ret != nvml.SUCCESS {
+		utils.Debugf("nvidia/mig: GpuInstance.GetInfo failed: %v", ret)
+		return
+	}
+	s.ProfileId = int(info.ProfileId)
+	if name, ret := gpuInstanceProfileName(parent, s.ProfileId); ret == nvml.SUCCESS {
+		s.ProfileName = name
+	} else {
+		utils.Debugf("nvidia/mig: GetGpuInstanceProfileInfoByIdV(%d) failed: %v", s.ProfileId, ret)
+	}
+	utils.Debugf("nvidia/mig: instance gi=%d ci=%d profile=%d (%s) uuid=%s",
+		s.GpuInstanceId, s.ComputeInstanceId, s.ProfileId, s.ProfileName, s.UUID)
+}
+
+// gpuInstanceProfileName returns the driver's name for a GPU instance
+// profile, the one nvidia-smi shows, e.g. "MIG 1g.10gb". It is a variable
+// because the versioned profile info handler cannot be mocked.
+var gpuInstanceProfileName = func(d nvml.Device, profileId int) (string, nvml.Return) {
+	info, ret := d.GetGpuInstanceProfileInfoByIdV(profileId).V2()
+	if ret != nvml.SUCCESS {
+		return "", ret
+	}
+	name := info.Name[:]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	return string(name), nvml.SUCCESS
+}
+
+func CollectMigDynamic(mig nvml.Device, s *MigInstanceStatic, collectProcesses bool, m *MigInstanceDynamic) {
+	m.Index = s.Index
+	m.ParentIndex = s.ParentIndex
+	m.GpuInstanceId = s.GpuInstanceId
+	m.ComputeInstanceId = s.ComputeInstanceId
+
+	CollectMemoryDynamic(mig, &m.Memory)
+	if collectProcesses {
+		CollectProcessesDynamic(mig, &m.Processes)
+	}
+}
+
+func VirtualizationModeName(mode nvml.GpuVirtualizationMode) string {
+	switch mode {
+	case nvml.GPU_VIRTUALIZATION_MODE_NONE:
+		return "none"
+	case nvml.GPU_VIRTUALIZATION_MODE_PASSTHROUGH:
+		return "passthrough"
+	case nvml.GPU_VIRTUALIZATION_MODE_VGPU:
+		return "vgpu"
+	case nvml.GPU_VIRTUALIZATION_MODE_HOST_VGPU:
+		return "host-vgpu"
+	case nvml.GPU_VIRTUALIZATION_MODE_HOST_VSGA:
+		return "host-vsga"
+	default:
+		return fmt.Sprintf("unknown(%d)", mode)
+	}
+}
@@ -0,0 +1,179 @@
+package domains
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock"
+)
+
+// migSlot is one configured MIG device: its GPU and compute instance, the
+// GPU instance's profile and the attributes and memory it reports.
+type migSlot struct {
+	gi, ci, profile int
+	slices, sms     uint32
+	memoryMB        uint64
+	used, free      uint64
+}
+
+// migDevice returns a physical device in the given MIG mode with count
+// slots, of which the keys of slots are configured, and their MIG handles.
+// The handles mock only what CollectMigDynamic should call.
+func migDevice(mode int, count int, slots map[int]migSlot) (*mock.Device, map[int]*mock.Device) {
+	handles := make(map[int]*mock.Device)
+	for i, s := range slots {
+		handles[i] = &mock.Device{
+			GetUUIDFunc:              func() (string, nvml.Return) { return "MIG-" + string(rune('a'+i)), nvml.SUCCESS },
+			GetGpuInstanceIdFunc:     func() (int, nvml.Return) { return s.gi, nvml.SUCCESS },
+			GetComputeInstanceIdFunc: func() (int, nvml.Return) { return s.ci, nvml.SUCCESS },
+			GetAttributesFunc: func() (nvml.DeviceAttributes, nvml.Return) {
+				return nvml.DeviceAttributes{GpuInstanceSliceCount: s.slices, MultiprocessorCount: s.sms, MemorySizeMB: s.memoryMB}, nvml.SUCCESS
+			},
+			GetMemoryInfoFunc: func() (nvml.Memory, nvml.Return) {
+				return nvml.Memory{Total: s.used + s.free, Used: s.used, Free: s.free}, nvml.SUCCESS
+			},
+			GetBAR1MemoryInfoFunc: func() (nvml.BAR1Memory, nvml.Return) {
+				return nvml.BAR1Memory{}, nvml.ERROR_NOT_SUPPORTED
+			},
+			GetComputeRunningProcessesFunc: func() ([]nvml.ProcessInfo, nvml.Return) {
+				return []nvml.ProcessInfo{{Pid: uint32(100 + i), UsedGpuMemory: s.used, GpuInstanceId: uint32(s.gi)}}, nvml.SUCCESS
+			},
+			GetGraphicsRunningProcessesFunc: func() ([]nvml.ProcessInfo, nvml.Return) {
+				return nil, nvml.SUCCESS
+			},
+			GetMPSComputeRunningProcessesFunc: func() ([]nvml.ProcessInfo, nvml.Return) {
+				return nil, nvml.ERROR_NOT_SUPPORTED
+			},
+			GetProcessUtilizationFunc: func(uint64) ([]nvml.ProcessUtilizationSample, nvml.Return) {
+				return nil, nvml.ERROR_NOT_FOUND
+			},
+		}
+	}
+	parent := &mock.Device{
+		GetMigModeFunc: func() (int, int, nvml.Return) {
+			return mode, nvml.DEVICE_MIG_ENABLE, nvml.SUCCESS
+		},
+		GetMaxMigDeviceCountFunc: func() (int, nvml.Return) { return count, nvml.SUCCESS },
+		GetMigDeviceHandleByIndexFunc: func(n int) (nvml.Device, nvml.Return) {
+			if h, ok := handles[n]; ok {
+				return h, nvml.SUCCESS
+			}
+			return nil, nvml.ERROR_NOT_FOUND
+		},
+		GetGpuInstanceByIdFunc: func(id int) (nvml.GpuInstance, nvml.Return) {
+			for _, s := range slots {
+				if s.gi == id {
+					return &mock.GpuInstance{GetInfoFunc: func() (nvml.GpuInstanceInfo, nvml.Return) {
+						return nvml.GpuInstanceInfo{Id: uint32(id), ProfileId: uint32(s.profile)}, nvml.SUCCESS
+					}}, nvml.SUCCESS
+				}
+			}
+			return nil, nvml.ERROR_NOT_FOUND
+		},
+	}
+	return parent, handles
+}
+
+// withProfileNames makes gpuInstanceProfileName answer from names for the
+// rest of the test.
+func withProfileNames(t *testing.T, names map[int]string) {
+	saved := gpuInstanceProfileName
+	t.Cleanup(func() { gpuInstanceProfileName = saved })
+	gpuInstanceProfileName = func(d nvml.Device, profileId int) (string, nvml.Return) {
+		if name, ok := names[profileId]; ok {
+			return name, nvml.SUCCESS
+		}
+		return "", nvml.ERROR_NOT_SUPPORTED
+	}
+}
+
+var twoSlots = map[int]migSlot{
+	0: {gi: 1, ci: 0, profile: 19, slices: 1, sms: 14, memoryMB: 9984, used: 1 << 30, free: 8 << 30},
+	2: {gi: 5, ci: 0, profile: 9, slices: 3, sms: 42, memoryMB: 39936, used: 4 << 30, free: 35 << 30},
+}
+
+func TestCollectMigStatic(t *testing.T) {
+	withProfileNames(t, map[int]string{19: "MIG 1g.10gb"})
+
+	t.Run("enabled", func(t *testing.T) {
+		parent, handles := migDevice(nvml.DEVICE_MIG_ENABLE, 4, twoSlots)
+		var m MigStatic
+		got := CollectMigStatic(parent, 3, &m)
+
+		if !m.Enabled || !m.PendingEnabled || len(m.Instances) != 2 {
+			t.Fatalf("MigStatic = %+v, want enabled with 2 instances", m)
+		}
+		if want := []nvml.Device{handles[0], handles[2]}; !slices.Equal(got, want) {
+			t.Errorf("handles = %v, want those of slots 0 and 2", got)
+		}
+		want := MigInstanceStatic{Index: 0, ParentIndex: 3, UUID: "MIG-a", GpuInstanceId: 1, ProfileId: 19,
+			ProfileName: "MIG 1g.10gb", SliceCount: 1, MultiprocessorCount: 14, MemorySizeMB: 9984}
+		if m.Instances[0] != want {
+			t.Errorf("instance 0 = %+v, want %+v", m.Instances[0], want)
+		}
+		// The driver has no name for profile 9: none is made up.
+		if s := m.Instances[1]; s.Index != 2 || s.GpuInstanceId != 5 || s.ProfileId != 9 || s.ProfileName != "" {
+			t.Errorf("instance 1 = %+v, want slot 2, profile 9 without a name", s)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		parent, _ := migDevice(nvml.DEVICE_MIG_DISABLE, 4, twoSlots)
+		var m MigStatic
+		if got := CollectMigStatic(parent, 0, &m); got != nil || m.Enabled || !m.PendingEnabled || m.Instances != nil {
+			t.Errorf("CollectMigStatic = %v, %+v, want no handles, pending only", got, m)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		parent := &mock.Device{GetMigModeFunc: func() (int, int, nvml.Return) {
+			return 0, 0, nvml.ERROR_NOT_SUPPORTED
+		}}
+		var m MigStatic
+		if got := CollectMigStatic(parent, 0, &m); got != nil || m.Enabled {
+			t.Errorf("CollectMigStatic = %v, %+v, want nothing", got, m)
+		}
+	})
+}
+
+func TestCollectMigDynamic(t *testing.T) {
+	withProfileNames(t, nil)
+	parent, _ := migDevice(nvml.DEVICE_MIG_ENABLE, 4, twoSlots)
+	var static MigStatic
+	handles := CollectMigStatic(parent, 1, &static)
+
+	for _, processes := range []bool{true, false} {
+		var m MigInstanceDynamic
+		// The mock panics on GetUtilizationRates, which MIG handles do
+		// not support.
+		CollectMigDynamic(handles[1], &static.Instances[1], processes, &m)
+
+		if m.Index != 2 || m.ParentIndex != 1 || m.GpuInstanceId != 5 || m.ComputeInstanceId != 0 {
+			t.Errorf("ids = %+v", m)
+		}
+		if m.Memory.Used.V != 4<<30 || m.Memory.Free.V != 35<<30 || m.Memory.Used.T == 0 || m.Memory.Bar1Used.T != 0 {
+			t.Errorf("Memory = %+v", m.Memory)
+		}
+		if wantCount := map[bool]int{true: 1, false: 0}[processes]; m.Processes.Count != wantCount {
+			t.Errorf("processes=%v: %d processes, want %d", processes, m.Processes.Count, wantCount)
+		} else if processes && m.Processes.List[0].PID != 102 {
+			t.Errorf("processes = %+v", m.Processes.List)
+		}
+
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{`"Index":2`, `"ParentIndex":1`, `"GpuInstanceId":5`, `"Memory":{`, `"Processes":{`} {
+			if !strings.Contains(string(data), key) {
+				t.Errorf("JSON %s lacks %s", data, key)
+			}
+		}
+		if strings.Contains(string(data), "Utilization") {
+			t.Errorf("JSON %s has a utilization", data)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package domains
+
+import (
+	"InferenceProfiler/pkg/collecting/base"
+	"InferenceProfiler/pkg/utils"
+	"encoding/binary"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+type NvLinkStatic struct {
+	Links    int
+	Versions []int64
+}
+
+func CollectNvLinkStatic(d nvml.Device, n *NvLinkStatic) {
+	n.Links = 0
+	n.Versions = n.Versions[:0]
+	for link := 0; link < nvml.NVLINK_MAX_LINKS; link++ {
+		if _, ret := d.GetNvLinkState(link); ret != nvml.SUCCESS {
+			if link == 0 {
+				utils.Debugf("nvidia/nvlink: GetNvLinkState(0) failed: %v", ret)
+			}
+			break
+		}
+		n.Links++
+		if v, ret := d.GetNvLinkVersion(link); ret == nvml.SUCCESS {
+			n.Versions = append(n.Versions, int64(v))
+		} else {
+			utils.Debugf("nvidia/nvlink: GetNvLinkVersion(%d) failed: %v", link, ret)
+			n.Versions = append(n.Versions, 0)
+		}
+	}
+	utils.Debugf("nvidia/nvlink: static links=%d versions=%v", n.Links, n.Versions)
+}
+
+type NvLinkDynamic struct {
+	ActiveLinks    base.MetricInt `json:"ActiveLinks"`
+	TxThroughput   base.MetricInt `json:"TxThroughput"`
+	RxThroughput   base.MetricInt `json:"RxThroughput"`
+	ReplayErrors   base.MetricInt `json:"ReplayErrors"`
+	RecoveryErrors base.MetricInt `json:"RecoveryErrors"`
+	CrcFlitErrors  base.MetricInt `json:"CrcFlitErrors"`
+	CrcDataErrors  base.MetricInt `json:"CrcDataErrors"`
+}
+
+var nvLinkErrorCounters = []nvml.NvLinkErrorCounter{
+	nvml.NVLINK_ERROR_DL_REPLAY,
+	nvml.NVLINK_ERROR_DL_RECOVERY,
+	nvml.NVLINK_ERROR_DL_CRC_FLIT,
+	nvml.NVLINK_ERROR_DL_CRC_DATA,
+}
+
+// CollectNvLinkDynamic sums state, error counters and throughput (KiB) over
+// the links discovered in CollectNvLinkStatic.
+func CollectNvLinkDynamic(d nvml.Device, links int, n *NvLinkDynamic) {
+	if links == 0 {
+		return
+	}
+
+	var active int64
+	var errs [4]int64
+	for link := 0; link < links; link++ {
+		if state, ret := d.GetNvLinkState(link); ret == nvml.SUCCESS {
+			if state == nvml.FEATURE_ENABLED {
+				active++
+			}
+		} else {
+			utils.Debugf("nvidia/nvlink: GetNvLinkState(%d) failed: %v", link, ret)
+		}
+		for i, counter := range nvLinkErrorCounters {
+			if v, ret := d.GetNvLinkErrorCounter(link, counter); ret == nvml.SUCCESS {
+				errs[i] += int64(v)
+			} else {
+				utils.Debugf("nvidia/nvlink: GetNvLinkErrorCounter(%d, %d) failed: %v", link, counter, ret)
+			}
+		}
+	}
+	now := utils.GetTimestamp()
+	n.ActiveLinks = base.MetricInt{V: active, T: now}
+	n.ReplayErrors = base.MetricInt{V: errs[0], T: now}
+	n.RecoveryErrors = base.MetricInt{V: errs[1], T: now}
+	n.CrcFlitErrors = base.MetricInt{V: errs[2], T: now}
+	n.CrcDataErrors = base.MetricInt{V: errs[3], T: now}
+
+	values := make([]nvml.FieldValue, 0, 2*links)
+	for link := 0; link < links; link++ {
+		values = append(values,
+			nvml.FieldValue{FieldId: nvml.FI_DEV_NVLINK_THROUGHPUT_DATA_TX, ScopeId: uint32(link)},
+			nvml.FieldValue{FieldId: nvml.FI_DEV_NVLINK_THROUGHPUT_DATA_RX, ScopeId: uint32(link)},
+		)
+	}
+	if ret := d.GetFieldValues(values); ret != nvml.SUCCESS {
+		utils.Debugf("nvidia/nvlink: GetFieldValues(throughput) failed: %v", ret)
+		return
+	}
+	var tx, rx int64
+	for _, v := range values {
+		if nvml.Return(v.NvmlReturn) != nvml.SUCCESS {
+			continue
+		}
+		switch v.FieldId {
+		case nvml.FI_DEV_NVLINK_THROUGHPUT_DATA_TX:
+			tx += int64(binary.LittleEndian.Uint64(v.Value[:]))
+		case nvml.FI_DEV_NVLINK_THROUGHPUT_DATA_RX:
+			rx += int64(binary.LittleEndian.Uint64(v.Value[:]))
+		}
+	}
+	now = utils.GetTimestamp()
+	n.TxThroughput = base.MetricInt{V: tx, T: now}
+	n.RxThroughput = base.MetricInt{V: rx, T: now}
+}
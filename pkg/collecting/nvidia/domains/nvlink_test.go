@@ -0,0 +1,130 @@
+package domains
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/go-nvml/pkg/nvml/mock"
+)
+
+// link is one simulated NvLink: its state, error counters in
+// nvLinkErrorCounters order and throughput field values.
+type link struct {
+	up     bool
+	errs   [4]uint64
+	tx, rx uint64
+	txRet  nvml.Return // per-field NvmlReturn of the TX value
+}
+
+func nvLinkDevice(links []link, fieldsRet nvml.Return) *mock.Device {
+	return &mock.Device{
+		GetNvLinkStateFunc: func(n int) (nvml.EnableState, nvml.Return) {
+			if n >= len(links) {
+				return 0, nvml.ERROR_INVALID_ARGUMENT
+			}
+			if links[n].up {
+				return nvml.FEATURE_ENABLED, nvml.SUCCESS
+			}
+			return nvml.FEATURE_DISABLED, nvml.SUCCESS
+		},
+		GetNvLinkVersionFunc: func(n int) (uint32, nvml.Return) { return 4, nvml.SUCCESS },
+		GetNvLinkErrorCounterFunc: func(n int, c nvml.NvLinkErrorCounter) (uint64, nvml.Return) {
+			for i, counter := range nvLinkErrorCounters {
+				if counter == c {
+					return links[n].errs[i], nvml.SUCCESS
+				}
+			}
+			return 0, nvml.ERROR_NOT_SUPPORTED
+		},
+		GetFieldValuesFunc: func(values []nvml.FieldValue) nvml.Return {
+			if fieldsRet != nvml.SUCCESS {
+				return fieldsRet
+			}
+			for i := range values {
+				v := &values[i]
+				l := links[v.ScopeId]
+				switch v.FieldId {
+				case nvml.FI_DEV_NVLINK_THROUGHPUT_DATA_TX:
+					v.NvmlReturn = uint32(l.txRet)
+					binary.LittleEndian.PutUint64(v.Value[:], l.tx)
+				case nvml.FI_DEV_NVLINK_THROUGHPUT_DATA_RX:
+					binary.LittleEndian.PutUint64(v.Value[:], l.rx)
+				}
+			}
+			return nvml.SUCCESS
+		},
+	}
+}
+
+func TestCollectNvLinkDynamic(t *testing.T) {
+	four := []link{
+		{up: true, errs: [4]uint64{1, 0, 2, 0}, tx: 100, rx: 10},
+		{up: true, errs: [4]uint64{0, 1, 0, 3}, tx: 200, rx: 20},
+		{up: false, tx: 0, rx: 0},
+		{up: true, errs: [4]uint64{5, 0, 0, 0}, tx: 300, rx: 30},
+	}
+	partial := []link{
+		{up: true, tx: 100, rx: 10},
+		{up: true, tx: 999, rx: 20, txRet: nvml.ERROR_NOT_SUPPORTED},
+	}
+
+	type want struct {
+		active, tx, rx int64
+		errs           [4]int64
+		throughputSet  bool
+	}
+	tests := []struct {
+		name      string
+		links     []link
+		fieldsRet nvml.Return
+		want      want
+	}{
+		{"sums over links", four, nvml.SUCCESS, want{3, 600, 60, [4]int64{6, 1, 2, 3}, true}},
+		{"failed fields skipped", partial, nvml.SUCCESS, want{2, 100, 30, [4]int64{}, true}},
+		{"no field values", four, nvml.ERROR_NOT_SUPPORTED, want{3, 0, 0, [4]int64{6, 1, 2, 3}, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := nvLinkDevice(tt.links, tt.fieldsRet)
+			var s NvLinkStatic
+			CollectNvLinkStatic(d, &s)
+			if s.Links != len(tt.links) {
+				t.Fatalf("static links = %d, want %d", s.Links, len(tt.links))
+			}
+
+			var n NvLinkDynamic
+			CollectNvLinkDynamic(d, s.Links, &n)
+			got := want{
+				active:        n.ActiveLinks.V,
+				tx:            n.TxThroughput.V,
+				rx:            n.RxThroughput.V,
+				errs:          [4]int64{n.ReplayErrors.V, n.RecoveryErrors.V, n.CrcFlitErrors.V, n.CrcDataErrors.V},
+				throughputSet: n.TxThroughput.T != 0,
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if calls := len(d.GetFieldValuesCalls()); calls != 1 {
+				t.Errorf("GetFieldValues called %d times, want one batched call", calls)
+			}
+		})
+	}
+}
+
+func TestCollectNvLinkNoLinks(t *testing.T) {
+	d := &mock.Device{GetNvLinkStateFunc: func(int) (nvml.EnableState, nvml.Return) {
+		return 0, nvml.ERROR_NOT_SUPPORTED
+	}}
+	var s NvLinkStatic
+	CollectNvLinkStatic(d, &s)
+	if s.Links != 0 || len(s.Versions) != 0 {
+		t.Fatalf("static = %+v, want no links", s)
+	}
+	// Any other call would panic on the mock.
+	var n NvLinkDynamic
+	CollectNvLinkDynamic(d, 0, &n)
+	if n != (NvLinkDynamic{}) {
+		t.Errorf("dynamic = %+v, want zero", n)
+	}
+}
@@ -16,25 +16,29 @@ type Processes struct {
 }
 
 type ProcessStats struct {
-	PID        uint32         `json:"PID"`
-	Type       base.MetricStr `json:"Type"`
-	SM         base.MetricInt `json:"SM"`
-	MemUtil    base.MetricInt `json:"MemUtil"`
-	Encoder    base.MetricInt `json:"Encoder"`
-	Decoder    base.MetricInt `json:"Decoder"`
-	MemoryUsed base.MetricInt `json:"MemoryUsed"`
+	PID               uint32         `json:"PID"`
+	GpuInstanceId     uint32         `json:"GpuInstanceId"`
+	ComputeInstanceId uint32         `json:"ComputeInstanceId"`
+	Type              base.MetricStr `json:"Type"`
+	SM                base.MetricInt `json:"SM"`
+	MemUtil           base.MetricInt `json:"MemUtil"`
+	Encoder           base.MetricInt `json:"Encoder"`
+	Decoder           base.MetricInt `json:"Decoder"`
+	MemoryUsed        base.MetricInt `json:"MemoryUsed"`
 }
 
 func CollectProcessesDynamic(d nvml.Device, p *Processes) {
 	procMap := make(map[uint32]*ProcessStats)
 	p.List = p.List[:0]
 
-	addProcess := func(pid uint32, mem uint64, procType string, t int64) {
-		if _, exists := procMap[pid]; !exists {
-			procMap[pid] = &ProcessStats{
-				PID:        pid,
-				MemoryUsed: base.MetricInt{V: int64(mem), T: t},
-				Type:       base.MetricStr{V: procType, T: t},
+	addProcess := func(proc nvml.ProcessInfo, procType string, t int64) {
+		if _, exists := procMap[proc.Pid]; !exists {
+			procMap[proc.Pid] = &ProcessStats{
+				PID:               proc.Pid,
+				GpuInstanceId:     proc.GpuInstanceId,
+				ComputeInstanceId: proc.ComputeInstanceId,
+				MemoryUsed:        base.MetricInt{V: int64(proc.UsedGpuMemory), T: t},
+				Type:              base.MetricStr{V: procType, T: t},
 			}
 		}
 	}
@@ -42,7 +46,7 @@ func CollectProcessesDynamic(d nvml.Device, p *Processes) {
 	if list, ret := d.GetComputeRunningProcesses(); errors.Is(ret, nvml.SUCCESS) {
 		now := utils.GetTimestamp()
 		for _, proc := range list {
-			addProcess(proc.Pid, proc.UsedGpuMemory, "compute", now)
+			addProcess(proc, "compute", now)
 		}
 		utils.Debugf("nvidia/processes: %d compute processes", len(list))
 	} else {
@@ -52,7 +56,7 @@ func CollectProcessesDynamic(d nvml.Device, p *Processes) {
 	if list, ret := d.GetGraphicsRunningProcesses(); ret == nvml.SUCCESS {
 		now := utils.GetTimestamp()
 		for _, proc := range list {
-			addProcess(proc.Pid, proc.UsedGpuMemory, "graphics", now)
+			addProcess(proc, "graphics", now)
 		}
 		if len(list) > 0 {
 			utils.Debugf("nvidia/processes: %d graphics processes", len(list))
@@ -64,7 +68,7 @@ func CollectProcessesDynamic(d nvml.Device, p *Processes) {
 	if list, ret := d.GetMPSComputeRunningProcesses(); ret == nvml.SUCCESS {
 		now := utils.GetTimestamp()
 		for _, proc := range list {
-			addProcess(proc.Pid, proc.UsedGpuMemory, "mps", now)
+			addProcess(proc, "mps", now)
 		}
 		if len(list) > 0 {
 			utils.Debugf("nvidia/processes: %d MPS processes", len(list))
@@ -0,0 +1,119 @@
+package domains
+
+import (
+	"InferenceProfiler/pkg/collecting/base"
+	"InferenceProfiler/pkg/utils"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// Samples holds every NVML buffer sample taken since the previous poll.
+// Each series is a JSON array of [timestampUs, value] pairs, the same
+// string-encoded layout the vLLM histograms use.
+type Samples struct {
+	Power             base.MetricStr `json:"Power"`
+	GPUUtilization    base.MetricStr `json:"GPUUtilization"`
+	MemoryUtilization base.MetricStr `json:"MemoryUtilization"`
+	SMClock           base.MetricStr `json:"SMClock"`
+	MemoryClock       base.MetricStr `json:"MemoryClock"`
+}
+
+type sampleSeries struct {
+	kind nvml.SamplingType
+	name string
+	dst  func(*Samples) *base.MetricStr
+}
+
+var sampleSeriesList = []sampleSeries{
+	{nvml.TOTAL_POWER_SAMPLES, "power", func(s *Samples) *base.MetricStr { return &s.Power }},
+	{nvml.GPU_UTILIZATION_SAMPLES, "gpu-util", func(s *Samples) *base.MetricStr { return &s.GPUUtilization }},
+	{nvml.MEMORY_UTILIZATION_SAMPLES, "mem-util", func(s *Samples) *base.MetricStr { return &s.MemoryUtilization }},
+	{nvml.PROCESSOR_CLK_SAMPLES, "sm-clock", func(s *Samples) *base.MetricStr { return &s.SMClock }},
+	{nvml.MEMORY_CLK_SAMPLES, "mem-clock", func(s *Samples) *base.MetricStr { return &s.MemoryClock }},
+}
+
+// SampleBuffer remembers the last timestamp drained for each sampling type
+// of one device so every sample is emitted exactly once.
+type SampleBuffer struct {
+	mu          sync.Mutex
+	lastSeen    map[nvml.SamplingType]uint64
+	unsupported map[nvml.SamplingType]bool
+}
+
+func NewSampleBuffer() *SampleBuffer {
+	now := uint64(time.Now().UnixMicro())
+	b := &SampleBuffer{
+		lastSeen:    make(map[nvml.SamplingType]uint64, len(sampleSeriesList)),
+		unsupported: make(map[nvml.SamplingType]bool),
+	}
+	for _, s := range sampleSeriesList {
+		b.lastSeen[s.kind] = now
+	}
+	return b
+}
+
+func (b *SampleBuffer) Collect(d nvml.Device, out *Samples) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, series := range sampleSeriesList {
+		if b.unsupported[series.kind] {
+			continue
+		}
+		vt, samples, ret := d.GetSamples(series.kind, b.lastSeen[series.kind])
+		now := utils.GetTimestamp()
+		switch ret {
+		case nvml.SUCCESS:
+		case nvml.ERROR_NOT_FOUND:
+			// No new samples since lastSeen.
+			*series.dst(out) = base.MetricStr{V: "[]", T: now}
+			continue
+		case nvml.ERROR_NOT_SUPPORTED:
+			utils.Debugf("nvidia/samples: %s not supported, skipping from now on", series.name)
+			b.unsupported[series.kind] = true
+			continue
+		default:
+			utils.Debugf("nvidia/samples: GetSamples(%s) failed: %v", series.name, ret)
+			continue
+		}
+
+		pairs := make([][2]float64, 0, len(samples))
+		for _, s := range samples {
+			if s.TimeStamp <= b.lastSeen[series.kind] {
+				continue
+			}
+			pairs = append(pairs, [2]float64{float64(s.TimeStamp), decodeSample(vt, s.SampleValue)})
+			b.lastSeen[series.kind] = s.TimeStamp
+		}
+		data, err := json.Marshal(pairs)
+		if err != nil {
+			utils.Debugf("nvidia/samples: marshal %s failed: %v", series.name, err)
+			continue
+		}
+		*series.dst(out) = base.MetricStr{V: string(data), T: now}
+	}
+}
+
+func decodeSample(vt nvml.ValueType, raw [8]byte) float64 {
+	switch vt {
+	case nvml.VALUE_TYPE_DOUBLE:
+		return math.Float64frombits(binary.LittleEndian.Uint64(raw[:]))
+	case nvml.VALUE_TYPE_UNSIGNED_INT:
+		return float64(binary.LittleEndian.Uint32(raw[:4]))
+	case nvml.VALUE_TYPE_UNSIGNED_LONG, nvml.VALUE_TYPE_UNSIGNED_LONG_LONG:
+		return float64(binary.LittleEndian.Uint64(raw[:]))
+	case nvml.VALUE_TYPE_SIGNED_LONG_LONG:
+		return float64(int64(binary.LittleEndian.Uint64(raw[:])))
+	case nvml.VALUE_TYPE_SIGNED_INT:
+		return float64(int32(binary.LittleEndian.Uint32(raw[:4])))
+	case nvml.VALUE_TYPE_UNSIGNED_SHORT:
+		return float64(binary.LittleEndian.Uint16(raw[:2]))
+	default:
+		return 0
+	}
+}
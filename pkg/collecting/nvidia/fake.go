@@ -0,0 +1,468 @@
+package nvidia
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+const (
+	fakeMemoryTotal  = 16 << 30
+	fakeBar1Total    = 256 << 20
+	fakeIdlePowerMw  = 30_000
+	fakeMaxPowerMw   = 300_000
+	fakeMaxSMClock   = 1980
+	fakeMaxMemClock  = 2619
+	fakeIdleSMClock  = 210
+	fakeSamplePeriod = 20 * time.Millisecond
+	fakeMaxSamples   = 120
+	fakeHang         = 30 * time.Second
+)
+
+var fakeErrorCodes = map[string]nvml.Return{
+	"not_supported": nvml.ERROR_NOT_SUPPORTED,
+	"not_found":     nvml.ERROR_NOT_FOUND,
+	"no_permission": nvml.ERROR_NO_PERMISSION,
+	"timeout":       nvml.ERROR_TIMEOUT,
+	"gpu_lost":      nvml.ERROR_GPU_IS_LOST,
+	"unknown":       nvml.ERROR_UNKNOWN,
+}
+
+// Pseudo error codes that misbehave instead of returning: "hang" blocks the
+// call for fakeHang, or until fakeUnhang is closed, and "panic" panics, to
+// exercise the poll watchdog.
+const (
+	fakeReturnHang  nvml.Return = -1
+	fakeReturnPanic nvml.Return = -2
+)
+
+// fakeUnhang ends hung calls when closed, so tests do not leave them
+// behind. A library takes the channel set when it is created.
+var fakeUnhang chan struct{}
+
+// fakeLibrary simulates count GPUs whose load follows a scripted curve.
+// Methods named in errs return the configured error instead of a value, so
+// unsupported-feature paths can be exercised without hardware.
+type fakeLibrary struct {
+	devices []*fakeDevice
+	curve   func(t float64) float64
+	period  time.Duration
+	errs    map[string]nvml.Return
+	start   time.Time
+	unhang  <-chan struct{}
+}
+
+func newFakeLibrary(count int, curve, errs string) (*fakeLibrary, error) {
+	f := &fakeLibrary{errs: make(map[string]nvml.Return), start: time.Now(), unhang: fakeUnhang}
+
+	name, period, _ := strings.Cut(curve, ":")
+	f.period = time.Minute
+	if period != "" {
+		d, err := time.ParseDuration(period)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("nvml-fake-curve: invalid period %q", period)
+		}
+		f.period = d
+	}
+	switch name {
+	case "constant":
+		f.curve = func(float64) float64 { return 0.5 }
+	case "idle":
+		f.curve = func(float64) float64 { return 0 }
+	case "sine", "":
+		f.curve = func(t float64) float64 { return 0.5 + 0.5*math.Sin(2*math.Pi*t) }
+	case "square":
+		f.curve = func(t float64) float64 {
+			if t-math.Floor(t) < 0.5 {
+				return 1
+			}
+			return 0
+		}
+	case "ramp":
+		f.curve = func(t float64) float64 { return t - math.Floor(t) }
+	default:
+		return nil, fmt.Errorf("nvml-fake-curve: unknown curve %q", name)
+	}
+
+	for _, spec := range strings.Split(errs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		method, code, ok := strings.Cut(spec, "=")
+		ret := nvml.ERROR_NOT_SUPPORTED
+		if ok {
+			switch code = strings.ToLower(code); code {
+			case "hang":
+				ret = fakeReturnHang
+			case "panic":
+				ret = fakeReturnPanic
+			default:
+				if ret, ok = fakeErrorCodes[code]; !ok {
+					return nil, fmt.Errorf("nvml-fake-errors: unknown error %q", code)
+				}
+			}
+		}
+		f.errs[method] = ret
+	}
+
+	for i := 0; i < count; i++ {
+		f.devices = append(f.devices, &fakeDevice{lib: f, index: i})
+	}
+	log.Printf("nvidia: using %d simulated GPU(s), curve=%s period=%v", count, name, f.period)
+	return f, nil
+}
+
+// load returns the utilization level in [0,1] for device i at time t. Devices
+// are phase-shifted so multi-GPU output is distinguishable.
+func (f *fakeLibrary) load(i int, t time.Time) float64 {
+	phase := float64(i) / float64(len(f.devices))
+	return f.curve(t.Sub(f.start).Seconds()/f.period.Seconds() + phase)
+}
+
+func (f *fakeLibrary) ret(method string) nvml.Return {
+	if r, ok := f.errs[method]; ok {
+		return f.misbehave(method, r)
+	}
+	return nvml.SUCCESS
+}
+
+func (f *fakeLibrary) misbehave(method string, r nvml.Return) nvml.Return {
+	switch r {
+	case fakeReturnHang:
+		select {
+		case <-time.After(fakeHang):
+		case <-f.unhang:
+		}
+		return nvml.ERROR_TIMEOUT
+	case fakeReturnPanic:
+		panic("nvml-fake: " + method)
+	}
+	return r
+}
+
+func (f *fakeLibrary) Init() nvml.Return     { return f.ret("Init") }
+func (f *fakeLibrary) Shutdown() nvml.Return { return nvml.SUCCESS }
+
+func (f *fakeLibrary) DeviceGetCount() (int, nvml.Return) {
+	return len(f.devices), f.ret("DeviceGetCount")
+}
+
+func (f *fakeLibrary) DeviceGetHandleByIndex(i int) (nvml.Device, nvml.Return) {
+	if i < 0 || i >= len(f.devices) {
+		return nil, nvml.ERROR_INVALID_ARGUMENT
+	}
+	return f.devices[i], f.ret("DeviceGetHandleByIndex")
+}
+
+func (f *fakeLibrary) SystemGetDriverVersion() (string, nvml.Return) {
+	return "000.00-fake", f.ret("SystemGetDriverVersion")
+}
+
+func (f *fakeLibrary) SystemGetCudaDriverVersion() (int, nvml.Return) {
+	return 12080, f.ret("SystemGetCudaDriverVersion")
+}
+
+func (f *fakeLibrary) EventSetCreate() (nvml.EventSet, nvml.Return) {
+	if r := f.ret("EventSetCreate"); r != nvml.SUCCESS {
+		return nil, r
+	}
+	return fakeEventSet{}, nvml.SUCCESS
+}
+
+type fakeEventSet struct{}
+
+func (fakeEventSet) Free() nvml.Return { return nvml.SUCCESS }
+func (fakeEventSet) Wait(uint32) (nvml.EventData, nvml.Return) {
+	return nvml.EventData{}, nvml.ERROR_TIMEOUT
+}
+
+// fakeDevice implements the nvml.Device methods the domains package calls.
+// The embedded interface is nil, so any other method panics, which flags a
+// new domain call that the fake does not cover yet.
+type fakeDevice struct {
+	nvml.Device
+	lib   *fakeLibrary
+	index int
+
+	mu         sync.Mutex
+	energy     float64
+	lastEnergy time.Time
+}
+
+func (d *fakeDevice) load() float64 { return d.lib.load(d.index, time.Now()) }
+
+func (d *fakeDevice) ret(method string) nvml.Return { return d.lib.ret(method) }
+
+func (d *fakeDevice) powerAt(t time.Time) uint32 {
+	return uint32(fakeIdlePowerMw + (fakeMaxPowerMw-fakeIdlePowerMw)*d.lib.load(d.index, t))
+}
+
+func (d *fakeDevice) GetName() (string, nvml.Return) {
+	return "Simulated GPU", d.ret("GetName")
+}
+
+func (d *fakeDevice) GetUUID() (string, nvml.Return) {
+	return fmt.Sprintf("GPU-fake0000-0000-0000-0000-%012d", d.index), d.ret("GetUUID")
+}
+
+func (d *fakeDevice) GetSerial() (string, nvml.Return) {
+	return fmt.Sprintf("FAKE%08d", d.index), d.ret("GetSerial")
+}
+
+func (d *fakeDevice) GetCudaComputeCapability() (int, int, nvml.Return) {
+	return 8, 9, d.ret("GetCudaComputeCapability")
+}
+
+func (d *fakeDevice) GetVirtualizationMode() (nvml.GpuVirtualizationMode, nvml.Return) {
+	return nvml.GPU_VIRTUALIZATION_MODE_NONE, d.ret("GetVirtualizationMode")
+}
+
+func (d *fakeDevice) GetPowerManagementDefaultLimit() (uint32, nvml.Return) {
+	return fakeMaxPowerMw, d.ret("GetPowerManagementDefaultLimit")
+}
+
+func (d *fakeDevice) GetPowerManagementLimitConstraints() (uint32, uint32, nvml.Return) {
+	return 100_000, fakeMaxPowerMw, d.ret("GetPowerManagementLimitConstraints")
+}
+
+func (d *fakeDevice) GetPowerManagementLimit() (uint32, nvml.Return) {
+	return fakeMaxPowerMw, d.ret("GetPowerManagementLimit")
+}
+
+func (d *fakeDevice) GetEnforcedPowerLimit() (uint32, nvml.Return) {
+	return fakeMaxPowerMw, d.ret("GetEnforcedPowerLimit")
+}
+
+func (d *fakeDevice) GetPowerUsage() (uint32, nvml.Return) {
+	return d.powerAt(time.Now()), d.ret("GetPowerUsage")
+}
+
+func (d *fakeDevice) GetTotalEnergyConsumption() (uint64, nvml.Return) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	if !d.lastEnergy.IsZero() {
+		d.energy += float64(d.powerAt(now)) * now.Sub(d.lastEnergy).Seconds()
+	}
+	d.lastEnergy = now
+	return uint64(d.energy), d.ret("GetTotalEnergyConsumption")
+}
+
+func (d *fakeDevice) GetMemoryInfo() (nvml.Memory, nvml.Return) {
+	used := uint64(float64(fakeMemoryTotal) * (0.1 + 0.8*d.load()))
+	return nvml.Memory{Total: fakeMemoryTotal, Used: used, Free: fakeMemoryTotal - used}, d.ret("GetMemoryInfo")
+}
+
+func (d *fakeDevice) GetBAR1MemoryInfo() (nvml.BAR1Memory, nvml.Return) {
+	used := uint64(8 << 20)
+	return nvml.BAR1Memory{Bar1Total: fakeBar1Total, Bar1Used: used, Bar1Free: fakeBar1Total - used}, d.ret("GetBAR1MemoryInfo")
+}
+
+func (d *fakeDevice) GetUtilizationRates() (nvml.Utilization, nvml.Return) {
+	l := d.load()
+	return nvml.Utilization{Gpu: uint32(100 * l), Memory: uint32(60 * l)}, d.ret("GetUtilizationRates")
+}
+
+func (d *fakeDevice) GetMaxClockInfo(t nvml.ClockType) (uint32, nvml.Return) {
+	switch t {
+	case nvml.CLOCK_MEM:
+		return fakeMaxMemClock, d.ret("GetMaxClockInfo")
+	default:
+		return fakeMaxSMClock, d.ret("GetMaxClockInfo")
+	}
+}
+
+func (d *fakeDevice) GetClockInfo(t nvml.ClockType) (uint32, nvml.Return) {
+	switch t {
+	case nvml.CLOCK_MEM:
+		return fakeMaxMemClock, d.ret("GetClockInfo")
+	case nvml.CLOCK_VIDEO:
+		return 1500, d.ret("GetClockInfo")
+	default:
+		return uint32(fakeIdleSMClock + (fakeMaxSMClock-fakeIdleSMClock)*d.load()), d.ret("GetClockInfo")
+	}
+}
+
+func (d *fakeDevice) GetPerformanceState() (nvml.Pstates, nvml.Return) {
+	if d.load() > 0.1 {
+		return nvml.PSTATE_0, d.ret("GetPerformanceState")
+	}
+	return nvml.PSTATE_8, d.ret("GetPerformanceState")
+}
+
+func (d *fakeDevice) GetCurrentClocksEventReasons() (uint64, nvml.Return) {
+	if d.load() < 0.1 {
+		return nvml.ClocksEventReasonGpuIdle, d.ret("GetCurrentClocksEventReasons")
+	}
+	return 0, d.ret("GetCurrentClocksEventReasons")
+}
+
+func (d *fakeDevice) GetTemperatureThreshold(t nvml.TemperatureThresholds) (uint32, nvml.Return) {
+	switch t {
+	case nvml.TEMPERATURE_THRESHOLD_SHUTDOWN:
+		return 95, d.ret("GetTemperatureThreshold")
+	case nvml.TEMPERATURE_THRESHOLD_SLOWDOWN:
+		return 92, d.ret("GetTemperatureThreshold")
+	default:
+		return 87, d.ret("GetTemperatureThreshold")
+	}
+}
+
+func (d *fakeDevice) GetTemperature(nvml.TemperatureSensors) (uint32, nvml.Return) {
+	return uint32(35 + 45*d.load()), d.ret("GetTemperature")
+}
+
+func (d *fakeDevice) GetViolationStatus(nvml.PerfPolicyType) (nvml.ViolationTime, nvml.Return) {
+	return nvml.ViolationTime{ReferenceTime: uint64(time.Now().UnixMicro())}, d.ret("GetViolationStatus")
+}
+
+func (d *fakeDevice) GetPciInfo() (nvml.PciInfo, nvml.Return) {
+	var info nvml.PciInfo
+	copy(info.BusId[:], fmt.Sprintf("00000000:%02X:00.0", 0x10+d.index))
+	return info, d.ret("GetPciInfo")
+}
+
+func (d *fakeDevice) GetMaxPcieLinkGeneration() (int, nvml.Return) {
+	return 4, d.ret("GetMaxPcieLinkGeneration")
+}
+
+func (d *fakeDevice) GetMaxPcieLinkWidth() (int, nvml.Return) {
+	return 16, d.ret("GetMaxPcieLinkWidth")
+}
+
+func (d *fakeDevice) GetCurrPcieLinkGeneration() (int, nvml.Return) {
+	return 4, d.ret("GetCurrPcieLinkGeneration")
+}
+
+func (d *fakeDevice) GetCurrPcieLinkWidth() (int, nvml.Return) {
+	return 16, d.ret("GetCurrPcieLinkWidth")
+}
+
+func (d *fakeDevice) GetPcieReplayCounter() (int, nvml.Return) {
+	return 0, d.ret("GetPcieReplayCounter")
+}
+
+func (d *fakeDevice) GetPcieThroughput(nvml.PcieUtilCounter) (uint32, nvml.Return) {
+	return uint32(2_000_000 * d.load()), d.ret("GetPcieThroughput")
+}
+
+func (d *fakeDevice) GetNvLinkState(int) (nvml.EnableState, nvml.Return) {
+	return nvml.FEATURE_DISABLED, d.retDefault("GetNvLinkState", nvml.ERROR_NOT_SUPPORTED)
+}
+
+func (d *fakeDevice) GetEccMode() (nvml.EnableState, nvml.EnableState, nvml.Return) {
+	return nvml.FEATURE_DISABLED, nvml.FEATURE_DISABLED, d.ret("GetEccMode")
+}
+
+func (d *fakeDevice) GetRetiredPages(nvml.PageRetirementCause) ([]uint64, nvml.Return) {
+	return nil, d.ret("GetRetiredPages")
+}
+
+func (d *fakeDevice) GetRetiredPagesPendingStatus() (nvml.EnableState, nvml.Return) {
+	return nvml.FEATURE_DISABLED, d.ret("GetRetiredPagesPendingStatus")
+}
+
+func (d *fakeDevice) GetRemappedRows() (int, int, bool, bool, nvml.Return) {
+	return 0, 0, false, false, d.ret("GetRemappedRows")
+}
+
+func (d *fakeDevice) GetMigMode() (int, int, nvml.Return) {
+	return nvml.DEVICE_MIG_DISABLE, nvml.DEVICE_MIG_DISABLE, d.ret("GetMigMode")
+}
+
+func (d *fakeDevice) RegisterEvents(uint64, nvml.EventSet) nvml.Return {
+	return d.ret("RegisterEvents")
+}
+
+func (d *fakeDevice) processes(method string) ([]nvml.ProcessInfo, nvml.Return) {
+	if r := d.ret(method); r != nvml.SUCCESS || d.load() < 0.1 {
+		return nil, r
+	}
+	mem, _ := d.GetMemoryInfo()
+	return []nvml.ProcessInfo{{Pid: uint32(os.Getpid()), UsedGpuMemory: mem.Used}}, nvml.SUCCESS
+}
+
+func (d *fakeDevice) GetComputeRunningProcesses() ([]nvml.ProcessInfo, nvml.Return) {
+	return d.processes("GetComputeRunningProcesses")
+}
+
+func (d *fakeDevice) GetGraphicsRunningProcesses() ([]nvml.ProcessInfo, nvml.Return) {
+	return nil, d.ret("GetGraphicsRunningProcesses")
+}
+
+func (d *fakeDevice) GetMPSComputeRunningProcesses() ([]nvml.ProcessInfo, nvml.Return) {
+	return nil, d.ret("GetMPSComputeRunningProcesses")
+}
+
+func (d *fakeDevice) GetProcessUtilization(uint64) ([]nvml.ProcessUtilizationSample, nvml.Return) {
+	if r := d.ret("GetProcessUtilization"); r != nvml.SUCCESS {
+		return nil, r
+	}
+	l := d.load()
+	if l < 0.1 {
+		return nil, nvml.ERROR_NOT_FOUND
+	}
+	return []nvml.ProcessUtilizationSample{{
+		Pid:       uint32(os.Getpid()),
+		TimeStamp: uint64(time.Now().UnixMicro()),
+		SmUtil:    uint32(100 * l),
+		MemUtil:   uint32(60 * l),
+	}}, nvml.SUCCESS
+}
+
+// GetSamples synthesises one buffer sample every fakeSamplePeriod since
+// lastSeen, mirroring the driver's ring buffer.
+func (d *fakeDevice) GetSamples(kind nvml.SamplingType, lastSeen uint64) (nvml.ValueType, []nvml.Sample, nvml.Return) {
+	if r := d.ret("GetSamples"); r != nvml.SUCCESS {
+		return 0, nil, r
+	}
+	now := time.Now()
+	from := time.UnixMicro(int64(lastSeen)).Add(fakeSamplePeriod)
+	if oldest := now.Add(-fakeMaxSamples * fakeSamplePeriod); from.Before(oldest) {
+		from = oldest
+	}
+
+	var samples []nvml.Sample
+	for t := from.Truncate(fakeSamplePeriod); !t.After(now); t = t.Add(fakeSamplePeriod) {
+		l := d.lib.load(d.index, t)
+		var v uint32
+		switch kind {
+		case nvml.TOTAL_POWER_SAMPLES:
+			v = d.powerAt(t)
+		case nvml.GPU_UTILIZATION_SAMPLES:
+			v = uint32(100 * l)
+		case nvml.MEMORY_UTILIZATION_SAMPLES:
+			v = uint32(60 * l)
+		case nvml.PROCESSOR_CLK_SAMPLES:
+			v = uint32(fakeIdleSMClock + (fakeMaxSMClock-fakeIdleSMClock)*l)
+		case nvml.MEMORY_CLK_SAMPLES:
+			v = fakeMaxMemClock
+		default:
+			return 0, nil, nvml.ERROR_NOT_SUPPORTED
+		}
+		s := nvml.Sample{TimeStamp: uint64(t.UnixMicro())}
+		binary.LittleEndian.PutUint32(s.SampleValue[:4], v)
+		samples = append(samples, s)
+	}
+	if len(samples) == 0 {
+		return nvml.VALUE_TYPE_UNSIGNED_INT, nil, nvml.ERROR_NOT_FOUND
+	}
+	return nvml.VALUE_TYPE_UNSIGNED_INT, samples, nvml.SUCCESS
+}
+
+// retDefault is ret for calls the simulated hardware lacks unless the error
+// map explicitly overrides them.
+func (d *fakeDevice) retDefault(method string, def nvml.Return) nvml.Return {
+	if r, ok := d.lib.errs[method]; ok {
+		return d.lib.misbehave(method, r)
+	}
+	return def
+}
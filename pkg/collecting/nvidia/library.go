@@ -0,0 +1,25 @@
+package nvidia
+
+import (
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+)
+
+// Library is the part of the NVML API the collector uses outside of device
+// handles. nvml.Interface satisfies it; fakeLibrary stands in on machines
+// without a GPU.
+type Library interface {
+	Init() nvml.Return
+	Shutdown() nvml.Return
+	DeviceGetCount() (int, nvml.Return)
+	DeviceGetHandleByIndex(int) (nvml.Device, nvml.Return)
+	SystemGetDriverVersion() (string, nvml.Return)
+	SystemGetCudaDriverVersion() (int, nvml.Return)
+	EventSetCreate() (nvml.EventSet, nvml.Return)
+}
+
+func newLibrary(opts *Options) (Library, error) {
+	if opts.Fake > 0 {
+		return newFakeLibrary(opts.Fake, opts.FakeCurve, opts.FakeErrors)
+	}
+	return nvml.New(), nil
+}
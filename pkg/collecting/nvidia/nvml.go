@@ -15,28 +15,29 @@ var (
 )
 
 type NVML struct {
+	lib     Library
 	devices []nvml.Device
 }
 
-func NewNVML() (*NVML, error) {
+func NewNVML(lib Library) (*NVML, error) {
 	nvmlMu.Lock()
 	defer nvmlMu.Unlock()
 
 	utils.Debugf("nvml: initializing, refcount=%d", nvmlRefCount)
 
 	if nvmlRefCount == 0 {
-		if ret := nvml.Init(); ret != nvml.SUCCESS {
+		if ret := lib.Init(); ret != nvml.SUCCESS {
 			utils.Debugf("nvml: Init() failed: %v", ret)
 			return nil, ret
 		}
 	}
 	nvmlRefCount++
 
-	count, ret := nvml.DeviceGetCount()
+	count, ret := lib.DeviceGetCount()
 	if !errors.Is(ret, nvml.SUCCESS) || count == 0 {
 		nvmlRefCount--
 		if nvmlRefCount == 0 {
-			nvml.Shutdown()
+			lib.Shutdown()
 		}
 		utils.Debugf("nvml: no GPUs found (count=%d ret=%v)", count, ret)
 		return nil, errors.New("no GPUs found")
@@ -44,7 +45,7 @@ func NewNVML() (*NVML, error) {
 
 	devices := make([]nvml.Device, 0, count)
 	for i := 0; i < count; i++ {
-		device, ret := nvml.DeviceGetHandleByIndex(i)
+		device, ret := lib.DeviceGetHandleByIndex(i)
 		if ret == nvml.SUCCESS {
 			devices = append(devices, device)
 		} else {
@@ -55,6 +56,7 @@ func NewNVML() (*NVML, error) {
 	log.Printf("nvidia: found %d GPU(s)", len(devices))
 
 	return &NVML{
+		lib:     lib,
 		devices: devices,
 	}, nil
 }
@@ -63,6 +65,10 @@ func (n *NVML) Devices() []nvml.Device {
 	return n.devices
 }
 
+func (n *NVML) Library() Library {
+	return n.lib
+}
+
 func (n *NVML) Count() int {
 	return len(n.devices)
 }
@@ -74,7 +80,7 @@ func (n *NVML) Close() error {
 	utils.Debugf("nvml: closing (%d devices, refcount=%d)", len(n.devices), nvmlRefCount)
 	nvmlRefCount--
 	if nvmlRefCount == 0 {
-		if ret := nvml.Shutdown(); ret != nvml.SUCCESS {
+		if ret := n.lib.Shutdown(); ret != nvml.SUCCESS {
 			utils.Debugf("nvml: Shutdown() failed: %v", ret)
 			return ret
 		}
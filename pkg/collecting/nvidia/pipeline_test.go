@@ -0,0 +1,213 @@
+package nvidia
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"InferenceProfiler/pkg/collecting"
+	"InferenceProfiler/pkg/utils"
+)
+
+// until ends a pipeline run after a number of records or, for runs whose
+// polls all fail and so write none, of consecutive failed polls.
+type until struct {
+	records  int
+	failures int64
+}
+
+// runPipeline records a flattened continuous run of the nvidia collector
+// over simulated GPUs and returns its static line, its records and the
+// collector's health afterwards.
+func runPipeline(t *testing.T, opts Options, u until) (map[string]any, []map[string]any, collecting.CollectorHealth) {
+	t.Helper()
+	cfg := &utils.Config{
+		UUID:          "pipeline",
+		OutputDir:     t.TempDir(),
+		Flatten:       true,
+		Interval:      50,
+		StalePolicy:   utils.StaleSkip,
+		StaleAfter:    3,
+		PollTimeout:   100,
+		DegradedAfter: 2,
+		QueueSize:     16,
+		QueuePolicy:   utils.QueueBlock,
+		MaxSamples:    u.records,
+		Options:       map[string]any{"nvidia": &opts},
+	}
+	path := filepath.Join(cfg.OutputDir, cfg.UUID+".jsonl")
+	w, err := utils.NewFileWriter(cfg, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := collecting.NewManager(cfg)
+	defer m.Close()
+	nvidiaHealth := func() collecting.CollectorHealth {
+		for _, h := range m.Health() {
+			if h.Name == "Nvidia" {
+				return h
+			}
+		}
+		return collecting.CollectorHealth{}
+	}
+
+	// The run ends at -max-samples or once the failures are counted; the
+	// timeout only catches a stuck one.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if u.failures > 0 {
+		go func() {
+			for ctx.Err() == nil && nvidiaHealth().ConsecutiveFailures < u.failures {
+				time.Sleep(5 * time.Millisecond)
+			}
+			cancel()
+		}()
+	}
+	n, err := m.Continuous(ctx, w, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Fatalf("run timed out after %d records", n)
+	}
+	if u.records > 0 && n != u.records {
+		t.Fatalf("run ended after %d records, want %d", n, u.records)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	health := nvidiaHealth()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []map[string]any
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			t.Fatalf("line %d: %v", len(lines)+1, err)
+		}
+		lines = append(lines, line)
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) == 0 {
+		t.Fatal("no static line written")
+	}
+	return lines[0], lines[1:], health
+}
+
+func TestPipelineFakeGPUs(t *testing.T) {
+	static, records, health := runPipeline(t, Options{Fake: 2, FakeCurve: "constant"}, until{records: 8})
+
+	if static["uuid"] != "pipeline" || static["Nvidia0DeviceName"] != "Simulated GPU" {
+		t.Errorf("static line: uuid=%v Nvidia0DeviceName=%v", static["uuid"], static["Nvidia0DeviceName"])
+	}
+	if _, ok := static["Nvidia1DeviceUUID"]; !ok {
+		t.Error("static line lacks the second GPU")
+	}
+	if len(records) != 8 {
+		t.Fatalf("%d records, want 8", len(records))
+	}
+
+	var lastSeq float64
+	for i, rec := range records {
+		for _, gpu := range []string{"Nvidia0", "Nvidia1"} {
+			// The constant curve runs at half load.
+			if v := rec[gpu+"UtilizationGPU"]; v != float64(50) {
+				t.Fatalf("record %d: %sUtilizationGPU = %v, want 50", i, gpu, v)
+			}
+			if used, free := rec[gpu+"MemoryUsed"].(float64), rec[gpu+"MemoryFree"].(float64); used+free != fakeMemoryTotal {
+				t.Errorf("record %d: %s memory used+free = %v, want %d", i, gpu, used+free, fakeMemoryTotal)
+			}
+			if rec[gpu+"PowerUsageT"] == float64(0) {
+				t.Errorf("record %d: %sPowerUsage not read", i, gpu)
+			}
+			// ECC is off on the simulated GPUs, so its counters are never
+			// read; NvLink is not supported.
+			if rec[gpu+"ECCVolatileCorrectedT"] != float64(0) || rec[gpu+"NvLinkActiveLinksT"] != float64(0) {
+				t.Errorf("record %d: %s ECC/NvLink read on hardware without them", i, gpu)
+			}
+		}
+		seq := rec["TicksSeq"].(float64)
+		if seq <= lastSeq {
+			t.Errorf("record %d: TicksSeq %v after %v", i, seq, lastSeq)
+		}
+		lastSeq = seq
+	}
+	if health.State != "ok" || health.ConsecutiveFailures != 0 {
+		t.Errorf("health = %+v, want ok", health)
+	}
+}
+
+func TestPipelineFakeErrors(t *testing.T) {
+	opts := Options{Fake: 1, FakeCurve: "constant", FakeErrors: "GetPowerUsage,GetTemperature=gpu_lost"}
+	_, records, health := runPipeline(t, opts, until{records: 6})
+
+	for i, rec := range records {
+		// Failed calls leave their metrics unset; the rest of the poll
+		// goes through.
+		if rec["Nvidia0PowerUsageT"] != float64(0) || rec["Nvidia0ThermalGPUT"] != float64(0) {
+			t.Errorf("record %d: power T=%v thermal T=%v, want unset", i, rec["Nvidia0PowerUsageT"], rec["Nvidia0ThermalGPUT"])
+		}
+		if rec["Nvidia0PowerEnergyT"] == float64(0) || rec["Nvidia0UtilizationGPUT"] == float64(0) {
+			t.Errorf("record %d: metrics around the failed calls not read", i)
+		}
+	}
+	if health.State != "ok" {
+		t.Errorf("health = %+v; NVML errors are not poll failures", health)
+	}
+}
+
+func TestPipelineFakePanic(t *testing.T) {
+	opts := Options{Fake: 1, FakeErrors: "GetPowerUsage=panic"}
+	static, records, health := runPipeline(t, opts, until{failures: 3})
+
+	if _, ok := static["Nvidia0DeviceName"]; !ok {
+		t.Error("static line lacks the GPU")
+	}
+	// Every poll panics, so no Nvidia section is ever written, but the
+	// run survives and the watchdog counts the panics.
+	for i, rec := range records {
+		if _, ok := rec["Nvidia0PowerUsage"]; ok {
+			t.Fatalf("record %d has Nvidia data from a panicking poll", i)
+		}
+	}
+	if health.Panics < 3 || health.State != "degraded" {
+		t.Errorf("health = %+v, want degraded after repeated panics", health)
+	}
+}
+
+func TestPipelineFakeHang(t *testing.T) {
+	// The hung call outlives the run; end it with the test.
+	fakeUnhang = make(chan struct{})
+	t.Cleanup(func() {
+		close(fakeUnhang)
+		fakeUnhang = nil
+	})
+	opts := Options{Fake: 1, FakeErrors: "GetUtilizationRates=hang"}
+	_, records, health := runPipeline(t, opts, until{failures: 3})
+
+	for i, rec := range records {
+		if _, ok := rec["Nvidia0UtilizationGPU"]; ok {
+			t.Fatalf("record %d has Nvidia data from a hung poll", i)
+		}
+	}
+	// The first poll times out after -poll-timeout; later ones find it
+	// still running and fail at once instead of piling up.
+	if health.Timeouts != 1 || health.ConsecutiveFailures < 2 || health.State != "degraded" {
+		t.Errorf("health = %+v, want one timeout and degraded", health)
+	}
+}
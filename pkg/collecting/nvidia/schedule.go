@@ -0,0 +1,62 @@
+package nvidia
+
+import (
+	"InferenceProfiler/pkg/utils"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+var domainNames = []string{
+	"power", "memory", "utilization", "clocks", "thermal", "pcie", "violations",
+	"nvlink", "ecc", "events", "processes", "samples", "mig",
+}
+
+// domainSchedule lets individual domains poll slower than the collector
+// itself, configured as "nvidia.<domain>=ms" in -intervals. Domains without
+// an override run on every poll.
+type domainSchedule struct {
+	intervals map[string]time.Duration
+	last      map[string]time.Time
+}
+
+func newDomainSchedule(intervals map[string]int) *domainSchedule {
+	s := &domainSchedule{
+		intervals: make(map[string]time.Duration),
+		last:      make(map[string]time.Time),
+	}
+	for key, ms := range intervals {
+		domain, ok := strings.CutPrefix(key, "nvidia.")
+		if !ok {
+			continue
+		}
+		if !slices.Contains(domainNames, domain) {
+			log.Printf("nvidia: ignoring interval for unknown domain %q (known: %s)",
+				domain, strings.Join(domainNames, ","))
+			continue
+		}
+		s.intervals[domain] = time.Duration(ms) * time.Millisecond
+		utils.Debugf("nvidia: domain %s interval=%dms", domain, ms)
+	}
+	return s
+}
+
+// skipped returns the domains that are not due at now and records the others
+// as run. A nil map means every domain is due.
+func (s *domainSchedule) skipped(now time.Time) map[string]bool {
+	if len(s.intervals) == 0 {
+		return nil
+	}
+	skip := make(map[string]bool)
+	for domain, iv := range s.intervals {
+		// Allow 10% early so jitter in the collector's own schedule does not
+		// push a domain out by a whole extra poll.
+		if last, ok := s.last[domain]; ok && now.Sub(last) < iv-iv/10 {
+			skip[domain] = true
+			continue
+		}
+		s.last[domain] = now
+	}
+	return skip
+}
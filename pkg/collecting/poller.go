@@ -2,33 +2,155 @@ package collecting
 
 import (
 	"InferenceProfiler/pkg/collecting/base"
+	"InferenceProfiler/pkg/utils"
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	adaptiveSlowdown  = 0.8 // grow the interval when a poll takes this share of it
+	adaptiveSpeedup   = 0.3 // shrink back toward the configured interval below this
+	adaptiveMaxFactor = 16
+	ewmaWeight        = 0.2
+
+	defaultTimeoutFactor = 5 // poll deadline in intervals when -poll-timeout is 0
+
+	// stopGrace is how long stop waits for a cancelled poll to return
+	// before leaving it to run on.
+	stopGrace = 100 * time.Millisecond
+)
+
+var (
+	errPollTimeout = errors.New("poll deadline exceeded")
+	errPollBusy    = errors.New("previous poll still running")
+	errPollPanic   = errors.New("poll panicked")
+)
+
 type poller struct {
 	collector base.Collector
+	interval  time.Duration
+	adaptive  bool
+	align     bool
+	timeout   time.Duration
+	current   atomic.Int64
+	busy      atomic.Bool
+	wake      chan struct{}
 	cancel    context.CancelFunc
 	wg        sync.WaitGroup
 
 	mu      sync.RWMutex
-	cached  any
+	last    sample
 	cycles  int64
 	totalNs int64
 	minNs   int64
 	maxNs   int64
+	ewmaNs  float64
+	health  watchdog
+}
+
+// sample is the result of one completed poll.
+type sample struct {
+	data  any
+	seq   int64
+	start time.Time
+	end   time.Time
+}
+
+func newPoller(c base.Collector, interval time.Duration, cfg *utils.Config) *poller {
+	p := &poller{
+		collector: c,
+		interval:  interval,
+		adaptive:  cfg.Adaptive,
+		align:     cfg.Align,
+		timeout:   time.Duration(cfg.PollTimeout) * time.Millisecond,
+		health:    watchdog{name: c.Name(), threshold: int64(cfg.DegradedAfter)},
+		wake:      make(chan struct{}, 1),
+	}
+	p.current.Store(int64(interval))
+	return p
+}
+
+func (p *poller) deadline() time.Duration {
+	if p.timeout > 0 {
+		return p.timeout
+	}
+	return defaultTimeoutFactor * p.effectiveInterval()
+}
+
+// guardedPoll runs Poll under the poll deadline and turns a panic into an
+// error. A poll that misses its deadline has its context cancelled but keeps
+// running in the background; until it returns, further polls fail with
+// errPollBusy instead of piling up goroutines.
+func (p *poller) guardedPoll(ctx context.Context) (any, error) {
+	if !p.busy.CompareAndSwap(false, true) {
+		return nil, errPollBusy
+	}
+
+	type result struct {
+		data any
+		err  error
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.deadline())
+	defer cancel()
+	ch := make(chan result, 1)
+
+	go func() {
+		var res result
+		defer func() {
+			if r := recover(); r != nil {
+				utils.Debugf("poller: %s panic stack:\n%s", p.collector.Name(), debug.Stack())
+				res = result{err: fmt.Errorf("%w: %v", errPollPanic, r)}
+			}
+			// Not busy by the time the result is in, so a poller
+			// stopped right after a poll can close its collector.
+			p.busy.Store(false)
+			ch <- res
+		}()
+		res.data = p.collector.Poll(ctx)
+	}()
+
+	select {
+	case r := <-ch:
+		return r.data, r.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w after %v", errPollTimeout, p.deadline())
+		}
+		return nil, ctx.Err()
+	}
 }
 
 func (p *poller) timedPoll(ctx context.Context) {
 	start := time.Now()
-	data := p.collector.Poll(ctx)
-	elapsed := time.Since(start).Nanoseconds()
+	data, err := p.guardedPoll(ctx)
+	end := time.Now()
+	elapsed := end.Sub(start).Nanoseconds()
+
+	if ctx.Err() != nil {
+		return
+	}
 
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.cached = data
+	if err != nil {
+		// The previous sample stays cached, so its age keeps growing and
+		// the stale policy picks it up.
+		if p.health.failures == 0 {
+			log.Printf("poller: %s poll failed: %v", p.collector.Name(), err)
+		} else {
+			utils.Debugf("poller: %s poll failed: %v", p.collector.Name(), err)
+		}
+		p.health.fail(err, errors.Is(err, errPollTimeout), errors.Is(err, errPollPanic))
+		return
+	}
+	p.health.succeed()
+	p.last = sample{data: data, seq: p.last.seq + 1, start: start, end: end}
 	p.cycles++
 	p.totalNs += elapsed
 	if elapsed < p.minNs || p.cycles == 1 {
@@ -37,52 +159,148 @@ func (p *poller) timedPoll(ctx context.Context) {
 	if elapsed > p.maxNs {
 		p.maxNs = elapsed
 	}
+	if p.cycles == 1 {
+		p.ewmaNs = float64(elapsed)
+	} else {
+		p.ewmaNs = ewmaWeight*float64(elapsed) + (1-ewmaWeight)*p.ewmaNs
+	}
+	if p.adaptive {
+		p.adapt()
+	}
+}
+
+// adapt doubles the effective interval while the smoothed poll time is close
+// to it, and halves it back toward the configured interval once polls are
+// cheap again. Called with p.mu held.
+func (p *poller) adapt() {
+	cur := time.Duration(p.current.Load())
+	next := cur
+	switch {
+	case p.ewmaNs > adaptiveSlowdown*float64(cur):
+		next = min(cur*2, p.interval*adaptiveMaxFactor)
+	case p.ewmaNs < adaptiveSpeedup*float64(cur) && cur > p.interval:
+		next = max(cur/2, p.interval)
+	}
+	if next != cur {
+		p.current.Store(int64(next))
+		log.Printf("poller: %s interval %v -> %v (avg poll %v)",
+			p.collector.Name(), cur, next, time.Duration(p.ewmaNs).Round(time.Microsecond))
+	}
 }
 
-func (p *poller) latest() any {
+// latest returns the most recent poll result. Its sequence number increases
+// by one per completed poll.
+func (p *poller) latest() sample {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
-	return p.cached
+	return p.last
 }
 
-func (p *poller) startLoop(ctx context.Context, interval time.Duration) {
+func (p *poller) healthSnapshot() CollectorHealth {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.health.snapshot()
+}
+
+func (p *poller) effectiveInterval() time.Duration {
+	return time.Duration(p.current.Load())
+}
+
+// baseInterval is the interval the poller was asked for, before adaptive
+// backoff.
+func (p *poller) baseInterval() time.Duration {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.interval
+}
+
+// setInterval changes the interval of a running poller, dropping any
+// adaptive backoff. A loop waiting out the old interval polls at once.
+func (p *poller) setInterval(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if d == p.interval {
+		return
+	}
+	log.Printf("poller: %s interval %v -> %v", p.collector.Name(), p.interval, d)
+	p.interval = d
+	p.current.Store(int64(d))
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *poller) startLoop(ctx context.Context) {
 	ctx, p.cancel = context.WithCancel(ctx)
 	p.wg.Add(1)
 
 	go func() {
 		defer p.wg.Done()
 
-		p.timedPoll(ctx)
-
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
+		next := time.Now()
+		if p.align {
+			// Start on the next wall-clock multiple of the interval so
+			// polls on different hosts happen at the same instants.
+			iv := p.baseInterval()
+			next = next.Truncate(iv).Add(iv)
+		}
+		timer := time.NewTimer(time.Until(next))
+		defer timer.Stop()
 
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case <-ticker.C:
-				p.timedPoll(ctx)
+			case <-timer.C:
+			case <-p.wake:
+				next = time.Now()
+				if p.align {
+					iv := p.baseInterval()
+					next = next.Truncate(iv).Add(iv)
+					timer.Reset(time.Until(next))
+					continue
+				}
+				timer.Stop()
 			}
+
+			p.timedPoll(ctx)
+
+			// Fixed-rate schedule like time.Ticker, but re-reading the
+			// interval each cycle so adaptive changes take effect. After
+			// an overrun the missed slots are skipped, which keeps the
+			// schedule on its (possibly wall-clock aligned) grid.
+			iv := p.effectiveInterval()
+			next = next.Add(iv)
+			if now := time.Now(); !next.After(now) {
+				next = next.Add((now.Sub(next)/iv + 1) * iv)
+			}
+			timer.Reset(time.Until(next))
 		}
 	}()
 }
 
+// stop ends the poll loop. A poll it cancels gets stopGrace to return, so
+// the collector is idle afterwards unless a poll hangs.
 func (p *poller) stop() {
 	if p.cancel != nil {
 		p.cancel()
 		p.wg.Wait()
 	}
+	for wait := time.Now().Add(stopGrace); p.busy.Load() && time.Now().Before(wait); {
+		time.Sleep(time.Millisecond)
+	}
 }
 
 func (p *poller) pollStats() string {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	if p.cycles == 0 {
-		return "no polls"
+		return fmt.Sprintf("no polls  timeouts=%d  panics=%d", p.health.timeouts, p.health.panics)
 	}
 	avgUs := float64(p.totalNs) / float64(p.cycles) / 1000.0
 	minUs := float64(p.minNs) / 1000.0
 	maxUs := float64(p.maxNs) / 1000.0
-	return fmt.Sprintf("cycles=%8d  avg=%10.1fµs  min=%10.1fµs  max=%10.1fµs", p.cycles, avgUs, minUs, maxUs)
+	return fmt.Sprintf("cycles=%8d  avg=%10.1fµs  min=%10.1fµs  max=%10.1fµs  interval=%v  timeouts=%d  panics=%d",
+		p.cycles, avgUs, minUs, maxUs, p.effectiveInterval(), p.health.timeouts, p.health.panics)
 }
@@ -1,6 +1,7 @@
 package process
 
 import (
+	"InferenceProfiler/pkg/collecting"
 	"InferenceProfiler/pkg/collecting/base"
 	"InferenceProfiler/pkg/utils"
 	"bytes"
@@ -33,6 +34,19 @@ type Collector struct{}
 
 func New() *Collector { return &Collector{} }
 
+func init() {
+	collecting.Register(collecting.Registration{
+		CollectorSpec: utils.CollectorSpec{
+			Name:        "process",
+			Description: "process metrics",
+			Default:     true,
+			Flag:        "no-procs",
+		},
+		New:   func() base.Collector { return New() },
+		Order: 30,
+	})
+}
+
 func (c *Collector) Name() string               { return "Process" }
 func (c *Collector) Init(_ *utils.Config) error { return nil }
 func (c *Collector) Static() any                { return nil }
@@ -0,0 +1,120 @@
+package collecting
+
+import (
+	"InferenceProfiler/pkg/collecting/base"
+	"InferenceProfiler/pkg/utils"
+	"context"
+	"encoding/json"
+	"log"
+	"sync/atomic"
+)
+
+// recordQueue decouples the tick loop from the Writer: ticks push finished
+// records and a single goroutine writes them, so a slow sink delays writes
+// instead of ticks. When the queue is full the policy decides whether the
+// tick waits, the new record is dropped, or the oldest queued one is.
+type recordQueue struct {
+	w      base.Writer
+	policy string
+	sized  bool
+	ch     chan queued
+	done   chan struct{}
+
+	dropped atomic.Int64
+	written atomic.Int64
+	pending atomic.Int64 // estimated bytes of the records in ch
+}
+
+// queued is a record waiting for the writer.
+type queued struct {
+	rec  map[string]any
+	size int64 // estimated encoded size; 0 unless the queue is sized
+}
+
+// newRecordQueue starts the writer goroutine. A sized queue estimates each
+// record's encoded size as it is pushed, for size.
+func newRecordQueue(w base.Writer, size int, policy string, sized bool) *recordQueue {
+	q := &recordQueue{
+		w:      w,
+		policy: policy,
+		sized:  sized,
+		ch:     make(chan queued, size),
+		done:   make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *recordQueue) run() {
+	defer close(q.done)
+	for item := range q.ch {
+		for name, data := range item.rec {
+			q.w.Dynamic(name, data)
+		}
+		if err := q.w.Flush(); err != nil {
+			log.Printf("manager: flush error: %v", err)
+		}
+		q.pending.Add(-item.size)
+		q.written.Add(1)
+	}
+}
+
+func (q *recordQueue) push(ctx context.Context, rec map[string]any) {
+	item := queued{rec: rec}
+	if q.sized {
+		if data, err := json.Marshal(rec); err == nil {
+			item.size = int64(len(data)) + 1
+		}
+	}
+	q.pending.Add(item.size)
+
+	switch q.policy {
+	case utils.QueueDropNewest:
+		select {
+		case q.ch <- item:
+		default:
+			q.drop("newest", item)
+		}
+	case utils.QueueDropOldest:
+		for {
+			select {
+			case q.ch <- item:
+				return
+			default:
+			}
+			select {
+			case old := <-q.ch:
+				q.drop("oldest", old)
+			default:
+			}
+		}
+	default:
+		select {
+		case q.ch <- item:
+		case <-ctx.Done():
+			q.drop("newest", item)
+		}
+	}
+}
+
+// size returns the bytes the writer has written plus the estimated size of
+// the records still queued, so -max-bytes counts a record when the tick
+// accepts it rather than once the writer gets to it.
+func (q *recordQueue) size() int64 {
+	return base.SizeOf(q.w) + q.pending.Load()
+}
+
+func (q *recordQueue) drop(which string, item queued) {
+	q.pending.Add(-item.size)
+	if n := q.dropped.Add(1); n == 1 {
+		log.Printf("manager: record queue full, dropping %s records", which)
+	}
+}
+
+func (q *recordQueue) depth() int { return len(q.ch) }
+
+// close writes what is still queued and waits for the writer goroutine.
+func (q *recordQueue) close() {
+	close(q.ch)
+	<-q.done
+}
@@ -0,0 +1,61 @@
+package collecting
+
+import (
+	"InferenceProfiler/pkg/collecting/base"
+	"InferenceProfiler/pkg/utils"
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Registration is how a collector package makes itself known. Collectors call
+// Register from init; a binary picks its collectors by importing their
+// packages.
+type Registration struct {
+	utils.CollectorSpec
+	New func() base.Collector
+	// Order places the collector among the others, lowest first, so that
+	// initialisation, polling and listings do not depend on import order.
+	Order int
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   []Registration
+)
+
+// Register adds a collector. It panics on a duplicate or empty name, since
+// both are programming errors caught at startup.
+func Register(r Registration) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if r.Name == "" || r.New == nil {
+		panic("collecting: Register needs a name and a constructor")
+	}
+	for _, existing := range registry {
+		if existing.Name == r.Name {
+			panic(fmt.Sprintf("collecting: collector %q registered twice", r.Name))
+		}
+	}
+	registry = append(registry, r)
+	slices.SortStableFunc(registry, func(a, b Registration) int { return cmp.Compare(a.Order, b.Order) })
+}
+
+// Registered returns all registered collectors by Order, and those of equal
+// Order in registration order.
+func Registered() []Registration {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return append([]Registration(nil), registry...)
+}
+
+// Specs returns the flag-parser view of the registry.
+func Specs() []utils.CollectorSpec {
+	regs := Registered()
+	out := make([]utils.CollectorSpec, len(regs))
+	for i, r := range regs {
+		out[i] = r.CollectorSpec
+	}
+	return out
+}
@@ -1,28 +1,60 @@
 package vllm
 
 import (
+	"InferenceProfiler/pkg/collecting"
+	"InferenceProfiler/pkg/collecting/base"
 	"InferenceProfiler/pkg/utils"
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"time"
 )
 
+const DefaultEndpoint = "http://localhost:8000/metrics"
+
+// Options are the vllm collector's flags.
+type Options struct {
+	Endpoint     string `flag:"vllm-endpoint" arg:"URL" json:"endpoint" spec:"safe" help:"vLLM metrics endpoint"`
+	NoHistograms bool   `flag:"no-vllm-hist" json:"no_histograms" spec:"safe" disable:"vllm-hist" help:"Disable vLLM histogram collection"`
+}
+
 type Collector struct {
 	endpoint    string
 	collectHist bool
 	client      *http.Client
+	health      *endpointHealth
 	last        *vllmDynamic
 }
 
+var errNoMetrics = errors.New("no vLLM metrics in response")
+
 func New() *Collector { return &Collector{} }
 
+func init() {
+	collecting.Register(collecting.Registration{
+		CollectorSpec: utils.CollectorSpec{
+			Name:        "vllm",
+			Description: "vLLM metrics",
+			Default:     true,
+			Options:     func() any { return &Options{Endpoint: DefaultEndpoint} },
+		},
+		New:   func() base.Collector { return New() },
+		Order: 50,
+	})
+}
+
 func (c *Collector) Name() string { return "Vllm" }
 
 func (c *Collector) Init(cfg *utils.Config) error {
-	c.endpoint = cfg.VLLMEndpoint
-	c.collectHist = !cfg.DisableVLLMHistograms
+	opts := utils.CollectorOptions[Options](cfg, "vllm")
+	c.endpoint = opts.Endpoint
+	if c.endpoint == "" {
+		c.endpoint = DefaultEndpoint
+	}
+	c.collectHist = !opts.NoHistograms
 	c.client = utils.NewHTTPClient(1*time.Second, 100*time.Millisecond, 500*time.Millisecond, 1)
+	c.health = newEndpointHealth(time.Duration(cfg.Interval) * time.Millisecond)
 
 	log.Printf("vllm: endpoint=%s histograms=%v", c.endpoint, c.collectHist)
 	return nil
@@ -31,25 +63,50 @@ func (c *Collector) Init(cfg *utils.Config) error {
 func (c *Collector) Static() any { return nil }
 
 func (c *Collector) Poll(ctx context.Context) any {
+	now := time.Now()
+	if !c.health.shouldAttempt(now) {
+		return c.unavailable()
+	}
+
 	body, err := utils.HTTPGet(ctx, c.client, c.endpoint)
 	if err != nil {
-		if c.last == nil {
-			log.Printf("vllm: endpoint not available")
-		}
-		if c.last != nil {
-			cached := *c.last
-			cached.Available = false
-			return &cached
-		}
-		return nil
+		c.health.fail(now, c.endpoint, err)
+		return c.unavailable()
 	}
 	defer body.Close()
 
 	var m vllmDynamic
 	parseVllm(body, c.collectHist, &m)
+	if !m.Available {
+		// A 200 without vLLM metrics is not vLLM, e.g. a proxy's error page.
+		c.health.fail(now, c.endpoint, errNoMetrics)
+		return c.unavailable()
+	}
+
+	reconnected := c.health.succeed(time.Now(), c.endpoint)
+	if countersReset(c.last, &m) {
+		c.health.resets++
+		m.CounterReset = true
+		log.Printf("vllm: counter reset detected (reconnect=%v)", reconnected)
+	}
+	m.Health = c.health.snapshot()
 
 	c.last = &m
 	return &m
 }
 
+// unavailable returns the last good record marked unavailable, so consumers
+// keep the previous values but can tell they are stale from Available and
+// Health.
+func (c *Collector) unavailable() any {
+	m := vllmDynamic{}
+	if c.last != nil {
+		m = *c.last
+	}
+	m.Available = false
+	m.CounterReset = false
+	m.Health = c.health.snapshot()
+	return &m
+}
+
 func (c *Collector) Close() error { return nil }
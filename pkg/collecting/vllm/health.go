@@ -0,0 +1,147 @@
+package vllm
+
+import (
+	"InferenceProfiler/pkg/utils"
+	"log"
+	"time"
+)
+
+// Retries of a down endpoint back off from the poll interval, but never
+// below minBackoff, so a zero interval cannot retry on every tick.
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
+
+type endpointState int
+
+const (
+	stateUnknown endpointState = iota
+	stateUp
+	stateDown
+)
+
+func (s endpointState) String() string {
+	switch s {
+	case stateUp:
+		return "up"
+	case stateDown:
+		return "down"
+	default:
+		return "unknown"
+	}
+}
+
+type Health struct {
+	State               string `json:"State"`
+	ConsecutiveFailures int64  `json:"ConsecutiveFailures"`
+	TotalFailures       int64  `json:"TotalFailures"`
+	Reconnects          int64  `json:"Reconnects"`
+	OutageStart         int64  `json:"OutageStart"`
+	LastOutageStart     int64  `json:"LastOutageStart"`
+	LastOutageEnd       int64  `json:"LastOutageEnd"`
+	CounterResets       int64  `json:"CounterResets"`
+	LastError           string `json:"LastError"`
+}
+
+// endpointHealth tracks availability of the metrics endpoint across polls and
+// schedules retries with exponential backoff while it is down.
+type endpointHealth struct {
+	state       endpointState
+	failures    int64
+	total       int64
+	reconnects  int64
+	resets      int64
+	outageStart time.Time
+	lastStart   time.Time
+	lastEnd     time.Time
+	lastErr     string
+
+	baseBackoff time.Duration
+	backoff     time.Duration
+	nextAttempt time.Time
+}
+
+func newEndpointHealth(base time.Duration) *endpointHealth {
+	return &endpointHealth{baseBackoff: max(base, minBackoff)}
+}
+
+func (h *endpointHealth) shouldAttempt(now time.Time) bool {
+	return h.state != stateDown || !now.Before(h.nextAttempt)
+}
+
+func (h *endpointHealth) fail(now time.Time, endpoint string, err error) {
+	h.failures++
+	h.total++
+	h.lastErr = err.Error()
+
+	if h.state != stateDown {
+		h.outageStart = now
+		h.backoff = h.baseBackoff
+		log.Printf("vllm: endpoint %s down: %v", endpoint, err)
+	} else {
+		h.backoff = min(h.backoff*2, maxBackoff)
+	}
+	h.state = stateDown
+	h.nextAttempt = now.Add(h.backoff)
+	utils.Debugf("vllm: failure #%d, next attempt in %v", h.failures, h.backoff)
+}
+
+// succeed records a successful scrape and reports whether the endpoint was
+// previously down, i.e. this poll is a reconnect.
+func (h *endpointHealth) succeed(now time.Time, endpoint string) bool {
+	reconnected := h.state == stateDown
+	if reconnected {
+		h.reconnects++
+		h.lastStart = h.outageStart
+		h.lastEnd = now
+		log.Printf("vllm: endpoint %s recovered after %v (%d failed attempts)",
+			endpoint, now.Sub(h.outageStart).Round(time.Millisecond), h.failures)
+	} else if h.state == stateUnknown {
+		log.Printf("vllm: endpoint %s up", endpoint)
+	}
+	h.state = stateUp
+	h.failures = 0
+	h.backoff = 0
+	h.outageStart = time.Time{}
+	return reconnected
+}
+
+func (h *endpointHealth) snapshot() Health {
+	return Health{
+		State:               h.state.String(),
+		ConsecutiveFailures: h.failures,
+		TotalFailures:       h.total,
+		Reconnects:          h.reconnects,
+		OutageStart:         unixNanoOrZero(h.outageStart),
+		LastOutageStart:     unixNanoOrZero(h.lastStart),
+		LastOutageEnd:       unixNanoOrZero(h.lastEnd),
+		CounterResets:       h.resets,
+		LastError:           h.lastErr,
+	}
+}
+
+func unixNanoOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// countersReset reports whether vLLM restarted between two successful
+// scrapes: its process start time changed or any monotonic series went
+// backwards. Series in only one of the scrapes are not compared.
+func countersReset(prev, cur *vllmDynamic) bool {
+	if prev == nil || !prev.Available || !cur.Available {
+		return false
+	}
+	if prev.startTime != 0 && cur.startTime != 0 && cur.startTime != prev.startTime {
+		return true
+	}
+	for name, v := range cur.counters {
+		if old, ok := prev.counters[name]; ok && v < old {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package vllm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEndpointHealthBackoff(t *testing.T) {
+	// A zero poll interval still backs off from minBackoff.
+	h := newEndpointHealth(0)
+	now := time.Unix(1000, 0)
+	down := errors.New("connection refused")
+
+	want := []time.Duration{100, 200, 400, 800, 1600, 3200, 6400, 12800, 25600, 30000, 30000}
+	for i, backoff := range want {
+		backoff *= time.Millisecond
+		h.fail(now, "test", down)
+		if h.backoff != backoff {
+			t.Fatalf("failure %d: backoff %v, want %v", i+1, h.backoff, backoff)
+		}
+		if h.shouldAttempt(now.Add(backoff - time.Nanosecond)) {
+			t.Fatalf("failure %d: attempt before the backoff", i+1)
+		}
+		now = now.Add(backoff)
+		if !h.shouldAttempt(now) {
+			t.Fatalf("failure %d: no attempt after the backoff", i+1)
+		}
+	}
+	if s := h.snapshot(); s.State != "down" || s.ConsecutiveFailures != int64(len(want)) || s.OutageStart != time.Unix(1000, 0).UnixNano() {
+		t.Errorf("snapshot while down = %+v", s)
+	}
+
+	if !h.succeed(now, "test") {
+		t.Error("succeed after an outage is not a reconnect")
+	}
+	if s := h.snapshot(); s.State != "up" || s.ConsecutiveFailures != 0 || s.Reconnects != 1 || s.OutageStart != 0 ||
+		s.LastOutageStart != time.Unix(1000, 0).UnixNano() || s.LastOutageEnd != now.UnixNano() {
+		t.Errorf("snapshot after recovery = %+v", s)
+	}
+	if !h.shouldAttempt(now) {
+		t.Error("no attempt while up")
+	}
+
+	// The next outage starts again from the base.
+	h.fail(now, "test", down)
+	if h.backoff != minBackoff || h.total != int64(len(want))+1 {
+		t.Errorf("after a new failure: backoff %v, total %d", h.backoff, h.total)
+	}
+}
+
+func TestEndpointHealthBaseInterval(t *testing.T) {
+	h := newEndpointHealth(20 * time.Second)
+	now := time.Now()
+	for _, want := range []time.Duration{20 * time.Second, maxBackoff, maxBackoff} {
+		h.fail(now, "test", errNoMetrics)
+		if h.backoff != want {
+			t.Errorf("backoff %v, want %v", h.backoff, want)
+		}
+	}
+}
+
+// scrape parses a metrics page, with a gauge added so it is vLLM's.
+func scrape(t *testing.T, page string) *vllmDynamic {
+	t.Helper()
+	var m vllmDynamic
+	parseVllm(strings.NewReader(page+"vllm:num_requests_waiting{model_name=\"m\"} 0\n"), false, &m)
+	if !m.Available {
+		t.Fatalf("no vLLM metrics in\n%s", page)
+	}
+	return &m
+}
+
+func TestCountersReset(t *testing.T) {
+	const before = `# HELP vllm:num_requests_running Number of requests running.
+vllm:num_requests_running{model_name="m"} 4
+vllm:prompt_tokens_total{model_name="m"} 5000
+vllm:request_success_total{finished_reason="stop",model_name="m"} 40
+vllm:request_success_total{finished_reason="length",model_name="m"} 2
+vllm:e2e_request_latency_seconds_bucket{le="1.0",model_name="m"} 30
+vllm:e2e_request_latency_seconds_count{model_name="m"} 42
+process_start_time_seconds 1.7e+09
+`
+	tests := []struct {
+		name, after string
+		want        bool
+	}{
+		{"counters grow, gauges drop", `vllm:num_requests_running{model_name="m"} 0
+vllm:prompt_tokens_total{model_name="m"} 6000
+vllm:request_success_total{finished_reason="stop",model_name="m"} 41
+vllm:e2e_request_latency_seconds_bucket{le="1.0",model_name="m"} 31
+process_start_time_seconds 1.7e+09
+`, false},
+		{"a token counter drops", `vllm:prompt_tokens_total{model_name="m"} 10
+process_start_time_seconds 1.7e+09
+`, true},
+		{"one labelled series drops", `vllm:request_success_total{finished_reason="stop",model_name="m"} 41
+vllm:request_success_total{finished_reason="length",model_name="m"} 1
+`, true},
+		{"a histogram count drops", `vllm:e2e_request_latency_seconds_count{model_name="m"} 3
+`, true},
+		// A restart quick enough that every counter already grew back.
+		{"the process start changes", `vllm:prompt_tokens_total{model_name="m"} 9000
+process_start_time_seconds 1.7000001e+09
+`, true},
+		{"a series appears", `vllm:prompt_tokens_total{model_name="m"} 5000
+vllm:generation_tokens_total{model_name="m"} 1
+`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countersReset(scrape(t, before), scrape(t, tt.after)); got != tt.want {
+				t.Errorf("countersReset = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if countersReset(nil, scrape(t, before)) {
+		t.Error("reset on the first scrape")
+	}
+}
@@ -11,6 +11,8 @@ import (
 
 type vllmDynamic struct {
 	Available              bool             `json:"Available"`
+	CounterReset           bool             `json:"CounterReset"`
+	Health                 Health           `json:"Health"`
 	NumRequestsRunning     base.MetricFloat `json:"NumRequestsRunning"`
 	NumRequestsWaiting     base.MetricFloat `json:"NumRequestsWaiting"`
 	KvCacheUsagePercent    base.MetricFloat `json:"KvCacheUsagePercent"`
@@ -27,6 +29,17 @@ type vllmDynamic struct {
 	PromptTokensHist       base.MetricStr   `json:"PromptTokensHist"`
 	GenerationTokensHist   base.MetricStr   `json:"GenerationTokensHist"`
 	TimePerOutputTokenHist base.MetricStr   `json:"TimePerOutputTokenHist"`
+
+	// counters holds every monotonic series by name and labels, and
+	// startTime the process start, to tell a restart between two scrapes.
+	counters  map[string]float64
+	startTime float64
+}
+
+// monotonic reports whether a series only grows while the process lives:
+// counters, and the bucket counts and totals of histograms.
+func monotonic(name string) bool {
+	return strings.HasSuffix(name, "_total") || strings.HasSuffix(name, "_bucket") || strings.HasSuffix(name, "_count")
 }
 
 func parseVllm(r io.Reader, collectHistograms bool, m *vllmDynamic) {
@@ -56,6 +69,7 @@ func parseVllm(r io.Reader, collectHistograms bool, m *vllmDynamic) {
 	}
 
 	buckets := make(map[string]map[string]float64)
+	m.counters = make(map[string]float64)
 
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
@@ -71,6 +85,16 @@ func parseVllm(r io.Reader, collectHistograms bool, m *vllmDynamic) {
 
 		name = strings.Replace(name, "vllm:", "vllm_", 1)
 
+		cleanName := name
+		if idx := strings.IndexByte(name, '{'); idx > 0 {
+			cleanName = name[:idx]
+		}
+		if monotonic(cleanName) {
+			m.counters[name] = value
+		} else if cleanName == "process_start_time_seconds" {
+			m.startTime = value
+		}
+
 		if collectHistograms && strings.Contains(name, "_bucket") {
 			baseName := name[:strings.Index(name, "_bucket")]
 			if _, ok := histMetrics[baseName]; ok {
@@ -85,11 +109,6 @@ func parseVllm(r io.Reader, collectHistograms bool, m *vllmDynamic) {
 			continue
 		}
 
-		cleanName := name
-		if idx := strings.IndexByte(name, '{'); idx > 0 {
-			cleanName = name[:idx]
-		}
-
 		if ptr, ok := floatMetrics[cleanName]; ok {
 			*ptr = base.MetricFloat{V: value, T: utils.GetTimestamp()}
 			foundData = true
@@ -1,6 +1,8 @@
 package vm
 
 import (
+	"InferenceProfiler/pkg/collecting"
+	"InferenceProfiler/pkg/collecting/base"
 	"InferenceProfiler/pkg/utils"
 	"context"
 )
@@ -25,6 +27,18 @@ type Collector struct {
 
 func New() *Collector { return &Collector{} }
 
+func init() {
+	collecting.Register(collecting.Registration{
+		CollectorSpec: utils.CollectorSpec{
+			Name:        "vm",
+			Description: "VM metrics (cpu, mem, disk, net)",
+			Default:     true,
+		},
+		New:   func() base.Collector { return New() },
+		Order: 10,
+	})
+}
+
 func (c *Collector) Name() string { return "Vm" }
 
 func (c *Collector) Init(_ *utils.Config) error {
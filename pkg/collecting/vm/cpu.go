@@ -10,8 +10,6 @@ import (
 	"runtime"
 	"strings"
 	"syscall"
-
-	"github.com/beevik/ntp"
 )
 
 var (
@@ -27,7 +25,6 @@ type CpuStatic struct {
 	CPUType       string `json:"Type"`
 	CPUCache      string `json:"Cache"`
 	KernelInfo    string `json:"KernelInfo"`
-	NTPInfo       string `json:"NTPInfo"`
 }
 
 type CpuDynamic struct {
@@ -53,10 +50,6 @@ func collectCpuStatic(s *CpuStatic) {
 
 	utils.Debugf("cpu: static hostname=%s cpus=%d type=%s", s.HostName, s.NumProcessors, s.CPUType)
 	utils.Debugf("cpu: cache=%s", s.CPUCache)
-
-	t := utils.DebugTimer()
-	s.NTPInfo = getNTPInfo()
-	utils.DebugDuration("cpu", "NTP query", t)
 }
 
 func collectCpuDynamic(d *CpuDynamic) {
@@ -158,39 +151,6 @@ func getKernelInfo() string {
 	return string(data)
 }
 
-func getNTPInfo() string {
-	response, err := ntp.Query("pool.ntp.org")
-	if err != nil {
-		utils.Debugf("cpu: NTP query failed: %v", err)
-		data, _ := json.Marshal(map[string]interface{}{
-			"Status": err.Error(),
-		})
-		return string(data)
-	}
-	utils.Debugf("cpu: NTP offset=%v rtt=%v stratum=%d", response.ClockOffset, response.RTT, response.Stratum)
-	data, err := json.Marshal(map[string]interface{}{
-		"Status":         "success",
-		"ClockOffset":    response.ClockOffset,
-		"Time":           response.Time,
-		"RTT":            response.RTT,
-		"Precision":      response.Precision,
-		"Stratum":        response.Stratum,
-		"ReferenceID":    response.ReferenceID,
-		"ReferenceTime":  response.ReferenceTime,
-		"RootDelay":      response.RootDelay,
-		"RootDispersion": response.RootDispersion,
-		"RootDistance":   response.RootDistance,
-		"Leap":           response.Leap,
-		"MinError":       response.MinError,
-		"KissCode":       response.KissCode,
-		"Poll":           response.Poll,
-	})
-	if err != nil {
-		return err.Error()
-	}
-	return string(data)
-}
-
 func getLoadAvg() base.MetricFloat {
 	val, ts, _ := utils.File(procLoadavg)
 	if parts := strings.Fields(val); len(parts) > 0 {
@@ -0,0 +1,75 @@
+package collecting
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+)
+
+// CollectorHealth is the watchdog view of a running collector, reported
+// alongside InitResult by the server.
+type CollectorHealth struct {
+	Name                string `json:"name"`
+	State               string `json:"state"`
+	ConsecutiveFailures int64  `json:"consecutive_failures"`
+	Timeouts            int64  `json:"timeouts"`
+	Panics              int64  `json:"panics"`
+	LastError           string `json:"last_error,omitempty"`
+	DegradedSince       int64  `json:"degraded_since,omitempty"`
+}
+
+// watchdog counts failed polls of one collector and marks it degraded after
+// a run of consecutive failures. A single successful poll clears it.
+type watchdog struct {
+	name      string
+	threshold int64
+
+	failures      int64
+	timeouts      int64
+	panics        int64
+	lastErr       string
+	degradedSince time.Time
+}
+
+func (w *watchdog) fail(err error, timeout, panicked bool) {
+	w.failures++
+	w.lastErr = err.Error()
+	if timeout {
+		w.timeouts++
+	}
+	if panicked {
+		w.panics++
+	}
+	if w.failures == w.threshold && w.degradedSince.IsZero() {
+		w.degradedSince = time.Now()
+		log.Printf("watchdog: %s degraded after %d consecutive failed polls: %v", w.name, w.failures, err)
+	}
+}
+
+func (w *watchdog) succeed() {
+	if !w.degradedSince.IsZero() {
+		log.Printf("watchdog: %s recovered after %v", w.name, time.Since(w.degradedSince).Round(time.Millisecond))
+		w.degradedSince = time.Time{}
+	}
+	w.failures = 0
+}
+
+func (w *watchdog) snapshot() CollectorHealth {
+	h := CollectorHealth{
+		Name:                w.name,
+		State:               healthOK,
+		ConsecutiveFailures: w.failures,
+		Timeouts:            w.timeouts,
+		Panics:              w.panics,
+		LastError:           w.lastErr,
+	}
+	if !w.degradedSince.IsZero() {
+		h.State = healthDegraded
+		h.DegradedSince = w.degradedSince.UnixNano()
+	}
+	return h
+}
@@ -0,0 +1,387 @@
+// Package controlling drives several infpro servers as one: it starts and
+// stops runs with a shared UUID, gathers their status and collects their
+// files, using the same HTTP API as any other client.
+package controlling
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"InferenceProfiler/pkg/serving"
+	"InferenceProfiler/pkg/utils"
+)
+
+// Host is one infpro server.
+type Host struct {
+	Name string `json:"name"` // host:port, also the directory its files go to
+	URL  string `json:"url"`  // base URL of its API
+}
+
+// ParseHosts turns "host", "host:port" and URL entries into Hosts, using
+// port and scheme where an entry has none.
+func ParseHosts(entries []string, port int, scheme string) ([]Host, error) {
+	var hosts []Host
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if !strings.Contains(e, "://") {
+			e = scheme + "://" + e
+		}
+		u, err := url.Parse(e)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("host %q: want host, host:port or a URL", e)
+		}
+		if u.Port() == "" {
+			u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(port))
+		}
+		if seen[u.Host] {
+			return nil, fmt.Errorf("host %s given twice", u.Host)
+		}
+		seen[u.Host] = true
+		hosts = append(hosts, Host{Name: u.Host, URL: strings.TrimSuffix(u.String(), "/")})
+	}
+	if len(hosts) == 0 {
+		return nil, errors.New("no hosts given")
+	}
+	return hosts, nil
+}
+
+// ReadHostsFile reads host entries one per line, skipping blank lines and
+// # comments.
+func ReadHostsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return entries, sc.Err()
+}
+
+// Result is one host's outcome of a fleet operation. Run is the host's view
+// of the run afterwards, when it could be had; Files lists what Collect
+// wrote.
+type Result struct {
+	Host  Host             `json:"host"`
+	Run   *serving.RunInfo `json:"run,omitempty"`
+	Files []string         `json:"files,omitempty"`
+	Error string           `json:"error,omitempty"`
+}
+
+func (r Result) Failed() bool { return r.Error != "" }
+
+// Fleet sends every operation to all its hosts at once and waits for each
+// to answer.
+type Fleet struct {
+	Hosts   []Host
+	client  *http.Client
+	token   string
+	timeout time.Duration
+}
+
+// New returns a Fleet of hosts reached through client. token, if set, is
+// sent as a bearer token; timeout bounds each API call but not downloads.
+func New(hosts []Host, client *http.Client, token string, timeout time.Duration) *Fleet {
+	return &Fleet{Hosts: hosts, client: client, token: token, timeout: timeout}
+}
+
+// With returns a Fleet of hosts sharing f's client and settings.
+func (f *Fleet) With(hosts []Host) *Fleet {
+	g := *f
+	g.Hosts = hosts
+	return &g
+}
+
+// Succeeded returns the hosts of results that did not fail.
+func Succeeded(results []Result) []Host {
+	var hosts []Host
+	for _, r := range results {
+		if !r.Failed() {
+			hosts = append(hosts, r.Host)
+		}
+	}
+	return hosts
+}
+
+// each runs op against every host concurrently and returns the results in
+// host order.
+func (f *Fleet) each(ctx context.Context, op func(ctx context.Context, h Host) (Result, error)) []Result {
+	results := make([]Result, len(f.Hosts))
+	var wg sync.WaitGroup
+	for i, h := range f.Hosts {
+		wg.Go(func() {
+			r, err := op(ctx, h)
+			r.Host = h
+			if err != nil {
+				r.Error = err.Error()
+			}
+			results[i] = r
+		})
+	}
+	wg.Wait()
+	return results
+}
+
+// Start starts a run with spec on every host through PUT /collect, so a
+// host already collecting refuses. spec.UUID must be set for the runs to
+// share it.
+func (f *Fleet) Start(ctx context.Context, spec utils.RunSpec) []Result {
+	return f.each(ctx, func(ctx context.Context, h Host) (Result, error) {
+		if err := f.call(ctx, h, http.MethodPut, "/collect", spec, nil); err != nil {
+			return Result{}, err
+		}
+		var info serving.RunInfo
+		if err := f.call(ctx, h, http.MethodGet, "/runs/"+url.PathEscape(spec.UUID), nil, &info); err != nil {
+			return Result{}, nil // started; the details are only for show
+		}
+		return Result{Run: &info}, nil
+	})
+}
+
+// StartAll starts the run like Start and, if any host fails, stops it on
+// every host again, so either all hosts record it or none do. Hosts that
+// failed are stopped too: a start that timed out here may still have gone
+// through on the host. A host whose rollback stop fails is reported as
+// failed with both errors.
+func (f *Fleet) StartAll(ctx context.Context, spec utils.RunSpec) []Result {
+	results := f.Start(ctx, spec)
+	if len(Succeeded(results)) == len(results) {
+		return results
+	}
+	stops := f.each(ctx, func(ctx context.Context, h Host) (Result, error) {
+		info, err := f.stop(ctx, h, spec.UUID)
+		var se *statusError
+		if errors.As(err, &se) && se.code == http.StatusNotFound {
+			return Result{}, nil // never started there
+		}
+		return Result{Run: info}, err
+	})
+	for i, s := range stops {
+		r := &results[i]
+		if s.Run != nil {
+			r.Run = s.Run
+		}
+		switch {
+		case !s.Failed():
+		case r.Failed():
+			r.Error += "; stopping: " + s.Error
+		default:
+			r.Error = "started, but stopping it again failed: " + s.Error
+		}
+	}
+	return results
+}
+
+// Stop stops run uuid on every host and waits for its output to be
+// flushed. Hosts where it had already ended report it as is.
+func (f *Fleet) Stop(ctx context.Context, uuid string) []Result {
+	return f.each(ctx, func(ctx context.Context, h Host) (Result, error) {
+		info, err := f.stop(ctx, h, uuid)
+		if err != nil {
+			return Result{}, err
+		}
+		return Result{Run: info}, nil
+	})
+}
+
+// stop stops run uuid on h, or returns it as is if it already ended.
+func (f *Fleet) stop(ctx context.Context, h Host, uuid string) (*serving.RunInfo, error) {
+	var info serving.RunInfo
+	err := f.call(ctx, h, http.MethodPost, "/runs/"+url.PathEscape(uuid)+"/stop", nil, &info)
+	var se *statusError
+	if errors.As(err, &se) && se.code == http.StatusConflict {
+		err = f.call(ctx, h, http.MethodGet, "/runs/"+url.PathEscape(uuid), nil, &info)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Status reports run uuid on every host, or without a uuid each host's
+// newest running run, if any.
+func (f *Fleet) Status(ctx context.Context, uuid string) []Result {
+	return f.each(ctx, func(ctx context.Context, h Host) (Result, error) {
+		if uuid != "" {
+			var info serving.RunInfo
+			if err := f.call(ctx, h, http.MethodGet, "/runs/"+url.PathEscape(uuid), nil, &info); err != nil {
+				return Result{}, err
+			}
+			return Result{Run: &info}, nil
+		}
+		var list struct {
+			Runs []serving.RunInfo `json:"runs"`
+		}
+		if err := f.call(ctx, h, http.MethodGet, "/runs?state="+serving.RunRunning+"&limit=1", nil, &list); err != nil {
+			return Result{}, err
+		}
+		if len(list.Runs) == 0 {
+			return Result{}, nil
+		}
+		return Result{Run: &list.Runs[0]}, nil
+	})
+}
+
+// convertedExt names the local copy of a JSONL file downloaded as format.
+var convertedExt = map[string]string{
+	serving.FormatFlatJSONL: ".flat.jsonl",
+	serving.FormatCSV:       ".csv",
+	serving.FormatParquet:   ".parquet",
+}
+
+// Collect downloads every file of run uuid from each host into dir/{host}.
+// With a format, JSONL files are fetched converted to it. JSONL output
+// already there with the listed size is not fetched again; it only grows,
+// while the catalogue rewrites .run.json in place, so that is always
+// fetched.
+func (f *Fleet) Collect(ctx context.Context, uuid, dir, format string) []Result {
+	return f.each(ctx, func(ctx context.Context, h Host) (Result, error) {
+		var list struct {
+			Files []struct {
+				Name string `json:"name"`
+				Size int64  `json:"size"`
+			} `json:"files"`
+		}
+		if err := f.call(ctx, h, http.MethodGet, "/files?uuid="+url.QueryEscape(uuid), nil, &list); err != nil {
+			return Result{}, err
+		}
+		hostDir := filepath.Join(dir, strings.NewReplacer(":", "_", "/", "_").Replace(h.Name))
+		if err := os.MkdirAll(hostDir, 0755); err != nil {
+			return Result{}, err
+		}
+
+		var r Result
+		var info serving.RunInfo
+		if err := f.call(ctx, h, http.MethodGet, "/runs/"+url.PathEscape(uuid), nil, &info); err == nil {
+			r.Run = &info
+		}
+		for _, file := range list.Files {
+			// The listing matches by prefix; other runs may share it.
+			if !strings.HasPrefix(file.Name, uuid+".") {
+				continue
+			}
+			name, query := file.Name, ""
+			if ext, ok := convertedExt[format]; ok && strings.HasSuffix(name, ".jsonl") {
+				name, query = strings.TrimSuffix(name, ".jsonl")+ext, "?format="+format
+			} else if st, err := os.Stat(filepath.Join(hostDir, name)); err == nil && st.Size() == file.Size && strings.HasSuffix(name, ".jsonl") {
+				r.Files = append(r.Files, filepath.Join(hostDir, name))
+				continue
+			}
+			path := filepath.Join(hostDir, name)
+			if err := f.download(ctx, h, "/files/"+url.PathEscape(file.Name)+query, path); err != nil {
+				return r, fmt.Errorf("%s: %w", file.Name, err)
+			}
+			r.Files = append(r.Files, path)
+		}
+		if len(r.Files) == 0 {
+			return r, fmt.Errorf("no files for run %s", uuid)
+		}
+		return r, nil
+	})
+}
+
+// download writes the body of GET path to file, through a temporary file
+// so an interrupted download leaves nothing half-written behind.
+func (f *Fleet) download(ctx context.Context, h Host, path, file string) error {
+	resp, err := f.send(ctx, h, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	tmp := file + ".part"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, resp.Body)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// statusError is a non-2xx response, with the server's message.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.code, http.StatusText(e.code), e.msg)
+}
+
+// call makes an API call bounded by the fleet's timeout, sending body and
+// decoding the response into out as JSON when they are not nil.
+func (f *Fleet) call(ctx context.Context, h Host, method, path string, body, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	resp, err := f.send(ctx, h, method, path, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send makes a request and turns non-2xx responses into a statusError.
+func (f *Fleet) send(ctx context.Context, h Host, method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, h.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if f.token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.token)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &statusError{code: resp.StatusCode, msg: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
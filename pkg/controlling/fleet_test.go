@@ -0,0 +1,252 @@
+package controlling
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"InferenceProfiler/pkg/collecting"
+	"InferenceProfiler/pkg/serving"
+	"InferenceProfiler/pkg/utils"
+)
+
+func TestParseHosts(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    []Host // nil: an error
+	}{
+		{"bare host", []string{"10.0.0.5"}, []Host{{"10.0.0.5:8888", "http://10.0.0.5:8888"}}},
+		{"host and port", []string{" a:9000 ", ""}, []Host{{"a:9000", "http://a:9000"}}},
+		{"URL keeps its scheme", []string{"https://b/"}, []Host{{"b:8888", "https://b:8888"}}},
+		{"IPv6", []string{"[::1]:7"}, []Host{{"[::1]:7", "http://[::1]:7"}}},
+		{"several", []string{"a", "b:1"}, []Host{{"a:8888", "http://a:8888"}, {"b:1", "http://b:1"}}},
+		{"duplicate", []string{"a", "a:8888"}, nil},
+		{"no hosts", []string{"", " "}, nil},
+		{"no host in URL", []string{"http://"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHosts(tt.entries, 8888, "http")
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("ParseHosts = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ParseHosts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadHostsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	data := "# fleet\n10.0.0.5\n\n  10.0.0.6:9000  # second\n#10.0.0.7\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadHostsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.5", "10.0.0.6:9000"}; !slices.Equal(got, want) {
+		t.Errorf("ReadHostsFile = %q, want %q", got, want)
+	}
+	if _, err := ReadHostsFile(filepath.Join(t.TempDir(), "none")); err == nil {
+		t.Error("ReadHostsFile of a missing file succeeded")
+	}
+}
+
+// testHost is a real server, with no collectors, behind an httptest
+// listener. delayStart holds the response to PUT /collect back after the
+// run has started, as a slow network would.
+type testHost struct {
+	Host
+	dir        string
+	api        http.Handler
+	delayStart time.Duration
+}
+
+func newTestHost(t *testing.T) *testHost {
+	t.Helper()
+	cfg := &utils.Config{
+		OutputDir:     t.TempDir(),
+		Interval:      20,
+		StalePolicy:   utils.StaleSkip,
+		StaleAfter:    3,
+		DegradedAfter: 3,
+		QueueSize:     4,
+		QueuePolicy:   utils.QueueBlock,
+		Disabled:      make(map[string]bool),
+	}
+	m := collecting.NewManager(cfg)
+	srv := serving.NewServer(m)
+	h := &testHost{dir: cfg.OutputDir, api: srv.Handler()}
+	ts := httptest.NewServer(h)
+	t.Cleanup(func() {
+		ts.Close()
+		srv.Shutdown(context.Background())
+		m.Close()
+	})
+	h.Host = Host{Name: strings.TrimPrefix(ts.URL, "http://"), URL: ts.URL}
+	return h
+}
+
+func (h *testHost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.api.ServeHTTP(w, r)
+	if r.Method == http.MethodPut && r.URL.Path == "/collect" {
+		time.Sleep(h.delayStart)
+	}
+}
+
+// refusingHost answers every request with 503.
+func refusingHost(t *testing.T) Host {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(ts.Close)
+	return Host{Name: strings.TrimPrefix(ts.URL, "http://"), URL: ts.URL}
+}
+
+func runState(t *testing.T, h Host, uuid string) string {
+	t.Helper()
+	results := New([]Host{h}, http.DefaultClient, "", time.Second).Status(context.Background(), uuid)
+	if results[0].Run == nil {
+		return "none"
+	}
+	return results[0].Run.State
+}
+
+func TestFleetStartStopCollect(t *testing.T) {
+	a, b := newTestHost(t), newTestHost(t)
+	fleet := New([]Host{a.Host, b.Host}, http.DefaultClient, "", 5*time.Second)
+	ctx := context.Background()
+
+	for _, r := range fleet.StartAll(ctx, utils.RunSpec{UUID: "exp-1"}) {
+		if r.Failed() || r.Run == nil || r.Run.State != serving.RunRunning {
+			t.Fatalf("%s: start: %+v", r.Host.Name, r)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	for _, r := range fleet.Stop(ctx, "exp-1") {
+		if r.Failed() || r.Run.State != serving.RunStopped {
+			t.Fatalf("%s: stop: %+v", r.Host.Name, r)
+		}
+	}
+	// Stopping again reports the run as it ended.
+	for _, r := range fleet.Stop(ctx, "exp-1") {
+		if r.Failed() || r.Run.State != serving.RunStopped {
+			t.Errorf("%s: second stop: %+v", r.Host.Name, r)
+		}
+	}
+
+	dir := t.TempDir()
+	for _, r := range fleet.Collect(ctx, "exp-1", dir, "") {
+		if r.Failed() {
+			t.Fatalf("%s: collect: %s", r.Host.Name, r.Error)
+		}
+		hostDir := filepath.Join(dir, strings.ReplaceAll(r.Host.Name, ":", "_"))
+		want := []string{filepath.Join(hostDir, "exp-1.jsonl"), filepath.Join(hostDir, "exp-1.run.json")}
+		if !slices.Equal(r.Files, want) {
+			t.Errorf("%s: collected %q, want %q", r.Host.Name, r.Files, want)
+		}
+		for _, path := range r.Files {
+			if _, err := os.Stat(path); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+	if got := fleet.Collect(ctx, "nope", dir, ""); !got[0].Failed() {
+		t.Errorf("collecting a run no host has: %+v", got[0])
+	}
+}
+
+// TestFleetCollectRefetchesRunInfo checks that collect fetches .run.json
+// again even when the local copy has its size: the catalogue rewrites it
+// in place, e.g. from "running" to "stopped", which are the same length.
+func TestFleetCollectRefetchesRunInfo(t *testing.T) {
+	h := newTestHost(t)
+	fleet := New([]Host{h.Host}, http.DefaultClient, "", 5*time.Second)
+	ctx := context.Background()
+	if r := fleet.Start(ctx, utils.RunSpec{UUID: "exp-4"}); r[0].Failed() {
+		t.Fatal(r[0].Error)
+	}
+	fleet.Stop(ctx, "exp-4")
+	want, err := os.ReadFile(filepath.Join(h.dir, "exp-4.run.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	hostDir := filepath.Join(dir, strings.ReplaceAll(h.Name, ":", "_"))
+	if err := os.MkdirAll(hostDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	stale := bytes.Repeat([]byte("x"), len(want))
+	if err := os.WriteFile(filepath.Join(hostDir, "exp-4.run.json"), stale, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if r := fleet.Collect(ctx, "exp-4", dir, ""); r[0].Failed() {
+		t.Fatal(r[0].Error)
+	}
+	if got, _ := os.ReadFile(filepath.Join(hostDir, "exp-4.run.json")); !bytes.Equal(got, want) {
+		t.Errorf("collected run info\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFleetStartAllRollsBack(t *testing.T) {
+	ok, slow := newTestHost(t), newTestHost(t)
+	down := refusingHost(t)
+	// The slow host starts the run but answers after the client gave up.
+	slow.delayStart = 300 * time.Millisecond
+	fleet := New([]Host{ok.Host, down, slow.Host}, http.DefaultClient, "", 100*time.Millisecond)
+
+	results := fleet.StartAll(context.Background(), utils.RunSpec{UUID: "exp-2"})
+	if results[0].Failed() || results[0].Run == nil || results[0].Run.State != serving.RunStopped {
+		t.Errorf("started host: %+v, want stopped again", results[0])
+	}
+	if !results[1].Failed() || !strings.Contains(results[1].Error, "503") {
+		t.Errorf("refusing host: %+v, want its 503", results[1])
+	}
+	if !results[2].Failed() {
+		t.Errorf("timed out host: %+v, want failed", results[2])
+	}
+	if got := Succeeded(results); len(got) != 1 || got[0] != ok.Host {
+		t.Errorf("Succeeded = %v", got)
+	}
+
+	// Rolled back everywhere, including where the start timed out.
+	time.Sleep(slow.delayStart)
+	for _, h := range []*testHost{ok, slow} {
+		if state := runState(t, h.Host, "exp-2"); state != serving.RunStopped {
+			t.Errorf("%s: run %s, want stopped", h.Name, state)
+		}
+	}
+}
+
+func TestFleetStartPartial(t *testing.T) {
+	ok := newTestHost(t)
+	fleet := New([]Host{ok.Host, refusingHost(t)}, http.DefaultClient, "", time.Second)
+
+	// Start, unlike StartAll, leaves the hosts that started running.
+	results := fleet.Start(context.Background(), utils.RunSpec{UUID: "exp-3"})
+	if results[0].Failed() || !results[1].Failed() {
+		t.Fatalf("results %+v", results)
+	}
+	if state := runState(t, ok.Host, "exp-3"); state != serving.RunRunning {
+		t.Errorf("run %s, want running", state)
+	}
+	fleet.With([]Host{ok.Host}).Stop(context.Background(), "exp-3")
+}
@@ -0,0 +1,164 @@
+package serving
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"InferenceProfiler/pkg/alerting"
+	"InferenceProfiler/pkg/collecting"
+	"InferenceProfiler/pkg/utils"
+)
+
+// Request and response bodies of the API, beyond RunInfo and the run spec
+// (utils.RunSpec). pkg/client decodes into the same types.
+
+// Snapshot is the response of GET /snapshot. Tick is empty unless a run is
+// collecting.
+type Snapshot struct {
+	Static map[string]any `json:"static"`
+	Tick   map[string]any `json:"tick"`
+}
+
+// CollectStatus is the response of GET /collect. While collecting it
+// describes the latest running run, otherwise the latest run in the Last
+// fields.
+type CollectStatus struct {
+	State          string                       `json:"state"` // "collecting" or "idle"
+	Mode           string                       `json:"mode,omitempty"`
+	UUID           string                       `json:"uuid,omitempty"`
+	Elapsed        string                       `json:"elapsed,omitempty"`
+	Config         *utils.RunSpec               `json:"config,omitempty"`
+	LastUUID       string                       `json:"last_uuid,omitempty"`
+	LastMode       string                       `json:"last_mode,omitempty"`
+	LastCount      int64                        `json:"last_count,omitempty"`
+	LastStopReason string                       `json:"last_stop_reason,omitempty"`
+	Collectors     []collecting.InitResult      `json:"collectors"`
+	Health         []collecting.CollectorHealth `json:"health"`
+}
+
+// RunStarted is the response of PUT /collect.
+type RunStarted struct {
+	State  string        `json:"state"` // "started"
+	UUID   string        `json:"uuid"`
+	Config utils.RunSpec `json:"config"`
+}
+
+// StateChange is the response of the requests that stop or delete a run:
+// DELETE /collect ("stopped"), DELETE /runs/{uuid} and DELETE /files/{uuid}
+// ("deleted", the latter listing the files removed).
+type StateChange struct {
+	State string   `json:"state"`
+	UUID  string   `json:"uuid"`
+	Files []string `json:"files,omitempty"`
+}
+
+// MarkerRequest is the body of POST /collect/markers and
+// POST /runs/{uuid}/markers. UUID picks the run for the former; by default
+// it is the latest running one.
+type MarkerRequest struct {
+	Label string `json:"label"`
+	UUID  string `json:"uuid,omitempty"`
+}
+
+// MarkerResponse is the marker as recorded, with the run it went to.
+type MarkerResponse struct {
+	UUID   string            `json:"uuid"`
+	Marker collecting.Marker `json:"marker"`
+}
+
+// RunQuery filters and pages GET /runs. Since and Until take RFC 3339 times
+// or durations back from now ("24h"); UUID matches a prefix. Zero fields
+// are left out.
+type RunQuery struct {
+	State     string
+	UUID      string
+	Host      string
+	Collector string
+	Marker    string
+	Since     string
+	Until     string
+	Offset    int
+	Limit     int
+}
+
+// Values encodes q as GET /runs query parameters.
+func (q RunQuery) Values() url.Values {
+	v := url.Values{}
+	for key, val := range map[string]string{
+		"state": q.State, "uuid": q.UUID, "host": q.Host, "collector": q.Collector,
+		"marker": q.Marker, "since": q.Since, "until": q.Until,
+	} {
+		if val != "" {
+			v.Set(key, val)
+		}
+	}
+	if q.Offset > 0 {
+		v.Set("offset", strconv.Itoa(q.Offset))
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return v
+}
+
+// RunList is the response of GET /runs: one page of Total matching runs,
+// newest first.
+type RunList struct {
+	Runs   []RunInfo `json:"runs"`
+	Count  int       `json:"count"`
+	Total  int       `json:"total"`
+	Offset int       `json:"offset"`
+	Limit  int       `json:"limit"`
+}
+
+// CollectorList is the response of GET /collectors.
+type CollectorList struct {
+	Collectors []collecting.InitResult      `json:"collectors"`
+	Health     []collecting.CollectorHealth `json:"health"`
+}
+
+// AlertList is the response of GET /alerts.
+type AlertList struct {
+	UUID       string               `json:"uuid"`
+	Collecting bool                 `json:"collecting"`
+	Firing     int                  `json:"firing"`
+	Alerts     []alerting.RuleState `json:"alerts"`
+}
+
+// FileEntry is one file in the output directory.
+type FileEntry struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// FileList is the response of GET /files.
+type FileList struct {
+	Files []FileEntry `json:"files"`
+	Count int         `json:"count"`
+}
+
+// FileOptions selects how GET /files/{uuid} sends a file: as written, or
+// converted to Format with only Fields (names or path.Match patterns).
+type FileOptions struct {
+	Format string
+	Fields []string
+}
+
+// Values encodes o as GET /files/{uuid} query parameters.
+func (o FileOptions) Values() url.Values {
+	v := url.Values{}
+	if o.Format != "" {
+		v.Set("format", o.Format)
+	}
+	if len(o.Fields) > 0 {
+		v.Set("fields", strings.Join(o.Fields, ","))
+	}
+	return v
+}
+
+// Converted reports whether o asks for a conversion, which rules out
+// Range requests.
+func (o FileOptions) Converted() bool {
+	return o.Format != "" && o.Format != FormatJSONL
+}
@@ -0,0 +1,99 @@
+package serving
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"InferenceProfiler/pkg/utils"
+)
+
+// Scopes an endpoint needs. Read covers everything that only looks at
+// state or files; control covers starting, stopping and changing things.
+const (
+	ScopeNone    = ""
+	ScopeRead    = "read"
+	ScopeControl = "control"
+)
+
+// auth checks bearer tokens. With no tokens configured every request is
+// allowed, as before tokens existed; a client certificate verified against
+// -tls-client-ca is then the only credential.
+type auth struct {
+	control string // -token, grants read and control
+	read    string // -read-token, grants read
+}
+
+func newAuth(cfg *utils.Config) *auth {
+	return &auth{control: cfg.Token, read: cfg.ReadToken}
+}
+
+func (a *auth) enabled() bool { return a.control != "" || a.read != "" }
+
+// scopeOf returns the scope of the request's bearer token, or "" if it has
+// none or an unknown one.
+func (a *auth) scopeOf(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return ScopeNone
+	}
+	switch {
+	case a.control != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.control)) == 1:
+		return ScopeControl
+	case a.read != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.read)) == 1:
+		return ScopeRead
+	}
+	return ScopeNone
+}
+
+// require wraps h so it only runs for requests whose token grants scope:
+// 401 without a valid token, 403 with a read token on a control endpoint.
+func (a *auth) require(scope string, h http.HandlerFunc) http.HandlerFunc {
+	if scope == ScopeNone || !a.enabled() {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch got := a.scopeOf(r); {
+		case got == ScopeNone:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="infpro"`)
+			http.Error(w, "missing or invalid bearer token", http.StatusUnauthorized)
+		case scope == ScopeControl && got != ScopeControl:
+			http.Error(w, "token does not grant the control scope", http.StatusForbidden)
+		default:
+			h(w, r)
+		}
+	}
+}
+
+// tlsConfig returns the server's TLS settings, or nil to serve plain HTTP.
+// With -tls-client-ca clients must present a certificate signed by it.
+func tlsConfig(cfg *utils.Config) (*tls.Config, error) {
+	if cfg.TLSCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	tc := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if cfg.TLSClientCA != "" {
+		pem, err := os.ReadFile(cfg.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls: no certificates in %s", cfg.TLSClientCA)
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tc, nil
+}
@@ -0,0 +1,269 @@
+package serving
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"InferenceProfiler/pkg/collecting"
+	"InferenceProfiler/pkg/utils"
+)
+
+// newTestServer returns a server over a manager with no collectors but
+// those runs enable, writing to a temporary directory.
+func newTestServer(t *testing.T, cfg *utils.Config) *Server {
+	t.Helper()
+	cfg.OutputDir = t.TempDir()
+	cfg.Interval = 100
+	cfg.Disabled = make(map[string]bool)
+	for _, spec := range collecting.Specs() {
+		cfg.Disabled[spec.Name] = !spec.Default
+	}
+	m := collecting.NewManager(cfg)
+	t.Cleanup(func() { m.Close() })
+	return NewServer(m)
+}
+
+func TestAuthScopes(t *testing.T) {
+	tests := []struct {
+		name          string
+		control, read string // configured -token, -read-token
+		method, path  string
+		bearer        string
+		want          int
+	}{
+		{"health needs nothing", "c", "r", "GET", "/health", "", http.StatusOK},
+		{"read without token", "c", "r", "GET", "/runs", "", http.StatusUnauthorized},
+		{"read with unknown token", "c", "r", "GET", "/runs", "x", http.StatusUnauthorized},
+		{"read with read token", "c", "r", "GET", "/runs", "r", http.StatusOK},
+		{"read with control token", "c", "r", "GET", "/runs", "c", http.StatusOK},
+		{"control without token", "c", "r", "DELETE", "/collect", "", http.StatusUnauthorized},
+		{"control with read token", "c", "r", "DELETE", "/collect", "r", http.StatusForbidden},
+		// Past the check: there is no run to stop.
+		{"control with control token", "c", "r", "DELETE", "/collect", "c", http.StatusConflict},
+		{"read-only server refuses control", "", "r", "DELETE", "/collect", "r", http.StatusForbidden},
+		{"no tokens, read", "", "", "GET", "/runs", "", http.StatusOK},
+		{"no tokens, control", "", "", "DELETE", "/collect", "", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestServer(t, &utils.Config{Token: tt.control, ReadToken: tt.read}).Handler()
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("%s %s = %d, want %d (%s)", tt.method, tt.path, rec.Code, tt.want, rec.Body.String())
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+		})
+	}
+}
+
+// testCA is a throwaway certificate authority.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a leaf certificate and its key, PEM encoded, for a server
+// on 127.0.0.1 or a client.
+func (ca *testCA) issue(t *testing.T, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "infpro test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// startTLS serves srv with tlsConfig(cfg) and returns its URL.
+func startTLS(t *testing.T, srv *Server, cfg *utils.Config) string {
+	t.Helper()
+	tc, err := tlsConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(srv.Handler())
+	ts.TLS = tc
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func TestTLSAndMTLS(t *testing.T) {
+	ca := newTestCA(t, "infpro test CA")
+	srvCert, srvKey := ca.issue(t, x509.ExtKeyUsageServerAuth)
+	cliCert, cliKey := ca.issue(t, x509.ExtKeyUsageClientAuth)
+	rogueCert, rogueKey := newTestCA(t, "rogue CA").issue(t, x509.ExtKeyUsageClientAuth)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+	client := func(certPEM, keyPEM []byte) *http.Client {
+		tc := &tls.Config{RootCAs: roots}
+		if certPEM != nil {
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Offer the certificate even when the server asks for
+			// another CA, so the server's verification is what fails.
+			tc.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return &cert, nil }
+		}
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: tc}, Timeout: 5 * time.Second}
+	}
+
+	cfg := &utils.Config{
+		TLSCert: writeFile(t, "srv.pem", srvCert),
+		TLSKey:  writeFile(t, "srv.key", srvKey),
+	}
+	tlsURL := startTLS(t, newTestServer(t, cfg), cfg)
+
+	mcfg := *cfg
+	mcfg.TLSClientCA = writeFile(t, "ca.pem", ca.pem)
+	mcfg.Token = "c"
+	mtlsURL := startTLS(t, newTestServer(t, &mcfg), &mcfg)
+
+	tests := []struct {
+		name   string
+		url    string
+		client *http.Client
+		bearer string
+		want   int // 0: the handshake fails
+	}{
+		{"TLS without client cert", tlsURL, client(nil, nil), "", http.StatusOK},
+		{"TLS, untrusted server cert", tlsURL, &http.Client{Timeout: 5 * time.Second}, "", 0},
+		{"mTLS without client cert", mtlsURL, client(nil, nil), "c", 0},
+		{"mTLS with cert from another CA", mtlsURL, client(rogueCert, rogueKey), "c", 0},
+		{"mTLS with valid cert, no token", mtlsURL, client(cliCert, cliKey), "", http.StatusUnauthorized},
+		{"mTLS with valid cert and token", mtlsURL, client(cliCert, cliKey), "c", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tt.url+"/runs", nil)
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			resp, err := tt.client.Do(req)
+			if tt.want == 0 {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("request succeeded with %s, want a TLS error", resp.Status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+
+	// Plain HTTP to the TLS port gets nowhere near the API.
+	resp, err := http.Get("http" + tlsURL[len("https"):] + "/runs")
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("plain HTTP to the TLS port = %s, want 400", resp.Status)
+		}
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	ca := newTestCA(t, "infpro test CA")
+	cert, key := ca.issue(t, x509.ExtKeyUsageServerAuth)
+	_, otherKey := ca.issue(t, x509.ExtKeyUsageServerAuth)
+	certFile, keyFile := writeFile(t, "srv.pem", cert), writeFile(t, "srv.key", key)
+
+	tests := []struct {
+		name string
+		cfg  utils.Config
+	}{
+		{"key does not match", utils.Config{TLSCert: certFile, TLSKey: writeFile(t, "other.key", otherKey)}},
+		{"missing client CA", utils.Config{TLSCert: certFile, TLSKey: keyFile, TLSClientCA: filepath.Join(t.TempDir(), "none.pem")}},
+		{"client CA without certificates", utils.Config{TLSCert: certFile, TLSKey: keyFile, TLSClientCA: keyFile}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tlsConfig(&tt.cfg); err == nil {
+				t.Error("tlsConfig succeeded")
+			}
+		})
+	}
+	if tc, err := tlsConfig(&utils.Config{}); tc != nil || err != nil {
+		t.Errorf("tlsConfig without -tls-cert = %v, %v; want plain HTTP", tc, err)
+	}
+}
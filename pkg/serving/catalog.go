@@ -0,0 +1,395 @@
+package serving
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"InferenceProfiler/pkg/collecting"
+	"InferenceProfiler/pkg/utils"
+)
+
+// runInfoSuffix names the file next to a run's output holding its RunInfo.
+const runInfoSuffix = ".run.json"
+
+// catalog indexes every run the server knows of: the ones it started and
+// the ones found in the output directory. A run's RunInfo is saved as
+// {uuid}.run.json beside its output whenever it changes, so the index
+// survives restarts. Output without one (continuous mode, older servers) is
+// indexed by reading the JSONL itself.
+type catalog struct {
+	dir string
+
+	mu   sync.Mutex
+	runs map[string]RunInfo
+}
+
+// loadCatalog rebuilds the index from dir. Runs whose saved state is still
+// running were cut short by the server exiting; they are marked interrupted
+// with their counts taken from the output.
+func loadCatalog(dir string) *catalog {
+	c := &catalog{dir: dir, runs: make(map[string]RunInfo)}
+	if dir == "" {
+		return c
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("catalog: reading output directory", "dir", dir, "error", err)
+		}
+		return c
+	}
+
+	outputs := make(map[string]bool)
+	for _, e := range entries {
+		name := e.Name()
+		switch {
+		case e.IsDir():
+		case strings.HasSuffix(name, runInfoSuffix):
+			info, err := c.load(name)
+			if err != nil {
+				slog.Warn("catalog: skipping run info", "file", name, "error", err)
+				continue
+			}
+			c.runs[info.UUID] = info
+		case strings.HasSuffix(name, ".jsonl") && !strings.Contains(name, ".trigger-"):
+			outputs[strings.TrimSuffix(name, ".jsonl")] = true
+		}
+	}
+
+	for uuid := range outputs {
+		info, ok := c.runs[uuid]
+		if ok && info.State != RunRunning {
+			continue
+		}
+		scanned, err := scanOutput(dir, uuid)
+		if err != nil {
+			slog.Warn("catalog: skipping output", "uuid", uuid, "error", err)
+			continue
+		}
+		if ok {
+			// Keep what was saved at start (config, markers so far) and
+			// take the rest from the output.
+			info.State = RunInterrupted
+			info.Stopped = scanned.Stopped
+			info.Elapsed = scanned.Elapsed
+			info.Records = scanned.Records
+			info.Bytes = scanned.Bytes
+			info.Markers = scanned.Markers
+			info.Error = "server exited during the run"
+			scanned = info
+		}
+		c.runs[uuid] = scanned
+		c.save(scanned)
+	}
+	for uuid, info := range c.runs {
+		if info.State == RunRunning {
+			// Saved as running but no output: it never wrote a line.
+			info.State, info.Error = RunInterrupted, "server exited during the run"
+			c.runs[uuid] = info
+			c.save(info)
+		}
+	}
+	slog.Info("catalog: loaded runs", "dir", dir, "runs", len(c.runs))
+	return c
+}
+
+func (c *catalog) load(name string) (RunInfo, error) {
+	var info RunInfo
+	data, err := os.ReadFile(filepath.Join(c.dir, name))
+	if err != nil {
+		return info, err
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return info, err
+	}
+	if info.UUID+runInfoSuffix != name {
+		return info, fmt.Errorf("uuid %q does not match the file name", info.UUID)
+	}
+	return info, nil
+}
+
+// save writes info beside the run's output, replacing the previous copy
+// atomically.
+func (c *catalog) save(info RunInfo) {
+	if c.dir == "" {
+		return
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		slog.Error("catalog: encoding run info", "uuid", info.UUID, "error", err)
+		return
+	}
+	path := filepath.Join(c.dir, info.UUID+runInfoSuffix)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		slog.Error("catalog: writing run info", "uuid", info.UUID, "error", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		slog.Error("catalog: writing run info", "uuid", info.UUID, "error", err)
+	}
+}
+
+// put records info and saves it.
+func (c *catalog) put(info RunInfo) {
+	c.mu.Lock()
+	c.runs[info.UUID] = info
+	c.mu.Unlock()
+	c.save(info)
+}
+
+func (c *catalog) get(uuid string) (RunInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info, ok := c.runs[uuid]
+	return info, ok
+}
+
+// remove forgets a run and deletes its run info file. Its output stays.
+func (c *catalog) remove(uuid string) bool {
+	c.mu.Lock()
+	_, ok := c.runs[uuid]
+	delete(c.runs, uuid)
+	c.mu.Unlock()
+	if ok && c.dir != "" {
+		if err := os.Remove(filepath.Join(c.dir, uuid+runInfoSuffix)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("catalog: removing run info", "uuid", uuid, "error", err)
+		}
+	}
+	return ok
+}
+
+func (c *catalog) all() map[string]RunInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]RunInfo, len(c.runs))
+	for uuid, info := range c.runs {
+		out[uuid] = info
+	}
+	return out
+}
+
+// scanOutput indexes a run from its JSONL output: the static line gives the
+// start, host and config, Meta sections the collectors, the last record the
+// end. Flattened output has no config. Trigger captures count towards Bytes.
+func scanOutput(dir, uuid string) (RunInfo, error) {
+	info := RunInfo{UUID: uuid, State: RunUnknown, File: uuid + ".jsonl", Markers: []collecting.Marker{}}
+	f, err := os.Open(filepath.Join(dir, info.File))
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+
+	collectors := make(map[string]bool)
+	r := bufio.NewReaderSize(f, 1<<16)
+	var last []byte
+	for n := 0; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			switch {
+			case n == 0:
+				if err := scanStatic(line, &info, collectors); err != nil {
+					return info, fmt.Errorf("static line: %w", err)
+				}
+			default:
+				info.Records++
+				last = line
+				// Collectors show up in Meta; skip mode may leave slow ones
+				// out of the first records, so look at a few.
+				if info.Records <= 10 || bytes.Contains(line, []byte(`"Markers`)) {
+					scanRecord(line, &info, collectors)
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return info, err
+		}
+	}
+	if info.Started.IsZero() {
+		return info, fmt.Errorf("no static line")
+	}
+
+	if last != nil {
+		if flat, err := decodeFlat(last); err == nil {
+			if ns, ok := nsField(flat, "timestamp"); ok {
+				t := time.Unix(0, ns)
+				info.Stopped = &t
+			}
+		}
+	}
+	if info.Stopped != nil {
+		info.Elapsed = info.Stopped.Sub(info.Started).Round(time.Millisecond).String()
+	}
+	info.Collectors = slices.Sorted(maps.Keys(collectors))
+
+	matches, _ := filepath.Glob(filepath.Join(dir, uuid+".*jsonl"))
+	for _, path := range matches {
+		if st, err := os.Stat(path); err == nil {
+			info.Bytes += st.Size()
+		}
+	}
+	return info, nil
+}
+
+func scanStatic(line []byte, info *RunInfo, collectors map[string]bool) error {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	var static map[string]any
+	if err := dec.Decode(&static); err != nil {
+		return err
+	}
+	if cfg, ok := static["config"]; ok {
+		if data, err := json.Marshal(cfg); err == nil {
+			json.Unmarshal(data, &info.Config)
+		}
+	}
+	for name, v := range static {
+		if _, ok := v.(map[string]any); ok && name != "config" {
+			collectors[name] = true
+		} else if _, ok := v.([]any); ok {
+			collectors[name] = true
+		}
+	}
+	flat := utils.Flatten(static)
+	if ns, ok := nsField(flat, "timestamp"); ok {
+		info.Started = time.Unix(0, ns)
+	}
+	if host, ok := flat["VmCpuHostName"].(string); ok {
+		info.Host = host
+	}
+	return nil
+}
+
+func scanRecord(line []byte, info *RunInfo, collectors map[string]bool) {
+	flat, err := decodeFlat(line)
+	if err != nil {
+		return
+	}
+	for key := range flat {
+		if name, ok := strings.CutPrefix(key, "Meta"); ok {
+			if name, ok := strings.CutSuffix(name, "Seq"); ok && name != "" {
+				collectors[name] = true
+			}
+		}
+	}
+	for i := 0; ; i++ {
+		label, ok := flat[fmt.Sprintf("Markers%dLabel", i)].(string)
+		if !ok {
+			break
+		}
+		m := collecting.Marker{Label: label}
+		if at, ok := nsField(flat, fmt.Sprintf("Markers%dAt", i)); ok {
+			m.At = at
+		}
+		if seq, ok := nsField(flat, fmt.Sprintf("Markers%dSeq", i)); ok {
+			m.Seq = seq
+		}
+		info.Markers = append(info.Markers, m)
+	}
+}
+
+func decodeFlat(line []byte) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	var m map[string]any
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	return utils.Flatten(m), nil
+}
+
+// nsField reads an integer field decoded with UseNumber, keeping the
+// nanosecond precision float64 would lose.
+func nsField(flat map[string]any, key string) (int64, bool) {
+	n, ok := flat[key].(json.Number)
+	if !ok {
+		return 0, false
+	}
+	v, err := n.Int64()
+	return v, err == nil
+}
+
+// runFilter selects runs for GET /runs.
+type runFilter struct {
+	state     string
+	uuid      string // prefix
+	host      string
+	collector string
+	marker    string
+	since     time.Time
+	until     time.Time
+}
+
+func (f runFilter) match(info RunInfo) bool {
+	switch {
+	case f.state != "" && info.State != f.state,
+		f.uuid != "" && !strings.HasPrefix(info.UUID, f.uuid),
+		f.host != "" && info.Host != f.host,
+		f.collector != "" && !slices.ContainsFunc(info.Collectors, func(c string) bool { return strings.EqualFold(c, f.collector) }),
+		f.marker != "" && !slices.ContainsFunc(info.Markers, func(m collecting.Marker) bool { return m.Label == f.marker }),
+		!f.since.IsZero() && info.Started.Before(f.since),
+		!f.until.IsZero() && !info.Started.Before(f.until):
+		return false
+	}
+	return true
+}
+
+// parseRunQuery reads the GET /runs filter and page from q. since and until
+// bound the start time and take RFC 3339 times or durations back from now
+// ("24h").
+func parseRunQuery(q url.Values) (runFilter, int, int, error) {
+	f := runFilter{
+		state:     q.Get("state"),
+		uuid:      q.Get("uuid"),
+		host:      q.Get("host"),
+		collector: q.Get("collector"),
+		marker:    q.Get("marker"),
+	}
+	var err error
+	if f.since, err = parseSince(q.Get("since")); err != nil {
+		return f, 0, 0, fmt.Errorf("since: %w", err)
+	}
+	if f.until, err = parseSince(q.Get("until")); err != nil {
+		return f, 0, 0, fmt.Errorf("until: %w", err)
+	}
+
+	offset, limit := 0, 100
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return f, 0, 0, fmt.Errorf("offset: want a non-negative number")
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > 1000 {
+			return f, 0, 0, fmt.Errorf("limit: want 1 to 1000")
+		}
+	}
+	return f, offset, limit, nil
+}
+
+func parseSince(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
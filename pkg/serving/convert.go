@@ -0,0 +1,373 @@
+package serving
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Formats GET /files/{uuid} can convert a run's JSONL output to. Converted
+// records are flattened as -flatten does, so field names match the keys of
+// a flattened run and the alert and trigger conditions.
+const (
+	FormatJSONL     = "jsonl"      // the file as written
+	FormatFlatJSONL = "flat-jsonl" // static line and records, flattened
+	FormatCSV       = "csv"        // one row per record
+	FormatParquet   = "parquet"    // one row per record
+)
+
+// parquetRowGroup bounds how many rows a parquet download buffers before
+// writing them out.
+const parquetRowGroup = 10000
+
+// fieldSelector picks the flattened fields a conversion keeps. Entries are
+// field names or path.Match patterns ("Nvidia0*"); none keeps every field.
+type fieldSelector []string
+
+func parseFields(s string) (fieldSelector, error) {
+	var f fieldSelector
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if _, err := path.Match(field, ""); err != nil {
+			return nil, fmt.Errorf("fields: %q: %w", field, err)
+		}
+		f = append(f, field)
+	}
+	return f, nil
+}
+
+func isPattern(field string) bool { return strings.ContainsAny(field, `*?[\`) }
+
+func (f fieldSelector) match(key string) bool {
+	if len(f) == 0 {
+		return true
+	}
+	for _, field := range f {
+		if ok, _ := path.Match(field, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// columns orders the columns of a tabular conversion out of the fields
+// seen: the selected fields in the order given, each pattern's matches
+// sorted, named fields kept even if no record has them. Without a selection
+// uuid and timestamp lead and the rest follow sorted.
+func (f fieldSelector) columns(seen map[string]colKind) []string {
+	if len(f) == 0 {
+		cols := slices.Sorted(maps.Keys(seen))
+		for _, lead := range []string{"timestamp", "uuid"} {
+			if i := slices.Index(cols, lead); i > 0 {
+				cols = slices.Insert(slices.Delete(cols, i, i+1), 0, lead)
+			}
+		}
+		return cols
+	}
+	var cols []string
+	added := make(map[string]bool)
+	add := func(col string) {
+		if !added[col] {
+			added[col] = true
+			cols = append(cols, col)
+		}
+	}
+	for _, field := range f {
+		if !isPattern(field) {
+			add(field)
+			continue
+		}
+		for _, col := range slices.Sorted(maps.Keys(seen)) {
+			if ok, _ := path.Match(field, col); ok {
+				add(col)
+			}
+		}
+	}
+	return cols
+}
+
+// colKind records the JSON types a column holds, to pick its parquet type.
+type colKind uint8
+
+const (
+	kindInt colKind = 1 << iota
+	kindFloat
+	kindString
+	kindBool
+)
+
+func kindOf(v any) colKind {
+	switch v := v.(type) {
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return kindInt
+		}
+		return kindFloat
+	case string:
+		return kindString
+	case bool:
+		return kindBool
+	}
+	return 0
+}
+
+// flatRecord decodes a JSONL line and flattens it as -flatten would have
+// written it. Metrics decode as objects, which flatten to XV and XT rather
+// than X and XT.
+func flatRecord(line []byte) (map[string]any, error) {
+	flat, err := decodeFlat(line)
+	if err != nil {
+		return nil, err
+	}
+	var metrics []string
+	for key := range flat {
+		if name, ok := strings.CutSuffix(key, "V"); ok {
+			if _, ok := flat[name+"T"]; ok {
+				metrics = append(metrics, name)
+			}
+		}
+	}
+	for _, name := range metrics {
+		flat[name] = flat[name+"V"]
+		delete(flat, name+"V")
+	}
+	return flat, nil
+}
+
+// eachLine calls fn with each complete line in the first size bytes of f,
+// numbered from 0. A run still being written may end in a partial line,
+// which is left out.
+func eachLine(f *os.File, size int64, fn func(n int, line []byte) error) error {
+	r := bufio.NewReaderSize(io.NewSectionReader(f, 0, size), 1<<16)
+	for n := 0; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if err := fn(n, line); err != nil {
+			return err
+		}
+	}
+}
+
+// serveConverted streams the JSONL output at file converted to format,
+// keeping only the fields sel selects. CSV and parquet need their columns
+// up front, so they read the file twice: once for the columns, once for
+// the rows. Records are one row each, with the run's uuid added since the
+// static line is not a row. The file is read up to its size at the start,
+// so a running run converts as of the request.
+func (s *Server) serveConverted(w http.ResponseWriter, file, format string, sel fieldSelector) {
+	f, err := os.Open(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	size := st.Size()
+
+	var (
+		uuid string
+		seen = make(map[string]colKind)
+		bad  int
+	)
+	if format != FormatFlatJSONL {
+		err := eachLine(f, size, func(n int, line []byte) error {
+			rec, err := flatRecord(line)
+			if err != nil {
+				bad++
+				return nil
+			}
+			if n == 0 {
+				uuid, _ = rec["uuid"].(string)
+				seen["uuid"] = kindString
+				return nil
+			}
+			for key, v := range rec {
+				seen[key] |= kindOf(v)
+			}
+			return nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	name := strings.TrimSuffix(filepath.Base(file), ".jsonl")
+	rows := 0
+	switch format {
+	case FormatFlatJSONL:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.flat.jsonl"`, name))
+		buf := bufio.NewWriter(w)
+		enc := json.NewEncoder(buf)
+		err = eachLine(f, size, func(n int, line []byte) error {
+			rec, err := flatRecord(line)
+			if err != nil {
+				bad++
+				return nil
+			}
+			if len(sel) > 0 {
+				maps.DeleteFunc(rec, func(key string, _ any) bool { return !sel.match(key) })
+				if len(rec) == 0 {
+					return nil
+				}
+			}
+			rows++
+			return enc.Encode(rec)
+		})
+		if err == nil {
+			err = buf.Flush()
+		}
+	case FormatCSV:
+		cols := sel.columns(seen)
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, name))
+		cw := csv.NewWriter(w)
+		cw.Write(cols)
+		fields := make([]string, len(cols))
+		err = eachLine(f, size, func(n int, line []byte) error {
+			rec, err := flatRecord(line)
+			if n == 0 || err != nil {
+				return nil
+			}
+			rec["uuid"] = uuid
+			for i, col := range cols {
+				fields[i] = csvText(rec[col])
+			}
+			rows++
+			return cw.Write(fields)
+		})
+		cw.Flush()
+		if err == nil {
+			err = cw.Error()
+		}
+	case FormatParquet:
+		w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.parquet"`, name))
+		rows, err = writeParquet(w, f, size, uuid, sel.columns(seen), seen)
+	}
+	if bad > 0 {
+		slog.Warn("server: skipped undecodable lines", "file", filepath.Base(file), "lines", bad)
+	}
+	if err != nil {
+		slog.Warn("server: converting file", "file", filepath.Base(file), "format", format, "error", err)
+		return
+	}
+	slog.Info("server: converted file", "file", filepath.Base(file), "format", format, "rows", rows)
+}
+
+func csvText(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// writeParquet writes the records in the first size bytes of f as parquet
+// rows of cols. Every column is optional; its type follows what the records
+// hold: INT64 or DOUBLE for numbers, BOOLEAN, or STRING for text and mixed
+// columns.
+func writeParquet(w io.Writer, f *os.File, size int64, uuid string, cols []string, seen map[string]colKind) (int, error) {
+	group := make(parquet.Group, len(cols))
+	for _, col := range cols {
+		var node parquet.Node
+		switch seen[col] {
+		case kindBool:
+			node = parquet.Leaf(parquet.BooleanType)
+		case kindInt:
+			node = parquet.Int(64)
+		case kindFloat, kindInt | kindFloat:
+			node = parquet.Leaf(parquet.DoubleType)
+		default:
+			node = parquet.String()
+		}
+		group[col] = parquet.Optional(node)
+	}
+	schema := parquet.NewSchema("infpro", group)
+	order := schema.Columns()
+
+	pw := parquet.NewWriter(w, schema,
+		parquet.Compression(&parquet.Zstd),
+		parquet.MaxRowsPerRowGroup(parquetRowGroup))
+	rows := 0
+	row := make([]parquet.Row, 1)
+	err := eachLine(f, size, func(n int, line []byte) error {
+		rec, err := flatRecord(line)
+		if n == 0 || err != nil {
+			return nil
+		}
+		rec["uuid"] = uuid
+		row[0] = row[0][:0]
+		for i, col := range order {
+			v, ok := parquetValue(rec[col[0]], seen[col[0]])
+			if !ok {
+				row[0] = append(row[0], parquet.NullValue().Level(0, 0, i))
+				continue
+			}
+			row[0] = append(row[0], v.Level(0, 1, i))
+		}
+		rows++
+		_, err = pw.WriteRows(row)
+		return err
+	})
+	if err != nil {
+		return rows, err
+	}
+	return rows, pw.Close()
+}
+
+// parquetValue converts v to the type writeParquet chose for a column of
+// kind, or reports false for a null.
+func parquetValue(v any, kind colKind) (parquet.Value, bool) {
+	if v == nil {
+		return parquet.Value{}, false
+	}
+	switch kind {
+	case kindBool:
+		return parquet.ValueOf(v), true
+	case kindInt:
+		n, err := v.(json.Number).Int64()
+		return parquet.ValueOf(n), err == nil
+	case kindFloat, kindInt | kindFloat:
+		n, err := v.(json.Number).Float64()
+		return parquet.ValueOf(n), err == nil
+	}
+	return parquet.ValueOf(csvText(v)), true
+}
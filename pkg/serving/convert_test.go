@@ -0,0 +1,233 @@
+package serving
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+
+	"InferenceProfiler/pkg/utils"
+)
+
+// convertFixture is the output of run "run": the static line, three
+// records and a partial line still being written. Vm.Cpu turns from int to
+// float, Vllm.Model from string to int, and Exec.A.NV has no NT partner.
+const convertFixture = `{"uuid":"run","Vm":{"Cores":8},"timestamp":1}
+{"timestamp":10,"Vm":{"Cpu":{"V":12,"T":100}},"Nvidia":[{"Util":{"V":50,"T":101}}],"Vllm":{"Model":"a"},"Exec":{"A":{"NV":1,"T":5}},"Up":true}
+{"timestamp":20,"Vm":{"Cpu":{"V":12.5,"T":200}},"Nvidia":[{"Util":{"V":51,"T":201}}],"Vllm":{"Model":7}}
+
+{"timestamp":30,"Vm":{"Cpu":{"V":13,"T":300}},"Up":false}
+{"timestamp":40,"Vm":{"Cpu":`
+
+// getConverted serves the fixture as run.jsonl, next to run-2.jsonl, which
+// sorts first, and returns the response to GET /files/{name}?query.
+func getConverted(t *testing.T, name, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	srv := newTestServer(t, &utils.Config{})
+	dir := srv.outputDir()
+	for file, data := range map[string]string{
+		"run.jsonl":   convertFixture,
+		"run-2.jsonl": `{"uuid":"run-2"}` + "\n" + `{"timestamp":99,"Other":1}` + "\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/files/"+name+"?"+query, nil))
+	return rec
+}
+
+func TestConvertFlatJSONL(t *testing.T) {
+	tests := []struct {
+		name, file, fields string
+		want               []string
+	}{
+		{"every field", "run", "", []string{
+			`{"VmCores":1,"timestamp":1,"uuid":"run"}`,
+			`{"ExecANV":1,"ExecAT":5,"Nvidia0Util":50,"Nvidia0UtilT":101,"Up":true,"VllmModel":"a","VmCpu":12,"VmCpuT":100,"timestamp":10}`,
+			`{"Nvidia0Util":51,"Nvidia0UtilT":201,"VllmModel":7,"VmCpu":12.5,"VmCpuT":200,"timestamp":20}`,
+			`{"Up":false,"VmCpu":13,"VmCpuT":300,"timestamp":30}`,
+		}},
+		// Records left with no selected field are dropped.
+		{"named fields and a glob", "run.jsonl", "timestamp,Nvidia*", []string{
+			`{"timestamp":1}`,
+			`{"Nvidia0Util":50,"Nvidia0UtilT":101,"timestamp":10}`,
+			`{"Nvidia0Util":51,"Nvidia0UtilT":201,"timestamp":20}`,
+			`{"timestamp":30}`,
+		}},
+		{"only some records have it", "run", "Up", []string{`{"Up":true}`, `{"Up":false}`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := getConverted(t, tt.file, "format=flat-jsonl&fields="+tt.fields)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			// VmCores is 8 in the file: compare the keys' order-free JSON.
+			got := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+			if len(got) != len(tt.want) {
+				t.Fatalf("%d lines, want %d:\n%s", len(got), len(tt.want), rec.Body.String())
+			}
+			for i := range got {
+				if !sameKeysAndValues(t, got[i], tt.want[i]) {
+					t.Errorf("line %d = %s, want %s", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// sameKeysAndValues compares two JSON objects, except that a want value of 1
+// for VmCores matches whatever the static line holds.
+func sameKeysAndValues(t *testing.T, got, want string) bool {
+	t.Helper()
+	var g, w map[string]any
+	if err := json.Unmarshal([]byte(got), &g); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := w["VmCores"]; ok {
+		w["VmCores"] = g["VmCores"]
+	}
+	return reflect.DeepEqual(g, w)
+}
+
+func TestConvertCSV(t *testing.T) {
+	tests := []struct {
+		name, fields, want string
+	}{
+		{"every field", "", "" +
+			"uuid,timestamp,ExecANV,ExecAT,Nvidia0Util,Nvidia0UtilT,Up,VllmModel,VmCpu,VmCpuT\n" +
+			"run,10,1,5,50,101,true,a,12,100\n" +
+			"run,20,,,51,201,,7,12.5,200\n" +
+			"run,30,,,,,false,,13,300\n"},
+		// Columns follow the list; a named field no record has stays.
+		{"order, glob and a missing field", "VmCpu,Nvidia*,Missing,timestamp", "" +
+			"VmCpu,Nvidia0Util,Nvidia0UtilT,Missing,timestamp\n" +
+			"12,50,101,,10\n" +
+			"12.5,51,201,,20\n" +
+			"13,,,,30\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := getConverted(t, "run", "format=csv&fields="+tt.fields)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := rec.Body.String(); got != tt.want {
+				t.Errorf("CSV\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertParquet(t *testing.T) {
+	rec := getConverted(t, "run", "format=parquet&fields=uuid,timestamp,VmCpu,VllmModel,Up,Nvidia0Util")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	body := rec.Body.Bytes()
+	f, err := parquet.OpenFile(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Columns are typed by what they hold; mixed ones become strings.
+	wantTypes := map[string]string{
+		"uuid": "BYTE_ARRAY", "timestamp": "INT64", "VmCpu": "DOUBLE",
+		"VllmModel": "BYTE_ARRAY", "Up": "BOOLEAN", "Nvidia0Util": "INT64",
+	}
+	for _, field := range f.Schema().Fields() {
+		if got := field.Type().Kind().String(); got != wantTypes[field.Name()] || !field.Optional() {
+			t.Errorf("column %s: %s (optional %v), want optional %s", field.Name(), got, field.Optional(), wantTypes[field.Name()])
+		}
+	}
+
+	// Rows read back by name; nil is a null.
+	type row struct {
+		UUID        *string  `parquet:"uuid,optional"`
+		Timestamp   *int64   `parquet:"timestamp,optional"`
+		VmCpu       *float64 `parquet:"VmCpu,optional"`
+		VllmModel   *string  `parquet:"VllmModel,optional"`
+		Up          *bool    `parquet:"Up,optional"`
+		Nvidia0Util *int64   `parquet:"Nvidia0Util,optional"`
+	}
+	rows, err := parquet.Read[row](bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range rows {
+		got = append(got, fmt.Sprintf("%v %v %v %v %v %v", deref(r.UUID), deref(r.Timestamp), deref(r.VmCpu), deref(r.VllmModel), deref(r.Up), deref(r.Nvidia0Util)))
+	}
+	want := []string{
+		"run 10 12 a true 50",
+		"run 20 12.5 7 <nil> 51",
+		"run 30 13 <nil> false <nil>",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("rows\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// deref returns *p, or nil for a nil p.
+func deref[T any](p *T) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+func TestFlatRecordPairsMetrics(t *testing.T) {
+	// XV and XT from a metric become X and XT; a V key without its T
+	// stays, and so does a T without its V.
+	got, err := flatRecord([]byte(`{"Gpu":{"Util":{"V":5,"T":9}},"NV":3,"Clock":{"T":4},"Vllm":{"Model":"m"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"GpuUtil": json.Number("5"), "GpuUtilT": json.Number("9"), "NV": json.Number("3"),
+		"ClockT": json.Number("4"), "VllmModel": "m"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("flatRecord = %v, want %v", got, want)
+	}
+	if _, err := flatRecord([]byte(`{"timestamp":`)); err == nil {
+		t.Error("flatRecord of a partial line succeeded")
+	}
+}
+
+func TestGetFileMatchesExactly(t *testing.T) {
+	tests := []struct {
+		name, query string
+		want        string // a line of the response body
+	}{
+		{"run", "format=csv", "run,10,"},
+		{"run.jsonl", "format=flat-jsonl", `"uuid":"run"`},
+		{"run-2", "format=csv", "run-2,99"},
+		{"run", "", `{"uuid":"run",`},
+		// Unconverted downloads keep the prefix match.
+		{"run-", "", `{"uuid":"run-2"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"?"+tt.query, func(t *testing.T) {
+			rec := getConverted(t, tt.name, tt.query)
+			if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("status %d, body\n%s\nwant %s", rec.Code, rec.Body.String(), tt.want)
+			}
+		})
+	}
+	if rec := getConverted(t, "run-", "format=csv"); rec.Code != http.StatusNotFound {
+		t.Errorf("converting a prefix: status %d, want 404", rec.Code)
+	}
+}
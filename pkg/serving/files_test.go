@@ -0,0 +1,46 @@
+package serving
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"InferenceProfiler/pkg/utils"
+)
+
+func TestFilesRejectBadUUID(t *testing.T) {
+	srv := newTestServer(t, &utils.Config{})
+	h := srv.Handler()
+	dir := srv.base.OutputDir
+	// Files next to the output directory and of another run in it.
+	victim := filepath.Join(filepath.Dir(dir), "victim.jsonl")
+	other := filepath.Join(dir, "other.trigger-x-1.jsonl")
+	for _, path := range []string{victim, other} {
+		if err := os.WriteFile(path, []byte("{}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, target := range []string{
+		"DELETE /files/..%2Fvictim",
+		"DELETE /files/..%5Cvictim",
+		"DELETE /files/*",
+		"GET /files/..%2Fvictim",
+		"GET /files?uuid=..%2Fvictim",
+	} {
+		method, path, _ := strings.Cut(target, " ")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s = %d, want 400 (%s)", target, rec.Code, rec.Body.String())
+		}
+	}
+	for _, path := range []string{victim, other} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+}
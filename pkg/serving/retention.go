@@ -0,0 +1,156 @@
+package serving
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"InferenceProfiler/pkg/utils"
+)
+
+// retentionEvery is how often the retention policy is applied besides
+// startup and the end of every run.
+const retentionEvery = time.Minute
+
+// runFiles returns the files in dir belonging to run uuid: its output,
+// trigger captures and run info.
+func runFiles(dir, uuid string) []string {
+	var files []string
+	for _, name := range []string{uuid + ".jsonl", uuid + runInfoSuffix} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			files = append(files, name)
+		}
+	}
+	captures, _ := filepath.Glob(filepath.Join(dir, uuid+".trigger-*.jsonl"))
+	for _, path := range captures {
+		files = append(files, filepath.Base(path))
+	}
+	return files
+}
+
+// deleteRun removes a finished run's files, its catalogue entry and the
+// run itself, and returns the files removed.
+func (s *Server) deleteRun(uuid string) ([]string, error) {
+	dir := s.outputDir()
+	files := runFiles(dir, uuid)
+	var errs []error
+	removed := make([]string, 0, len(files))
+	for _, name := range files {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed = append(removed, name)
+	}
+	s.catalog.remove(uuid)
+	s.forget(uuid)
+	return removed, errors.Join(errs...)
+}
+
+// applyRetention deletes finished runs beyond -retain-runs, older than
+// -retain-age, or, oldest first, until the rest fit in -retain-bytes.
+// Running runs are never deleted but count towards the limits.
+func (s *Server) applyRetention() {
+	cfg := s.base
+	if s.outputDir() == "" || (cfg.RetainAge == 0 && cfg.RetainBytes == 0 && cfg.RetainRuns == 0) {
+		return
+	}
+
+	all := s.catalog.all()
+	s.mu.Lock()
+	for uuid, run := range s.runs {
+		if run.running() {
+			all[uuid] = run.info()
+		}
+	}
+	s.mu.Unlock()
+
+	runs := make([]RunInfo, 0, len(all))
+	for _, info := range all {
+		runs = append(runs, info)
+	}
+	slices.SortFunc(runs, func(a, b RunInfo) int { return b.Started.Compare(a.Started) })
+
+	now := time.Now()
+	var total int64
+	for i, info := range runs {
+		total += info.Bytes
+		ended := info.Started
+		if info.Stopped != nil {
+			ended = *info.Stopped
+		}
+		var why string
+		switch {
+		case info.State == RunRunning:
+			continue
+		case cfg.RetainRuns > 0 && i >= cfg.RetainRuns:
+			why = "retain-runs"
+		case cfg.RetainAge > 0 && now.Sub(ended) > cfg.RetainAge:
+			why = "retain-age"
+		case cfg.RetainBytes > 0 && total > cfg.RetainBytes:
+			why = "retain-bytes"
+		default:
+			continue
+		}
+		removed, err := s.deleteRun(info.UUID)
+		total -= info.Bytes
+		slog.Info("retention: deleted run", "uuid", info.UUID, "policy", why, "files", len(removed), "bytes", info.Bytes)
+		if err != nil {
+			slog.Warn("retention: deleting run", "uuid", info.UUID, "error", err)
+		}
+	}
+}
+
+// retentionLoop applies the retention policy until done is closed.
+func (s *Server) retentionLoop(done <-chan struct{}) {
+	s.applyRetention()
+	ticker := time.NewTicker(retentionEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.applyRetention()
+		}
+	}
+}
+
+// handleDeleteFile deletes every file of run {uuid}, which must match
+// exactly, and drops it from the catalogue. Running runs get 409.
+func (s *Server) handleDeleteFile(w http.ResponseWriter, r *http.Request) {
+	dir, ok := s.requireOutputDir(w)
+	if !ok {
+		return
+	}
+	uuid := r.PathValue("uuid")
+	if err := utils.CheckUUID(uuid); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	run, live := s.runs[uuid]
+	running := live && run.running()
+	s.mu.Unlock()
+	if running {
+		http.Error(w, "run is still running; stop it first", http.StatusConflict)
+		return
+	}
+	if _, known := s.catalog.get(uuid); !known && len(runFiles(dir, uuid)) == 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	removed, err := s.deleteRun(uuid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("server: deleted run files", "uuid", uuid, "files", removed)
+	writeJSON(w, http.StatusOK, StateChange{State: "deleted", UUID: uuid, Files: removed})
+}
@@ -0,0 +1,55 @@
+package serving
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetentionForgetsDeletedRuns(t *testing.T) {
+	srv := newTestServer(t, runConfig())
+	h := srv.Handler()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+	for _, uuid := range []string{"old", "new"} {
+		if rec := do("POST", "/runs", `{"uuid":"`+uuid+`"}`); rec.Code != http.StatusCreated {
+			t.Fatalf("start %s: %d %s", uuid, rec.Code, rec.Body.String())
+		}
+		time.Sleep(50 * time.Millisecond)
+		if rec := do("POST", "/runs/"+uuid+"/stop", ""); rec.Code != http.StatusOK {
+			t.Fatalf("stop %s: %d %s", uuid, rec.Code, rec.Body.String())
+		}
+	}
+
+	srv.base.RetainRuns = 1
+	srv.applyRetention()
+
+	rec := do("GET", "/runs", "")
+	var list RunList
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	var uuids []string
+	for _, run := range list.Runs {
+		uuids = append(uuids, run.UUID)
+	}
+	if !slices.Equal(uuids, []string{"new"}) {
+		t.Errorf("runs after retention: %q, want only new", uuids)
+	}
+	if rec := do("GET", "/runs/old", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET /runs/old = %d, want 404", rec.Code)
+	}
+	if _, err := os.Stat(filepath.Join(srv.outputDir(), "old.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("old.jsonl: %v, want deleted", err)
+	}
+}
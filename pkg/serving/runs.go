@@ -0,0 +1,585 @@
+package serving
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"InferenceProfiler/pkg/alerting"
+	"InferenceProfiler/pkg/collecting"
+	"InferenceProfiler/pkg/collecting/base"
+	"InferenceProfiler/pkg/triggering"
+	"InferenceProfiler/pkg/utils"
+)
+
+// Run states. Interrupted runs were still going when the server exited;
+// unknown ones were found in the output directory without run info, e.g.
+// from continuous mode.
+const (
+	RunRunning     = "running"
+	RunStopped     = "stopped"
+	RunFailed      = "failed"
+	RunInterrupted = "interrupted"
+	RunUnknown     = "unknown"
+)
+
+var (
+	// errBadSpec marks run spec validation failures, reported as 400.
+	errBadSpec  = errors.New("invalid run spec")
+	errNotFound = errors.New("no such run")
+	errStopped  = errors.New("run not running")
+)
+
+// RunInfo describes one run in the /runs responses and the run catalogue.
+type RunInfo struct {
+	UUID       string               `json:"uuid"`
+	State      string               `json:"state"`
+	Started    time.Time            `json:"started"`
+	Stopped    *time.Time           `json:"stopped,omitempty"`
+	Elapsed    string               `json:"elapsed"`
+	Host       string               `json:"host,omitempty"`
+	Collectors []string             `json:"collectors"`
+	Records    int64                `json:"records"`
+	Bytes      int64                `json:"bytes"`
+	File       string               `json:"file,omitempty"`
+	Reason     string               `json:"stop_reason,omitempty"`
+	Detail     string               `json:"stop_detail,omitempty"`
+	Error      string               `json:"error,omitempty"`
+	Markers    []collecting.Marker  `json:"markers"`
+	Config     utils.RunSpec        `json:"config"`
+	Alerts     []alerting.RuleState `json:"alerts,omitempty"`
+}
+
+type runState struct {
+	uuid    string
+	cfg     *utils.Config
+	manager *collecting.Manager
+	host    string
+	started time.Time
+	run     *collecting.Run
+	cancel  context.CancelFunc
+	done    chan struct{}
+	alerts  *alerting.Engine
+	records *countingWriter
+
+	// Set once the run ends, under Server.mu.
+	stopped time.Time
+	reason  string
+	detail  string
+	err     string
+}
+
+func (r *runState) running() bool { return r.stopped.IsZero() }
+
+func (r *runState) info() RunInfo {
+	info := RunInfo{
+		UUID:       r.uuid,
+		State:      RunRunning,
+		Started:    r.started,
+		Elapsed:    time.Since(r.started).Round(time.Millisecond).String(),
+		Host:       r.host,
+		Collectors: r.manager.Collectors(r.run),
+		Records:    r.records.records.Load(),
+		Bytes:      r.records.Size(),
+		Reason:     r.reason,
+		Detail:     r.detail,
+		Error:      r.err,
+		Markers:    r.run.Markers(),
+		Config:     r.cfg.Spec(),
+	}
+	if r.cfg.OutputDir != "" {
+		info.File = r.uuid + ".jsonl"
+	}
+	if !r.running() {
+		info.State = RunStopped
+		if r.err != "" {
+			info.State = RunFailed
+		}
+		info.Stopped = &r.stopped
+		info.Elapsed = r.stopped.Sub(r.started).Round(time.Millisecond).String()
+	}
+	if r.alerts != nil {
+		info.Alerts = r.alerts.States()
+	}
+	return info
+}
+
+// countingWriter counts the dynamic records written through it.
+type countingWriter struct {
+	base.Writer
+	pending bool
+	records atomic.Int64
+}
+
+func (w *countingWriter) Dynamic(name string, data any) error {
+	w.pending = true
+	return w.Writer.Dynamic(name, data)
+}
+
+func (w *countingWriter) Size() int64 { return base.SizeOf(w.Writer) }
+
+func (w *countingWriter) Flush() error {
+	if w.pending {
+		w.pending = false
+		w.records.Add(1)
+	}
+	return w.Writer.Flush()
+}
+
+func resolveUUID(requestUUID string) string {
+	if requestUUID != "" {
+		return requestUUID
+	}
+	return utils.GenerateUUID()
+}
+
+// startRun applies spec over the server's startup config and starts a run
+// sharing the manager's pollers with any others. With exclusive set it
+// refuses to start while another run is active, as PUT /collect always has.
+// The uuid is reserved while the manager initialises the collectors, which
+// may take a while, so s.mu is not held meanwhile.
+func (s *Server) startRun(spec utils.RunSpec, exclusive bool) (*runState, error) {
+	s.mu.Lock()
+	if exclusive {
+		if r := s.latestRunning(); r != nil {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("already collecting uuid=%s", r.uuid)
+		}
+		for uuid := range s.starting {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("already starting uuid=%s", uuid)
+		}
+	}
+
+	spec.UUID = resolveUUID(spec.UUID)
+	// A uuid is taken once it has output; runs refused for lack of disk
+	// space may be retried under theirs.
+	if info, ok := s.catalog.get(spec.UUID); ok && info.File != "" || s.runs[spec.UUID] != nil || s.starting[spec.UUID] {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("run %s already exists", spec.UUID)
+	}
+	cfg, err := s.base.Apply(spec, collecting.Specs())
+	if err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: %v", errBadSpec, err)
+	}
+	s.starting[cfg.UUID] = true
+	s.mu.Unlock()
+
+	mr, err := s.manager.Acquire(cfg)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.starting, cfg.UUID)
+	if errors.Is(err, utils.ErrLowDisk) {
+		// Keep the refusal in the catalogue so whoever asked for the run
+		// can find out why there is no data.
+		now := time.Now()
+		s.catalog.put(RunInfo{
+			UUID: cfg.UUID, State: RunFailed, Started: now, Stopped: &now, Elapsed: "0s",
+			Host: s.host, Collectors: []string{}, Markers: []collecting.Marker{},
+			Reason: collecting.StopDiskFull, Detail: err.Error(), Error: "refused to start: " + err.Error(),
+			Config: cfg.Spec(),
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	alerts := alerting.New(cfg)
+	counter := &countingWriter{Writer: triggering.Wrap(utils.NewWriter(cfg), cfg)}
+	w := alerting.Wrap(counter, alerts)
+
+	r := &runState{
+		uuid:    cfg.UUID,
+		cfg:     cfg,
+		manager: s.manager,
+		host:    s.host,
+		run:     mr,
+		started: time.Now(),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		alerts:  alerts,
+		records: counter,
+	}
+	s.runs[r.uuid] = r
+	s.order = append(s.order, r.uuid)
+	s.catalog.put(r.info())
+
+	go func() {
+		defer close(r.done)
+		_, err := s.manager.Record(ctx, w, mr)
+		if err != nil {
+			slog.Error("server: run stopped with error", "uuid", r.uuid, "error", err)
+		}
+		if err := w.Close(); err != nil {
+			slog.Error("server: closing run output", "uuid", r.uuid, "error", err)
+		}
+
+		s.mu.Lock()
+		r.stopped = time.Now()
+		r.reason, r.detail = mr.StopReason(), mr.StopDetail()
+		if err != nil {
+			r.err = err.Error()
+		}
+		info := r.info()
+		s.mu.Unlock()
+		s.catalog.put(info)
+		s.applyRetention()
+	}()
+
+	slog.Info("server: started run", "uuid", r.uuid, "interval", cfg.Interval)
+	return r, nil
+}
+
+// stopRun cancels a run and waits for its output to be flushed.
+func (s *Server) stopRun(uuid string) (*runState, error) {
+	s.mu.Lock()
+	r, ok := s.runs[uuid]
+	switch {
+	case !ok:
+		s.mu.Unlock()
+		return nil, errNotFound
+	case !r.running():
+		s.mu.Unlock()
+		return r, errStopped
+	}
+	r.cancel()
+	s.mu.Unlock()
+
+	<-r.done
+	slog.Info("server: stopped run", "uuid", uuid)
+	return r, nil
+}
+
+// latestRun returns the most recently created run, or nil. Called with s.mu
+// held.
+func (s *Server) latestRun() *runState {
+	if len(s.order) == 0 {
+		return nil
+	}
+	return s.runs[s.order[len(s.order)-1]]
+}
+
+// latestRunning returns the most recently created run still running, or nil.
+// Called with s.mu held.
+func (s *Server) latestRunning() *runState {
+	for i := len(s.order) - 1; i >= 0; i-- {
+		if r := s.runs[s.order[i]]; r.running() {
+			return r
+		}
+	}
+	return nil
+}
+
+// Shutdown stops every run, flushing their output, then the HTTP server,
+// whether or not it is listening yet.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	var running []string
+	for _, uuid := range s.order {
+		if s.runs[uuid].running() {
+			running = append(running, uuid)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, uuid := range running {
+		slog.Info("server: stopping run before shutdown", "uuid", uuid)
+		s.stopRun(uuid)
+	}
+	close(s.stopRetention)
+	return s.httpServer.Shutdown(ctx)
+}
+
+func decodeSpec(r *http.Request) (utils.RunSpec, error) {
+	var spec utils.RunSpec
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil && !errors.Is(err, io.EOF) {
+		return spec, fmt.Errorf("%w: %v", errBadSpec, err)
+	}
+	return spec, nil
+}
+
+// runError writes err with the status the /runs and /collect endpoints use.
+func runError(w http.ResponseWriter, err error) {
+	status := http.StatusConflict
+	switch {
+	case errors.Is(err, errBadSpec):
+		status = http.StatusBadRequest
+	case errors.Is(err, errNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, utils.ErrLowDisk):
+		status = http.StatusInsufficientStorage
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// handleRunsList lists the catalogue, newest first, filtered by state,
+// uuid prefix, host, collector, marker label and start time, and paged with
+// offset and limit.
+func (s *Server) handleRunsList(w http.ResponseWriter, r *http.Request) {
+	filter, offset, limit, err := parseRunQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	all := s.catalog.all()
+	s.mu.Lock()
+	for uuid, run := range s.runs {
+		all[uuid] = run.info()
+	}
+	s.mu.Unlock()
+
+	runs := make([]RunInfo, 0, len(all))
+	for _, info := range all {
+		if filter.match(info) {
+			runs = append(runs, info)
+		}
+	}
+	slices.SortFunc(runs, func(a, b RunInfo) int {
+		if c := b.Started.Compare(a.Started); c != 0 {
+			return c
+		}
+		return strings.Compare(a.UUID, b.UUID)
+	})
+	total := len(runs)
+	// offset+limit can overflow, so clamp before adding.
+	start := min(offset, total)
+	runs = runs[start : start+min(limit, total-start)]
+	writeJSON(w, http.StatusOK, RunList{Runs: runs, Count: len(runs), Total: total, Offset: offset, Limit: limit})
+}
+
+func (s *Server) handleRunsCreate(w http.ResponseWriter, r *http.Request) {
+	spec, err := decodeSpec(r)
+	if err != nil {
+		runError(w, err)
+		return
+	}
+	run, err := s.startRun(spec, false)
+	if err != nil {
+		runError(w, err)
+		return
+	}
+	s.mu.Lock()
+	info := run.info()
+	s.mu.Unlock()
+	writeJSON(w, http.StatusCreated, info)
+}
+
+func (s *Server) handleRunGet(w http.ResponseWriter, r *http.Request) {
+	uuid := r.PathValue("uuid")
+	s.mu.Lock()
+	run, live := s.runs[uuid]
+	var info RunInfo
+	if live {
+		info = run.info()
+	}
+	s.mu.Unlock()
+	if !live {
+		var ok bool
+		if info, ok = s.catalog.get(uuid); !ok {
+			runError(w, errNotFound)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (s *Server) handleRunStop(w http.ResponseWriter, r *http.Request) {
+	run, err := s.stopRun(r.PathValue("uuid"))
+	if err != nil {
+		runError(w, err)
+		return
+	}
+	s.mu.Lock()
+	info := run.info()
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, info)
+}
+
+// handleRunDelete stops a run if it is still going and removes it from the
+// catalogue. Its output files stay.
+func (s *Server) handleRunDelete(w http.ResponseWriter, r *http.Request) {
+	uuid := r.PathValue("uuid")
+	_, err := s.stopRun(uuid)
+	if err != nil && !errors.Is(err, errStopped) && !errors.Is(err, errNotFound) {
+		runError(w, err)
+		return
+	}
+	if !s.catalog.remove(uuid) && errors.Is(err, errNotFound) {
+		runError(w, errNotFound)
+		return
+	}
+	s.forget(uuid)
+	writeJSON(w, http.StatusOK, StateChange{State: "deleted", UUID: uuid})
+}
+
+// forget drops a stopped run from the runs started since startup.
+func (s *Server) forget(uuid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.runs, uuid)
+	for i, u := range s.order {
+		if u == uuid {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// handleMarker adds a marker to the run named by the path or the body's
+// uuid, by default the latest running one, and responds once the tick it
+// was attached to has been taken.
+func (s *Server) handleMarker(w http.ResponseWriter, r *http.Request) {
+	var req MarkerRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid marker: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Label == "" {
+		http.Error(w, "invalid marker: label is required", http.StatusBadRequest)
+		return
+	}
+	uuid := r.PathValue("uuid")
+	if uuid == "" {
+		uuid = req.UUID
+	}
+
+	s.mu.Lock()
+	run := s.latestRunning()
+	if uuid != "" {
+		run = s.runs[uuid]
+	}
+	s.mu.Unlock()
+	if run == nil {
+		if uuid != "" {
+			runError(w, errNotFound)
+		} else {
+			http.Error(w, "not collecting", http.StatusConflict)
+		}
+		return
+	}
+
+	m, err := run.run.Mark(r.Context(), req.Label)
+	switch {
+	case errors.Is(err, collecting.ErrRunEnded):
+		runError(w, errStopped)
+		return
+	case err != nil:
+		return // client went away
+	}
+	s.mu.Lock()
+	info := run.info()
+	s.mu.Unlock()
+	s.catalog.put(info)
+	writeJSON(w, http.StatusOK, MarkerResponse{UUID: run.uuid, Marker: m})
+}
+
+// handleCollectGet reports the latest run in the single-run format /collect
+// has always used.
+func (s *Server) handleCollectGet(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp := CollectStatus{
+		Collectors: s.manager.InitResults(),
+		Health:     s.manager.Health(),
+	}
+
+	if r := s.latestRunning(); r != nil {
+		spec := r.cfg.Spec()
+		resp.State = "collecting"
+		resp.Mode = "continuous"
+		resp.UUID = r.uuid
+		resp.Elapsed = time.Since(r.started).String()
+		resp.Config = &spec
+	} else {
+		resp.State = "idle"
+		if r := s.latestRun(); r != nil {
+			resp.LastUUID = r.uuid
+			resp.LastMode = "continuous"
+			resp.LastCount = r.records.records.Load()
+			resp.LastStopReason = r.reason
+		}
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleCollectPut(w http.ResponseWriter, r *http.Request) {
+	spec, err := decodeSpec(r)
+	if err != nil {
+		runError(w, err)
+		return
+	}
+	run, err := s.startRun(spec, true)
+	if err != nil {
+		runError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, RunStarted{State: "started", UUID: run.uuid, Config: run.cfg.Spec()})
+}
+
+// handleCollectDelete stops the latest running run.
+func (s *Server) handleCollectDelete(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	r := s.latestRunning()
+	s.mu.Unlock()
+	if r == nil {
+		http.Error(w, "not collecting", http.StatusConflict)
+		return
+	}
+	if _, err := s.stopRun(r.uuid); err != nil {
+		runError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, StateChange{State: "stopped", UUID: r.uuid})
+}
+
+// handleAlerts reports the alert rules of the run named by ?uuid=, by
+// default the latest run.
+func (s *Server) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	run := s.latestRun()
+	if uuid := r.URL.Query().Get("uuid"); uuid != "" {
+		run = s.runs[uuid]
+		if run == nil {
+			s.mu.Unlock()
+			runError(w, errNotFound)
+			return
+		}
+	}
+	var uuid string
+	var engine *alerting.Engine
+	active := false
+	if run != nil {
+		uuid, engine, active = run.uuid, run.alerts, run.running()
+	}
+	s.mu.Unlock()
+
+	states := []alerting.RuleState{}
+	if engine != nil {
+		states = engine.States()
+	}
+	firing := 0
+	for _, st := range states {
+		if st.State == alerting.StateFiring {
+			firing++
+		}
+	}
+	writeJSON(w, http.StatusOK, AlertList{UUID: uuid, Collecting: active, Firing: firing, Alerts: states})
+}
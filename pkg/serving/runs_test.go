@@ -0,0 +1,145 @@
+package serving
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"InferenceProfiler/pkg/collecting"
+	"InferenceProfiler/pkg/collecting/base"
+	"InferenceProfiler/pkg/utils"
+)
+
+// gatedCollector is off unless a run enables it; its Init waits for
+// initGate to stand in for a slow driver.
+type gatedCollector struct{}
+
+var initGate chan struct{}
+
+func (gatedCollector) Name() string { return "Gated" }
+func (gatedCollector) Init(*utils.Config) error {
+	<-initGate
+	return nil
+}
+func (gatedCollector) Static() any              { return nil }
+func (gatedCollector) Poll(context.Context) any { return 1 }
+func (gatedCollector) Close() error             { return nil }
+
+func init() {
+	collecting.Register(collecting.Registration{
+		CollectorSpec: utils.CollectorSpec{Name: "gated"},
+		New:           func() base.Collector { return gatedCollector{} },
+	})
+}
+
+// runConfig returns a config that runs can be started under.
+func runConfig() *utils.Config {
+	return &utils.Config{
+		StalePolicy:   utils.StaleSkip,
+		StaleAfter:    3,
+		DegradedAfter: 3,
+		QueueSize:     4,
+		QueuePolicy:   utils.QueueBlock,
+	}
+}
+
+func TestRunsListOffset(t *testing.T) {
+	h := newTestServer(t, &utils.Config{}).Handler()
+	for _, offset := range []int{0, 5, math.MaxInt - 50, math.MaxInt} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/runs?offset="+strconv.Itoa(offset), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("offset %d: status %d: %s", offset, rec.Code, rec.Body.String())
+		}
+		var list RunList
+		if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		if list.Count != 0 || list.Offset != offset {
+			t.Errorf("offset %d: count=%d offset=%d", offset, list.Count, list.Offset)
+		}
+	}
+}
+
+func TestShutdownStopsRuns(t *testing.T) {
+	for _, listen := range []bool{false, true} {
+		cfg := runConfig()
+		cfg.Bind = "127.0.0.1"
+		srv := newTestServer(t, cfg)
+		served := make(chan error, 1)
+		if listen {
+			// Shutdown may come before the server is listening.
+			go func() { served <- srv.ListenAndServe() }()
+		}
+		r, err := srv.startRun(utils.RunSpec{UUID: "run"}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := srv.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-r.done:
+		default:
+			t.Errorf("listen=%v: run still going after Shutdown", listen)
+		}
+		if listen {
+			if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+				t.Errorf("ListenAndServe = %v, want ErrServerClosed", err)
+			}
+		}
+	}
+}
+
+func TestStartRunInitsOutsideLock(t *testing.T) {
+	initGate = make(chan struct{})
+	srv := newTestServer(t, runConfig())
+	h := srv.Handler()
+
+	started := make(chan error, 1)
+	go func() {
+		_, err := srv.startRun(utils.RunSpec{UUID: "slow", Enabled: []string{"gated"}}, true)
+		started <- err
+	}()
+	for {
+		srv.mu.Lock()
+		reserved := srv.starting["slow"]
+		srv.mu.Unlock()
+		if reserved {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The API answers while the collectors initialise, and the uuid and,
+	// for exclusive starts, the server are taken.
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/runs", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /runs = %d", rec.Code)
+	}
+	if _, err := srv.startRun(utils.RunSpec{UUID: "slow"}, false); err == nil {
+		t.Error("started a second run under a reserved uuid")
+	}
+	if _, err := srv.startRun(utils.RunSpec{UUID: "other"}, true); err == nil {
+		t.Error("started an exclusive run while another was starting")
+	}
+
+	close(initGate)
+	if err := <-started; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.stopRun("slow"); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.starting) != 0 {
+		t.Errorf("reservations left: %v", srv.starting)
+	}
+}
@@ -1,13 +1,15 @@
 package serving
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,126 +20,86 @@ import (
 
 type Server struct {
 	manager    *collecting.Manager
+	base       *utils.Config
 	httpServer *http.Server
+	catalog    *catalog
+	host       string
 
-	mu        sync.Mutex
-	uuid      string
-	mode      string
-	startTime time.Time
-	count     int64
-	cancel    context.CancelFunc
-	done      chan struct{}
-}
+	stopRetention chan struct{}
 
-func NewServer(manager *collecting.Manager) *Server {
-	return &Server{manager: manager}
+	mu       sync.Mutex
+	runs     map[string]*runState
+	order    []string        // run UUIDs by creation
+	starting map[string]bool // UUIDs of runs whose collectors are initialising
 }
 
-func (s *Server) ListenAndServe(port int) error {
-	mux := http.NewServeMux()
-
-	mux.HandleFunc("GET /health", s.handleHealth)
-	mux.HandleFunc("GET /snapshot", s.handleSnapshot)
-	mux.HandleFunc("GET /collect", s.handleCollectGet)
-	mux.HandleFunc("PUT /collect", s.handleCollectPut)
-	mux.HandleFunc("DELETE /collect", s.handleCollectDelete)
-	mux.HandleFunc("GET /files", s.handleListFiles)
-	mux.HandleFunc("GET /files/{uuid}", s.handleGetFile)
-
+func NewServer(manager *collecting.Manager) *Server {
+	base := manager.Config()
+	host, _ := os.Hostname()
+	s := &Server{
+		manager:       manager,
+		base:          base,
+		catalog:       loadCatalog(base.OutputDir),
+		host:          host,
+		stopRetention: make(chan struct{}),
+		runs:          make(map[string]*runState),
+		starting:      make(map[string]bool),
+	}
+	// Made here, not in ListenAndServe, so that Shutdown can run at any
+	// time: a server shut down first refuses to listen.
 	s.httpServer = &http.Server{
-		Addr:              fmt.Sprintf("%s:%d", "0.0.0.0", port),
-		Handler:           mux,
+		Addr:              net.JoinHostPort(base.Bind, strconv.Itoa(base.ServerPort)),
+		Handler:           s.Handler(),
 		ReadHeaderTimeout: 10 * time.Second,
 		ReadTimeout:       30 * time.Second,
 		IdleTimeout:       60 * time.Second,
 	}
-
-	return s.httpServer.ListenAndServe()
+	return s
 }
 
-func (s *Server) Shutdown(ctx context.Context) error {
-	if s.httpServer == nil {
-		return nil
-	}
-	s.mu.Lock()
-	if s.collecting() {
-		slog.Info("server: stopping active collection before shutdown", "uuid", s.uuid)
-		s.cancel()
-		done := s.done
-		s.mu.Unlock()
-		<-done
-	} else {
-		s.mu.Unlock()
+// Handler returns the API with -token/-read-token checks applied. /health
+// needs no token so load balancers can probe it.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	a := newAuth(s.base)
+	handle := func(pattern, scope string, h http.HandlerFunc) {
+		mux.HandleFunc(pattern, a.require(scope, h))
 	}
-	return s.httpServer.Shutdown(ctx)
-}
-
-func (s *Server) collecting() bool {
-	return s.cancel != nil
-}
 
-func resolveUUID(requestUUID string) string {
-	if requestUUID != "" {
-		return requestUUID
-	}
-	return utils.GenerateUUID()
+	handle("GET /health", ScopeNone, s.handleHealth)
+	handle("GET /snapshot", ScopeRead, s.handleSnapshot)
+	handle("GET /collect", ScopeRead, s.handleCollectGet)
+	handle("PUT /collect", ScopeControl, s.handleCollectPut)
+	handle("DELETE /collect", ScopeControl, s.handleCollectDelete)
+	handle("POST /collect/markers", ScopeControl, s.handleMarker)
+	handle("GET /runs", ScopeRead, s.handleRunsList)
+	handle("POST /runs", ScopeControl, s.handleRunsCreate)
+	handle("GET /runs/{uuid}", ScopeRead, s.handleRunGet)
+	handle("POST /runs/{uuid}/stop", ScopeControl, s.handleRunStop)
+	handle("DELETE /runs/{uuid}", ScopeControl, s.handleRunDelete)
+	handle("POST /runs/{uuid}/markers", ScopeControl, s.handleMarker)
+	handle("GET /collectors", ScopeRead, s.handleCollectorsGet)
+	handle("POST /collectors/{name}/{action}", ScopeControl, s.handleCollectorAction)
+	handle("GET /alerts", ScopeRead, s.handleAlerts)
+	handle("GET /files", ScopeRead, s.handleListFiles)
+	handle("GET /files/{uuid}", ScopeRead, s.handleGetFile)
+	handle("DELETE /files/{uuid}", ScopeControl, s.handleDeleteFile)
+	return mux
 }
 
-func (s *Server) startContinuous(requestUUID string) (string, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if s.collecting() {
-		return "", fmt.Errorf("already collecting uuid=%s", s.uuid)
+// ListenAndServe serves the API on -bind:-port, over TLS when -tls-cert is
+// set.
+func (s *Server) ListenAndServe() error {
+	tc, err := tlsConfig(s.base)
+	if err != nil {
+		return err
 	}
-
-	uuid := resolveUUID(requestUUID)
-	ctx, cancel := context.WithCancel(context.Background())
-
-	cfg := *s.manager.Config()
-	cfg.UUID = uuid
-	w := utils.NewWriter(&cfg)
-
-	s.uuid = uuid
-	s.mode = "continuous"
-	s.startTime = time.Now()
-	s.count = 0
-	s.cancel = cancel
-	s.done = make(chan struct{})
-
-	go func() {
-		defer close(s.done)
-		defer w.Close()
-		count, err := s.manager.Continuous(ctx, w)
-		if err != nil {
-			slog.Error("server: continuous stopped with error", "uuid", uuid, "error", err)
-		}
-
-		s.mu.Lock()
-		s.count = int64(count)
-		s.cancel = nil
-		s.mu.Unlock()
-	}()
-
-	slog.Info("server: started continuous", "uuid", uuid)
-	return uuid, nil
-}
-
-func (s *Server) stop() (string, error) {
-	s.mu.Lock()
-	if !s.collecting() {
-		s.mu.Unlock()
-		return "", fmt.Errorf("not collecting")
+	s.httpServer.TLSConfig = tc
+	go s.retentionLoop(s.stopRetention)
+	if tc != nil {
+		return s.httpServer.ListenAndServeTLS("", "")
 	}
-
-	s.cancel()
-	uuid := s.uuid
-	done := s.done
-	s.mu.Unlock()
-
-	<-done
-	slog.Info("server: stopped collection", "uuid", uuid)
-	return uuid, nil
+	return s.httpServer.ListenAndServe()
 }
 
 func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
@@ -145,64 +107,44 @@ func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
 	fmt.Fprint(w, "ok")
 }
 
-func (s *Server) handleCollectGet(w http.ResponseWriter, _ *http.Request) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	resp := map[string]any{
-		"collectors": s.manager.InitResults(),
-	}
-
-	if s.collecting() {
-		resp["state"] = "collecting"
-		resp["mode"] = s.mode
-		resp["uuid"] = s.uuid
-		resp["elapsed"] = time.Since(s.startTime).String()
-	} else {
-		resp["state"] = "idle"
-		if s.uuid != "" {
-			resp["last_uuid"] = s.uuid
-			resp["last_mode"] = s.mode
-			resp["last_count"] = s.count
-		}
-	}
-
-	writeJSON(w, http.StatusOK, resp)
-}
-
 func (s *Server) handleSnapshot(w http.ResponseWriter, _ *http.Request) {
 	tick := s.manager.SnapshotTick()
-	resp := map[string]any{
-		"static": s.manager.StaticData(),
-		"tick":   tick,
-	}
-	writeJSON(w, http.StatusOK, resp)
+	writeJSON(w, http.StatusOK, Snapshot{Static: s.manager.StaticData(), Tick: tick})
 }
 
-func (s *Server) handleCollectPut(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		UUID string `json:"uuid"`
-	}
-	json.NewDecoder(r.Body).Decode(&req)
+func (s *Server) handleCollectorsGet(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, CollectorList{Collectors: s.manager.InitResults(), Health: s.manager.Health()})
+}
 
-	uuid, err := s.startContinuous(req.UUID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusConflict)
+func (s *Server) handleCollectorAction(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	var (
+		result collecting.InitResult
+		err    error
+	)
+	switch r.PathValue("action") {
+	case "enable":
+		result, err = s.manager.Enable(name)
+	case "disable":
+		result, err = s.manager.Disable(name)
+	case "reinit":
+		result, err = s.manager.Reinit(name)
+	default:
+		http.Error(w, "unknown action, want enable, disable or reinit", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, collecting.ErrUnknownCollector) {
+		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{
-		"state": "started",
-		"uuid":  uuid,
-	})
-}
-
-func (s *Server) handleCollectDelete(w http.ResponseWriter, _ *http.Request) {
-	uuid, err := s.stop()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusConflict)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"state": "stopped", "uuid": uuid})
+	slog.Info("server: collector action", "collector", result.Name, "action", r.PathValue("action"),
+		"available", result.Available)
+	writeJSON(w, http.StatusOK, result)
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
@@ -211,8 +153,10 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	json.NewEncoder(w).Encode(v)
 }
 
+// outputDir is the directory the catalogue was loaded from; run specs
+// cannot change it.
 func (s *Server) outputDir() string {
-	return s.manager.Config().OutputDir
+	return s.base.OutputDir
 }
 
 func (s *Server) requireOutputDir(w http.ResponseWriter) (string, bool) {
@@ -231,18 +175,19 @@ func (s *Server) handleListFiles(w http.ResponseWriter, r *http.Request) {
 	}
 
 	filter := r.URL.Query().Get("uuid")
+	if filter != "" {
+		if err := utils.CheckUUID(filter); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 	entries, err := os.ReadDir(outDir)
 	if err != nil {
 		http.Error(w, "failed to read output directory: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	type fileEntry struct {
-		Name string `json:"name"`
-		Size int64  `json:"size"`
-	}
-
-	files := make([]fileEntry, 0, len(entries))
+	files := make([]FileEntry, 0, len(entries))
 	for _, e := range entries {
 		if e.IsDir() {
 			continue
@@ -251,35 +196,77 @@ func (s *Server) handleListFiles(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 		if info, err := e.Info(); err == nil {
-			files = append(files, fileEntry{Name: e.Name(), Size: info.Size()})
+			files = append(files, FileEntry{Name: e.Name(), Size: info.Size()})
 		}
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"files": files,
-		"count": len(files),
-	})
+	writeJSON(w, http.StatusOK, FileList{Files: files, Count: len(files)})
 }
 
+// handleGetFile streams the file named {uuid}, or else the run's output
+// {uuid}.jsonl, as written or, with ?format=, converted; see
+// serveConverted. Unconverted downloads fall back to the first file whose
+// name starts with {uuid}, as they always have. Conversions do not: names
+// sort "run-2.jsonl" before "run.jsonl".
 func (s *Server) handleGetFile(w http.ResponseWriter, r *http.Request) {
 	outDir, ok := s.requireOutputDir(w)
 	if !ok {
 		return
 	}
 
-	uuid := r.PathValue("uuid")
-	entries, err := os.ReadDir(outDir)
-	if err != nil {
-		http.Error(w, "failed to read output directory: "+err.Error(), http.StatusInternalServerError)
+	q := r.URL.Query()
+	format := q.Get("format")
+	sel, err := parseFields(q.Get("fields"))
+	switch {
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case format == "" || format == FormatJSONL:
+		if len(sel) > 0 {
+			http.Error(w, "fields needs format=flat-jsonl, csv or parquet", http.StatusBadRequest)
+			return
+		}
+		format = ""
+	case format != FormatFlatJSONL && format != FormatCSV && format != FormatParquet:
+		http.Error(w, "unknown format, want jsonl, flat-jsonl, csv or parquet", http.StatusBadRequest)
 		return
 	}
 
-	for _, e := range entries {
-		if !e.IsDir() && strings.HasPrefix(e.Name(), uuid) {
-			http.ServeFile(w, r, filepath.Join(outDir, e.Name()))
+	uuid := r.PathValue("uuid")
+	if err := utils.CheckUUID(uuid); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := ""
+	for _, candidate := range []string{uuid, uuid + ".jsonl"} {
+		if st, err := os.Stat(filepath.Join(outDir, candidate)); err == nil && st.Mode().IsRegular() {
+			name = candidate
+			break
+		}
+	}
+	if name == "" && format == "" {
+		entries, err := os.ReadDir(outDir)
+		if err != nil {
+			http.Error(w, "failed to read output directory: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		for _, e := range entries {
+			if !e.IsDir() && strings.HasPrefix(e.Name(), uuid) {
+				name = e.Name()
+				break
+			}
+		}
 	}
 
-	http.Error(w, "not found", http.StatusNotFound)
+	path := filepath.Join(outDir, name)
+	switch {
+	case name == "":
+		http.Error(w, "not found", http.StatusNotFound)
+	case format == "":
+		http.ServeFile(w, r, path)
+	case !strings.HasSuffix(name, ".jsonl"):
+		http.Error(w, name+" is not JSONL output and cannot be converted", http.StatusBadRequest)
+	default:
+		s.serveConverted(w, path, format, sel)
+	}
 }
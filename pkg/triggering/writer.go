@@ -0,0 +1,215 @@
+// Package triggering records windows around interesting events. A Writer
+// sits in front of the run's writer, keeps the last -trigger-pre seconds of
+// records in memory and, when a -trigger expression starts to hold, writes
+// that history plus the following -trigger-post seconds to a capture file
+// of its own.
+package triggering
+
+import (
+	"InferenceProfiler/pkg/collecting/base"
+	"InferenceProfiler/pkg/utils"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Event is written under "Trigger" in the static line of a capture file and
+// in every captured record where a trigger fired.
+type Event struct {
+	Label   string        `json:"Label"`
+	Expr    string        `json:"Expr"`
+	FiredAt int64         `json:"FiredAt"`
+	Matches []utils.Match `json:"Matches"`
+}
+
+type record struct {
+	at   time.Time
+	data map[string]any
+}
+
+// capture is an open capture file. It ends post after the last trigger that
+// fired while it was open.
+type capture struct {
+	w       *utils.Writer
+	path    string
+	until   time.Time
+	records int
+}
+
+type Writer struct {
+	inner    base.Writer
+	cfg      *utils.Config
+	triggers []utils.Trigger
+	pre      time.Duration
+	post     time.Duration
+	only     bool
+
+	static  map[string]any
+	pending map[string]any
+	ring    []record
+	prev    map[string]any
+	prevAt  time.Time
+	holding map[string]bool
+	seq     int
+	capture *capture
+
+	// captured counts the bytes written to capture files, for Size.
+	captured atomic.Int64
+
+	// now is time.Now, replaced in tests.
+	now func() time.Time
+}
+
+// Wrap returns w unchanged when cfg has no triggers, and otherwise a Writer
+// evaluating them in front of w. With -trigger-only the wrapped writer only
+// receives the static line.
+func Wrap(w base.Writer, cfg *utils.Config) base.Writer {
+	if len(cfg.Triggers) == 0 {
+		return w
+	}
+	return &Writer{
+		inner:    w,
+		cfg:      cfg,
+		triggers: cfg.Triggers,
+		pre:      time.Duration(cfg.TriggerPre) * time.Second,
+		post:     time.Duration(cfg.TriggerPost) * time.Second,
+		only:     cfg.TriggerOnly,
+		static:   make(map[string]any),
+		pending:  make(map[string]any),
+		holding:  make(map[string]bool),
+		now:      time.Now,
+	}
+}
+
+func (w *Writer) Static(name string, data any) error {
+	w.static[name] = data
+	return w.inner.Static(name, data)
+}
+
+func (w *Writer) Dynamic(name string, data any) error {
+	w.pending[name] = data
+	if w.only {
+		return nil
+	}
+	return w.inner.Dynamic(name, data)
+}
+
+// Flush writes the pending record to the wrapped writer, evaluates the
+// triggers against it and feeds it to the open capture, if any.
+func (w *Writer) Flush() error {
+	err := w.inner.Flush()
+	if len(w.pending) == 0 {
+		return err
+	}
+
+	now := w.now()
+	// Captured records keep the time they were taken, not the time they
+	// reach the capture file.
+	w.pending["timestamp"] = now.UnixNano()
+	rec := record{at: now, data: w.pending}
+	w.pending = make(map[string]any)
+
+	// Only the last pre seconds are history for a capture opening now.
+	i := 0
+	for i < len(w.ring) && now.Sub(w.ring[i].at) > w.pre {
+		i++
+	}
+	w.ring = w.ring[i:]
+
+	flat := utils.Flatten(rec.data)
+	var fired []Event
+	for _, t := range w.triggers {
+		matches, ok := t.Expr.Eval(flat, w.prev, now.Sub(w.prevAt))
+		// Triggers fire when their expression starts to hold, so a
+		// condition that stays true yields one capture, not one per record.
+		if ok && !w.holding[t.Label] {
+			fired = append(fired, Event{Label: t.Label, Expr: t.Expr.String(), FiredAt: now.UnixNano(), Matches: matches})
+		}
+		w.holding[t.Label] = ok
+	}
+	w.prev, w.prevAt = flat, now
+
+	if w.capture != nil && !now.Before(w.capture.until) && len(fired) == 0 {
+		w.closeCapture()
+	}
+	if len(fired) > 0 {
+		for _, ev := range fired {
+			log.Printf("trigger: %s fired (%s)", ev.Label, describe(ev.Matches))
+		}
+		if w.capture == nil {
+			w.openCapture(fired[0], now)
+		} else {
+			w.capture.until = now.Add(w.post)
+		}
+		rec.data["Trigger"] = fired
+	}
+	if w.capture != nil {
+		w.writeCapture(rec)
+	}
+
+	w.ring = append(w.ring, rec)
+	return err
+}
+
+func (w *Writer) openCapture(ev Event, now time.Time) {
+	w.seq++
+	path := filepath.Join(w.cfg.OutputDir, fmt.Sprintf("%s.trigger-%s-%d.jsonl", w.cfg.UUID, ev.Label, w.seq))
+	fw, err := utils.NewFileWriter(w.cfg, path)
+	if err != nil {
+		log.Printf("trigger: %s: %v", ev.Label, err)
+		return
+	}
+	for name, data := range w.static {
+		fw.Static(name, data)
+	}
+	fw.Static("Trigger", ev)
+	w.capture = &capture{w: fw, path: path, until: now.Add(w.post)}
+
+	for _, r := range w.ring {
+		w.writeCapture(r)
+	}
+}
+
+func (w *Writer) writeCapture(r record) {
+	for name, data := range r.data {
+		w.capture.w.Dynamic(name, data)
+	}
+	before := w.capture.w.Size()
+	if err := w.capture.w.Flush(); err != nil {
+		log.Printf("trigger: write %s: %v", w.capture.path, err)
+	}
+	w.captured.Add(w.capture.w.Size() - before)
+	w.capture.records++
+}
+
+func (w *Writer) closeCapture() {
+	if err := w.capture.w.Close(); err != nil {
+		log.Printf("trigger: close %s: %v", w.capture.path, err)
+	}
+	log.Printf("trigger: captured %d records to %s", w.capture.records, w.capture.path)
+	w.capture = nil
+}
+
+// Size returns the bytes written to the wrapped writer and capture files.
+func (w *Writer) Size() int64 { return base.SizeOf(w.inner) + w.captured.Load() }
+
+// Close ends an open capture early and closes the wrapped writer.
+func (w *Writer) Close() error {
+	if w.capture != nil {
+		w.closeCapture()
+	}
+	return w.inner.Close()
+}
+
+func describe(matches []utils.Match) string {
+	s := ""
+	for i, m := range matches {
+		if i > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("%s=%g", m.Field, m.Value)
+	}
+	return s
+}
@@ -0,0 +1,196 @@
+package triggering
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"InferenceProfiler/pkg/utils"
+)
+
+// start is second 0 of the fake clock.
+var start = time.Unix(1000, 0)
+
+// innerWriter counts what reaches the wrapped writer.
+type innerWriter struct{ static, dynamic int }
+
+func (w *innerWriter) Static(string, any) error  { w.static++; return nil }
+func (w *innerWriter) Dynamic(string, any) error { w.dynamic++; return nil }
+func (w *innerWriter) Flush() error              { return nil }
+func (w *innerWriter) Close() error              { return nil }
+
+// harness drives a Writer on a fake clock.
+type harness struct {
+	t     *testing.T
+	w     *Writer
+	inner *innerWriter
+	dir   string
+	now   time.Time
+}
+
+// newHarness wraps a writer with -trigger-pre pre and -trigger-post post
+// seconds and the given -trigger values.
+func newHarness(t *testing.T, pre, post int, only bool, triggers ...string) *harness {
+	t.Helper()
+	cfg := &utils.Config{UUID: "run", OutputDir: t.TempDir(), TriggerPre: pre, TriggerPost: post, TriggerOnly: only}
+	for _, s := range triggers {
+		tr, err := utils.ParseTrigger(s, len(cfg.Triggers)+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Triggers = append(cfg.Triggers, tr)
+	}
+	h := &harness{t: t, inner: &innerWriter{}, dir: cfg.OutputDir, now: start}
+	h.w = Wrap(h.inner, cfg).(*Writer)
+	h.w.now = func() time.Time { return h.now }
+	h.w.Static("Host", map[string]any{"Name": "h"})
+	return h
+}
+
+// tick moves the clock step on and writes one record of the Gpu section.
+func (h *harness) tick(step time.Duration, gpu map[string]any) {
+	h.t.Helper()
+	h.now = h.now.Add(step)
+	h.w.Dynamic("Gpu", gpu)
+	if err := h.w.Flush(); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// temps writes one record a second per temperature.
+func (h *harness) temps(temps ...float64) {
+	h.t.Helper()
+	for _, temp := range temps {
+		h.tick(time.Second, map[string]any{"Temp": temp})
+	}
+}
+
+// captures closes the writer and returns each capture file's records as the
+// second they were taken, followed by !LABEL for every trigger fired in it.
+func (h *harness) captures() map[string][]string {
+	h.t.Helper()
+	if err := h.w.Close(); err != nil {
+		h.t.Fatal(err)
+	}
+	paths, err := filepath.Glob(filepath.Join(h.dir, "run.trigger-*.jsonl"))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	out := make(map[string][]string)
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(f)
+		for first := true; scanner.Scan(); first = false {
+			var line struct {
+				Timestamp int64
+				Trigger   json.RawMessage
+				Host      any
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				h.t.Fatalf("%s: %v", path, err)
+			}
+			if first {
+				// The static line names the trigger that opened the file.
+				if line.Host == nil || line.Trigger == nil {
+					h.t.Errorf("%s: static line %s", path, scanner.Text())
+				}
+				continue
+			}
+			rec := fmt.Sprint(int64(time.Unix(0, line.Timestamp).Sub(start) / time.Second))
+			var fired []Event
+			json.Unmarshal(line.Trigger, &fired)
+			for _, ev := range fired {
+				rec += "!" + ev.Label
+			}
+			out[filepath.Base(path)] = append(out[filepath.Base(path)], rec)
+		}
+		f.Close()
+	}
+	return out
+}
+
+func TestTriggerFiresWhenItStartsToHold(t *testing.T) {
+	h := newHarness(t, 3, 2, false, "hot: GpuTemp > 80")
+	// Holds from second 6 to 8: one capture with seconds 3-5 as history
+	// and 6-7 after, closed at 8 although it still holds.
+	h.temps(50, 50, 50, 50, 50, 90, 90, 90, 50, 50)
+	// Re-armed once it stopped holding; history may overlap the first.
+	h.temps(90, 90)
+
+	want := map[string][]string{
+		"run.trigger-hot-1.jsonl": {"3", "4", "5", "6!hot", "7"},
+		// Close ends the capture early.
+		"run.trigger-hot-2.jsonl": {"8", "9", "10", "11!hot", "12"},
+	}
+	if got := h.captures(); !reflect.DeepEqual(got, want) {
+		t.Errorf("captures = %v, want %v", got, want)
+	}
+	if h.inner.static != 1 || h.inner.dynamic != 12 {
+		t.Errorf("wrapped writer got %d static and %d dynamic sections, want 1 and 12", h.inner.static, h.inner.dynamic)
+	}
+}
+
+func TestTriggerExtendsOpenCapture(t *testing.T) {
+	h := newHarness(t, 1, 2, false, "hot: GpuTemp > 80", "busy: GpuUtil >= 100")
+	h.temps(50, 50, 90, 50)
+	// Fires again as the capture would end: it runs 2s past second 5.
+	h.temps(90, 50, 50, 50)
+	// Both fire at once: one capture, named after the first.
+	h.tick(time.Second, map[string]any{"Temp": 95, "Util": 100})
+	h.temps(50)
+
+	want := map[string][]string{
+		"run.trigger-hot-1.jsonl": {"2", "3!hot", "4", "5!hot", "6"},
+		"run.trigger-hot-2.jsonl": {"8", "9!hot!busy", "10"},
+	}
+	if got := h.captures(); !reflect.DeepEqual(got, want) {
+		t.Errorf("captures = %v, want %v", got, want)
+	}
+}
+
+func TestTriggerRate(t *testing.T) {
+	h := newHarness(t, 0, 1, false, "fast: rate(GpuTokens) > 150", "preempt: delta(GpuPreempt) > 0")
+	// 200 tokens a second, then the same 200 over two seconds; the first
+	// record has no previous one to compare.
+	h.tick(time.Second, map[string]any{"Tokens": 0, "Preempt": 4})
+	h.tick(time.Second, map[string]any{"Tokens": 200, "Preempt": 4})
+	h.tick(2*time.Second, map[string]any{"Tokens": 400, "Preempt": 4})
+	h.tick(time.Second, map[string]any{"Tokens": 400, "Preempt": 5})
+
+	want := map[string][]string{
+		"run.trigger-fast-1.jsonl":    {"2!fast"},
+		"run.trigger-preempt-2.jsonl": {"5!preempt"},
+	}
+	if got := h.captures(); !reflect.DeepEqual(got, want) {
+		t.Errorf("captures = %v, want %v", got, want)
+	}
+}
+
+func TestTriggerHistoryIsBounded(t *testing.T) {
+	h := newHarness(t, 3, 1, true, "hot: GpuTemp > 80")
+	for range 100 {
+		h.tick(500*time.Millisecond, map[string]any{"Temp": 50})
+	}
+	// The last 3s at two records a second, and the one just written.
+	if len(h.w.ring) != 7 {
+		t.Errorf("%d records kept, want 7", len(h.w.ring))
+	}
+	h.temps(90)
+	got := h.captures()["run.trigger-hot-1.jsonl"]
+	if len(got) != 6 || !strings.HasSuffix(got[5], "!hot") {
+		t.Errorf("capture = %v, want 5 records of history and the trigger", got)
+	}
+	// -trigger-only: the wrapped writer keeps only the static line.
+	if h.inner.static != 1 || h.inner.dynamic != 0 {
+		t.Errorf("wrapped writer got %d static and %d dynamic sections, want 1 and 0", h.inner.static, h.inner.dynamic)
+	}
+}
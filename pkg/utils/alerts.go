@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// AlertRule fires once Expr has held for For, and resolves when it stops
+// holding. Expr uses the same syntax as -trigger.
+type AlertRule struct {
+	Name     string
+	Expr     Expr
+	For      time.Duration
+	Severity string
+}
+
+// AlertSpec is an alert rule as written in the -alerts file and run specs.
+type AlertSpec struct {
+	Name     string `json:"name"`
+	Expr     string `json:"expr"`
+	For      string `json:"for,omitempty"`
+	Severity string `json:"severity,omitempty"`
+}
+
+// alertFile is the -alerts file:
+//
+//	{
+//	  "webhook": "http://hooks.example/infpro",
+//	  "rules": [
+//	    {"name": "gpu-hot", "expr": "Nvidia*ThermalGPU > 85", "for": "30s", "severity": "critical"},
+//	    {"name": "vllm-down", "expr": "VllmAvailable == 0", "for": "10s"}
+//	  ]
+//	}
+type alertFile struct {
+	Webhook string      `json:"webhook"`
+	Rules   []AlertSpec `json:"rules"`
+}
+
+// LoadAlerts reads an -alerts file and returns its rules and webhook URL.
+func LoadAlerts(path string) ([]AlertRule, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var f alertFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", path, err)
+	}
+	rules, err := ParseAlerts(f.Rules)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, f.Webhook, nil
+}
+
+func ParseAlerts(specs []AlertSpec) ([]AlertRule, error) {
+	rules := make([]AlertRule, 0, len(specs))
+	seen := make(map[string]bool)
+	for i, r := range specs {
+		if r.Name == "" {
+			return nil, fmt.Errorf("rule %d has no name", i+1)
+		}
+		if seen[r.Name] {
+			return nil, fmt.Errorf("duplicate rule %q", r.Name)
+		}
+		seen[r.Name] = true
+
+		rule := AlertRule{Name: r.Name, Severity: r.Severity}
+		if rule.Severity == "" {
+			rule.Severity = "warning"
+		}
+		var err error
+		if rule.Expr, err = ParseExpr(r.Expr); err != nil {
+			return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+		if r.For != "" {
+			if rule.For, err = time.ParseDuration(r.For); err != nil || rule.For < 0 {
+				return nil, fmt.Errorf("rule %q: bad for %q", r.Name, r.For)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (r AlertRule) Spec() AlertSpec {
+	return AlertSpec{Name: r.Name, Expr: r.Expr.String(), For: r.For.String(), Severity: r.Severity}
+}
@@ -0,0 +1,274 @@
+package utils
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+)
+
+// CollectorSpec is what the flag parser needs to know about a collector. The
+// collecting package keeps the registry; utils only sees these specs so it
+// does not depend on any collector.
+//
+// Options, when set, returns a pointer to a struct holding the collector's
+// defaults. Each exported field tagged `flag:"name"` becomes a command-line
+// flag (and INFPRO_ env var) with the `help` tag as usage and the `arg` tag
+// naming its value in the help text; a bool field that also has
+// `disable:"name"` is set by listing that name in -disabled. Only fields
+// tagged `spec:"safe"` may be changed by a run spec, unless the server runs
+// with -allow-unsafe-specs: the others pick commands to run, hosts to
+// contact or fake hardware, which API callers must not control.
+type CollectorSpec struct {
+	Name        string
+	Description string
+	Default     bool
+	Flag        string
+	Interval    int // default poll interval in ms; 0 follows -interval
+	Options     func() any
+}
+
+// ToggleFlag is the flag that flips the collector away from its default:
+// -no-<name> for collectors that run by default, -<name> otherwise.
+func (s CollectorSpec) ToggleFlag() string {
+	if s.Flag != "" {
+		return s.Flag
+	}
+	if s.Default {
+		return "no-" + s.Name
+	}
+	return s.Name
+}
+
+// Enabled reports whether the named collector should run.
+func (c *Config) Enabled(name string) bool {
+	return !c.Disabled[name]
+}
+
+// CollectorOptions returns the options registered for a collector, or a zero
+// T if it has none (e.g. a Config built without ParseArgs).
+func CollectorOptions[T any](c *Config, name string) *T {
+	if opts, ok := c.Options[name].(*T); ok {
+		return opts
+	}
+	return new(T)
+}
+
+// optionField is one flag-tagged field of a collector's options struct.
+type optionField struct {
+	flag    string
+	json    string
+	help    string
+	arg     string
+	disable string
+	safe    bool
+	value   reflect.Value
+}
+
+func optionFields(opts any) []optionField {
+	v := reflect.ValueOf(opts)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		log.Fatalf("collector options must be a pointer to a struct, got %T", opts)
+	}
+	v = v.Elem()
+	var out []optionField
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		name := f.Tag.Get("flag")
+		if name == "" || !f.IsExported() {
+			continue
+		}
+		out = append(out, optionField{
+			flag:    name,
+			json:    strings.Split(f.Tag.Get("json"), ",")[0],
+			help:    f.Tag.Get("help"),
+			arg:     f.Tag.Get("arg"),
+			disable: f.Tag.Get("disable"),
+			safe:    f.Tag.Get("spec") == "safe",
+			value:   v.Field(i),
+		})
+	}
+	return out
+}
+
+// unsafeChange returns the JSON name of the first option not tagged
+// `spec:"safe"` whose value differs between base and opts, or "".
+func unsafeChange(base, opts any) string {
+	baseFields := optionFields(base)
+	for i, f := range optionFields(opts) {
+		if !f.safe && !reflect.DeepEqual(f.value.Interface(), baseFields[i].value.Interface()) {
+			return f.json
+		}
+	}
+	return ""
+}
+
+func (o optionField) bind(fs *flag.FlagSet) {
+	switch p := o.value.Addr().Interface().(type) {
+	case *bool:
+		fs.BoolVar(p, o.flag, *p, o.help)
+	case *int:
+		fs.IntVar(p, o.flag, *p, o.help)
+	case *float64:
+		fs.Float64Var(p, o.flag, *p, o.help)
+	case *string:
+		fs.StringVar(p, o.flag, *p, o.help)
+	case *[]string:
+		// Repeatable: each use adds a value.
+		fs.Func(o.flag, o.help, func(v string) error {
+			*p = append(*p, v)
+			return nil
+		})
+	default:
+		log.Fatalf("collector option -%s: unsupported type %s", o.flag, o.value.Type())
+	}
+}
+
+// collectorFlags registers the toggle and option flags of every collector.
+// The returned func applies the toggles once fs has been parsed.
+func collectorFlags(fs *flag.FlagSet, specs []CollectorSpec, cfg *Config) func() {
+	cfg.Disabled = make(map[string]bool)
+	cfg.Options = make(map[string]any)
+	toggles := make(map[string]*bool, len(specs))
+
+	for _, s := range specs {
+		toggles[s.Name] = new(bool)
+		verb := "Disable"
+		if !s.Default {
+			verb = "Enable"
+		}
+		fs.BoolVar(toggles[s.Name], s.ToggleFlag(), false, verb+" "+s.Description)
+
+		if s.Options != nil {
+			opts := s.Options()
+			for _, f := range optionFields(opts) {
+				f.bind(fs)
+			}
+			cfg.Options[s.Name] = opts
+		}
+	}
+
+	return func() {
+		for _, s := range specs {
+			cfg.Disabled[s.Name] = s.Default == *toggles[s.Name]
+		}
+	}
+}
+
+// applyCollectorList handles -disabled and -enabled. Names are collectors or
+// the disable tags of their options (e.g. vllm-hist).
+func applyCollectorList(list string, enable bool, specs []CollectorSpec, cfg *Config) {
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if err := setCollectorToggle(name, enable, specs, cfg); err != nil {
+			log.Fatalf("Invalid collector list: %v", err)
+		}
+	}
+}
+
+func setCollectorToggle(name string, enable bool, specs []CollectorSpec, cfg *Config) error {
+	for _, s := range specs {
+		if s.Name == name {
+			cfg.Disabled[name] = !enable
+			return nil
+		}
+		opts, ok := cfg.Options[s.Name]
+		if !ok {
+			continue
+		}
+		for _, f := range optionFields(opts) {
+			if f.disable == name && f.value.Kind() == reflect.Bool {
+				f.value.SetBool(!enable)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("unknown collector %q (known: %s)", name, strings.Join(collectorListNames(specs, cfg), ","))
+}
+
+func collectorListNames(specs []CollectorSpec, cfg *Config) []string {
+	var names []string
+	for _, s := range specs {
+		names = append(names, s.Name)
+		if opts, ok := cfg.Options[s.Name]; ok {
+			for _, f := range optionFields(opts) {
+				if f.disable != "" {
+					names = append(names, f.disable)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// WriteCollectorUsage prints the collector flags section of the help text,
+// generated from the registered specs.
+func WriteCollectorUsage(w io.Writer, specs []CollectorSpec) {
+	var names []string
+	for _, s := range specs {
+		verb := "Disable"
+		if !s.Default {
+			verb = "Enable"
+		}
+		fmt.Fprintf(w, "  %-16s %s %s\n", "-"+s.ToggleFlag(), verb, s.Description)
+		names = append(names, s.Name)
+		if s.Options == nil {
+			continue
+		}
+		for _, f := range optionFields(s.Options()) {
+			if f.disable != "" {
+				names = append(names, f.disable)
+			}
+			writeOptionUsage(w, f)
+		}
+	}
+	fmt.Fprintf(w, "  %-16s %s\n", "-disabled LIST", "Comma-separated collectors to disable")
+	fmt.Fprintf(w, "  %-16s (%s)\n", "", strings.Join(names, ","))
+	fmt.Fprintf(w, "  %-16s %s\n", "-enabled LIST", "Comma-separated collectors to enable")
+}
+
+func writeOptionUsage(w io.Writer, f optionField) {
+	name := "-" + f.flag
+	if f.value.Kind() != reflect.Bool {
+		arg := f.arg
+		if arg == "" {
+			arg = strings.ToUpper(f.value.Kind().String())
+		}
+		name += " " + arg
+	}
+	help := f.help
+	if !f.value.IsZero() {
+		help += fmt.Sprintf(" (default: %v)", f.value.Interface())
+	}
+	lines := wrapWords(help, 58)
+	if len(name) > 16 {
+		fmt.Fprintf(w, "  %s\n", name)
+	} else {
+		fmt.Fprintf(w, "  %-16s %s\n", name, lines[0])
+		lines = lines[1:]
+	}
+	for _, line := range lines {
+		fmt.Fprintf(w, "  %-16s %s\n", "", line)
+	}
+}
+
+func wrapWords(s string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		if line != "" && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	return append(lines, line)
+}
@@ -0,0 +1,215 @@
+package utils
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Condition is one comparison over a flattened field, as written by
+// -flatten: "VllmKvCacheUsagePercent > 0.95". The field may contain *
+// wildcards ("Nvidia*ClocksThrottleReasons != 0") and then holds when any
+// matching field does. delta(FIELD) compares the change since the previous
+// record and rate(FIELD) that change per second, for counters such as
+// "delta(VllmNumPreemptionsTotal) > 0".
+type Condition struct {
+	Field string
+	Func  string // "", "delta" or "rate"
+	Op    string
+	Value float64
+}
+
+// Match is a field that satisfied a condition, with the value compared.
+type Match struct {
+	Field string
+	Value float64
+}
+
+// Expr is a conjunction of conditions joined with &&.
+type Expr []Condition
+
+// Trigger is a labelled expression, given as "LABEL:EXPR" or just "EXPR".
+type Trigger struct {
+	Label string
+	Expr  Expr
+}
+
+var labelRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ParseTrigger parses one -trigger value. Unlabelled triggers are named
+// trigger<n>, n counting from 1.
+func ParseTrigger(s string, n int) (Trigger, error) {
+	t := Trigger{Label: fmt.Sprintf("trigger%d", n)}
+	if label, expr, ok := strings.Cut(s, ":"); ok && labelRe.MatchString(strings.TrimSpace(label)) {
+		t.Label, s = strings.TrimSpace(label), expr
+	}
+	e, err := ParseExpr(s)
+	if err != nil {
+		return Trigger{}, err
+	}
+	t.Expr = e
+	return t, nil
+}
+
+func (t Trigger) String() string { return t.Label + ": " + t.Expr.String() }
+
+var conditionRe = regexp.MustCompile(`^\s*(?:(delta|rate)\(\s*([A-Za-z0-9_*?.\[\]-]+)\s*\)|([A-Za-z0-9_*?.\[\]-]+))\s*(>=|<=|==|!=|>|<)\s*(\S+)\s*$`)
+
+func ParseCondition(s string) (Condition, error) {
+	m := conditionRe.FindStringSubmatch(s)
+	if m == nil {
+		return Condition{}, fmt.Errorf("%q: expected FIELD OP NUMBER, delta(FIELD) OP NUMBER or rate(FIELD) OP NUMBER", s)
+	}
+	c := Condition{Func: m[1], Field: m[2], Op: m[4]}
+	if c.Field == "" {
+		c.Field = m[3]
+	}
+	if _, err := path.Match(c.Field, ""); err != nil {
+		return Condition{}, fmt.Errorf("%q: bad field pattern: %v", s, err)
+	}
+	v, err := strconv.ParseFloat(m[5], 64)
+	if err != nil {
+		return Condition{}, fmt.Errorf("%q: %q is not a number", s, m[5])
+	}
+	c.Value = v
+	return c, nil
+}
+
+func ParseExpr(s string) (Expr, error) {
+	var e Expr
+	for _, part := range strings.Split(s, "&&") {
+		c, err := ParseCondition(part)
+		if err != nil {
+			return nil, err
+		}
+		e = append(e, c)
+	}
+	return e, nil
+}
+
+func (c Condition) String() string {
+	field := c.Field
+	if c.Func != "" {
+		field = c.Func + "(" + field + ")"
+	}
+	return fmt.Sprintf("%s %s %s", field, c.Op, strconv.FormatFloat(c.Value, 'g', -1, 64))
+}
+
+func (e Expr) String() string {
+	parts := make([]string, len(e))
+	for i, c := range e {
+		parts[i] = c.String()
+	}
+	return strings.Join(parts, " && ")
+}
+
+// Fields returns the flattened keys matching the condition's field, sorted.
+func (c Condition) Fields(cur map[string]any) []string {
+	if v, ok := cur[c.Field]; ok && v != nil {
+		return []string{c.Field}
+	}
+	if !strings.ContainsAny(c.Field, "*?[") {
+		return nil
+	}
+	var out []string
+	for k := range cur {
+		if ok, _ := path.Match(c.Field, k); ok {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// FieldValue is what the condition compares for one field: the field
+// itself, or its change since prev for delta and rate. ok is false when the
+// field is not numeric or prev lacks it.
+func (c Condition) FieldValue(field string, cur, prev map[string]any, dt time.Duration) (float64, bool) {
+	v, ok := ToFloat(cur[field])
+	if !ok || c.Func == "" {
+		return v, ok
+	}
+	p, ok := ToFloat(prev[field])
+	if !ok {
+		return 0, false
+	}
+	d := v - p
+	if c.Func == "rate" {
+		if dt <= 0 {
+			return 0, false
+		}
+		d /= dt.Seconds()
+	}
+	return d, true
+}
+
+// Compare applies the condition's operator to v.
+func (c Condition) Compare(v float64) bool {
+	switch c.Op {
+	case ">":
+		return v > c.Value
+	case ">=":
+		return v >= c.Value
+	case "<":
+		return v < c.Value
+	case "<=":
+		return v <= c.Value
+	case "==":
+		return v == c.Value
+	case "!=":
+		return v != c.Value
+	}
+	return false
+}
+
+// Eval returns every field satisfying the condition in the flattened record
+// cur. prev is the previous record and dt the time between the two.
+func (c Condition) Eval(cur, prev map[string]any, dt time.Duration) []Match {
+	var out []Match
+	for _, f := range c.Fields(cur) {
+		if v, ok := c.FieldValue(f, cur, prev, dt); ok && c.Compare(v) {
+			out = append(out, Match{Field: f, Value: v})
+		}
+	}
+	return out
+}
+
+// Eval reports whether every condition holds, with the matching fields.
+func (e Expr) Eval(cur, prev map[string]any, dt time.Duration) ([]Match, bool) {
+	var out []Match
+	for _, c := range e {
+		m := c.Eval(cur, prev, dt)
+		if len(m) == 0 {
+			return nil, false
+		}
+		out = append(out, m...)
+	}
+	return out, true
+}
+
+// ToFloat converts a flattened value to float64. Bools count as 0 or 1.
+func ToFloat(v any) (float64, bool) {
+	if v == nil {
+		return 0, false
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.Bool:
+		if rv.Bool() {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
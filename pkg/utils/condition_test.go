@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTrigger(t *testing.T) {
+	tests := []struct {
+		in    string
+		label string
+		want  string // the expression as printed; "": an error
+	}{
+		{"hot: GpuTemp > 80", "hot", "GpuTemp > 80"},
+		{"GpuTemp>=80.5", "trigger3", "GpuTemp >= 80.5"},
+		{"throttle:Nvidia*ClocksThrottleReasons != 0 && delta(VllmNumPreemptionsTotal) > 0", "throttle",
+			"Nvidia*ClocksThrottleReasons != 0 && delta(VllmNumPreemptionsTotal) > 0"},
+		{"rate( VllmTokens ) < -1e3", "trigger3", "rate(VllmTokens) < -1000"},
+		// Not a label: the expression is the whole value.
+		{"a b: X > 1", "", ""},
+		{"GpuTemp >", "", ""},
+		{"GpuTemp > hot", "", ""},
+		{"GpuTemp = 1", "", ""},
+		{"delta(GpuTemp > 1", "", ""},
+		{"max(GpuTemp) > 1", "", ""},
+		{"Gpu[ > 1", "", ""},
+		{"GpuTemp > 1 &&", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseTrigger(tt.in, 3)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("ParseTrigger = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Label != tt.label || got.Expr.String() != tt.want {
+				t.Errorf("ParseTrigger = %q, %q; want %q, %q", got.Label, got.Expr, tt.label, tt.want)
+			}
+		})
+	}
+}
+
+func TestExprEval(t *testing.T) {
+	tests := []struct {
+		name      string
+		expr      string
+		cur, prev map[string]any
+		dt        time.Duration
+		want      []Match // nil: does not hold
+	}{
+		{"above", "GpuTemp > 80", map[string]any{"GpuTemp": 90}, nil, 0, []Match{{"GpuTemp", 90}}},
+		{"at the threshold", "GpuTemp > 80", map[string]any{"GpuTemp": 80.0}, nil, 0, nil},
+		{"missing", "GpuTemp > 80", map[string]any{"GpuUtil": 90}, nil, 0, nil},
+		{"not a number", "VllmModel == 0", map[string]any{"VllmModel": "m"}, nil, 0, nil},
+		{"bool", "VllmAvailable == 0", map[string]any{"VllmAvailable": false}, nil, 0, []Match{{"VllmAvailable", 0}}},
+		{"glob matches any", "Nvidia*Util >= 90",
+			map[string]any{"Nvidia0Util": uint32(10), "Nvidia1Util": uint32(95), "Nvidia2Util": uint32(90)}, nil, 0,
+			[]Match{{"Nvidia1Util", 95}, {"Nvidia2Util", 90}}},
+		{"glob matches none", "Nvidia*Util >= 90", map[string]any{"Nvidia0Util": 10}, nil, 0, nil},
+		{"delta", "delta(Preempt) > 0", map[string]any{"Preempt": 7}, map[string]any{"Preempt": 5}, time.Second,
+			[]Match{{"Preempt", 2}}},
+		{"delta unchanged", "delta(Preempt) > 0", map[string]any{"Preempt": 7}, map[string]any{"Preempt": 7}, time.Second, nil},
+		{"delta without a previous record", "delta(Preempt) > 0", map[string]any{"Preempt": 7}, nil, 0, nil},
+		{"rate", "rate(Tokens) > 90", map[string]any{"Tokens": 300}, map[string]any{"Tokens": 100}, 2 * time.Second,
+			[]Match{{"Tokens", 100}}},
+		{"rate under", "rate(Tokens) > 90", map[string]any{"Tokens": 300}, map[string]any{"Tokens": 100}, 4 * time.Second, nil},
+		{"rate without elapsed time", "rate(Tokens) > 90", map[string]any{"Tokens": 300}, map[string]any{"Tokens": 100}, 0, nil},
+		{"all hold", "GpuTemp > 80 && delta(Preempt) > 0", map[string]any{"GpuTemp": 90, "Preempt": 3},
+			map[string]any{"Preempt": 1}, time.Second, []Match{{"GpuTemp", 90}, {"Preempt", 2}}},
+		{"one does not", "GpuTemp > 80 && delta(Preempt) > 0", map[string]any{"GpuTemp": 90, "Preempt": 1},
+			map[string]any{"Preempt": 1}, time.Second, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := ParseExpr(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := e.Eval(tt.cur, tt.prev, tt.dt)
+			if ok != (tt.want != nil) || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Eval = %v, %v; want %v", got, ok, tt.want)
+			}
+		})
+	}
+}
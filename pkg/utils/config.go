@@ -4,39 +4,85 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 const (
-	DefaultVLLMEndpoint = "http://localhost:8000/metrics"
 	DefaultMode         = "continuous"
 	FieldSeparatorColon = ":"
 	FieldSeparatorSpace = " "
 	UnavailableValue    = "unavailable"
 )
 
+// Stale policies decide what a continuous tick does with a collector whose
+// poller has not refreshed since the previous tick.
+const (
+	StaleSkip = "skip" // write only sections refreshed since the last tick
+	StaleFlag = "flag" // write every section, marking old ones Stale in Meta
+	StaleDrop = "drop" // write every section except those older than StaleAfter
+)
+
+// Queue policies decide what a tick does when the record queue in front of
+// the writer is full.
+const (
+	QueueBlock      = "block"       // wait for the writer
+	QueueDropNewest = "drop-newest" // discard the new record
+	QueueDropOldest = "drop-oldest" // discard the oldest queued record
+)
+
 type Config struct {
-	Mode                  string
-	UUID                  string
-	OutputDir             string
-	Flatten               bool
-	Interval              int
-	Debug                 bool
-	DisableVM             bool
-	DisableContainer      bool
-	DisableProcess        bool
-	DisableNvidia         bool
-	DisableVLLM           bool
-	DisableVLLMHistograms bool
-	VLLMEndpoint          string
-	Pprof                 string
-	ServerPort            int
+	Mode             string
+	UUID             string
+	OutputDir        string
+	Flatten          bool
+	Interval         int
+	Intervals        map[string]int
+	Adaptive         bool
+	Align            bool
+	StalePolicy      string
+	StaleAfter       int
+	PollTimeout      int
+	DegradedAfter    int
+	InitRetry        int
+	QueueSize        int
+	QueuePolicy      string
+	Triggers         []Trigger
+	TriggerPre       int
+	TriggerPost      int
+	TriggerOnly      bool
+	Alerts           []AlertRule
+	AlertWebhook     string
+	Duration         int
+	MaxSamples       int
+	MaxBytes         int64
+	IdleStop         int
+	MarkerFIFO       string
+	MinFree          int64
+	RetainAge        time.Duration
+	RetainBytes      int64
+	RetainRuns       int
+	Debug            bool
+	Disabled         map[string]bool
+	Options          map[string]any
+	Pprof            string
+	ServerPort       int
+	Bind             string
+	TLSCert          string
+	TLSKey           string
+	TLSClientCA      string
+	Token            string
+	ReadToken        string
+	AllowUnsafeSpecs bool
 }
 
-func ParseArgs(args []string) *Config {
+func ParseArgs(args []string, collectors []CollectorSpec) *Config {
 	cfg := &Config{}
 	cfg.Mode, args = parseMode(args)
 	fs := flag.NewFlagSet("InferenceProfiler", flag.ExitOnError)
@@ -45,47 +91,201 @@ func ParseArgs(args []string) *Config {
 	fs.StringVar(&cfg.OutputDir, "output", "", "Output directory (default: stdout)")
 	fs.BoolVar(&cfg.Flatten, "flatten", false, "Flatten nested structs to top-level keys")
 	fs.IntVar(&cfg.Interval, "interval", 1000, "Collection interval in milliseconds")
-	fs.BoolVar(&cfg.DisableVM, "no-vm", false, "Disable VM metrics")
-	fs.BoolVar(&cfg.DisableContainer, "no-container", false, "Disable container metrics")
-	fs.BoolVar(&cfg.DisableProcess, "no-procs", false, "Disable process metrics")
-	fs.BoolVar(&cfg.DisableNvidia, "no-nvidia", false, "Disable NVIDIA GPU metrics")
-	fs.BoolVar(&cfg.DisableVLLM, "no-vllm", false, "Disable vLLM metrics")
-	fs.BoolVar(&cfg.DisableVLLMHistograms, "no-vllm-hist", false, "Disable vLLM histogram collection")
-	fs.StringVar(&cfg.VLLMEndpoint, "vllm-endpoint", DefaultVLLMEndpoint, "vLLM metrics endpoint")
+	var intervals string
+	fs.StringVar(&intervals, "intervals", "", "Per-collector intervals in ms, e.g. process=5000,nvidia=100,nvidia.processes=1000")
+	fs.IntVar(&cfg.QueueSize, "queue", 64, "Records buffered between the tick loop and the writer")
+	fs.StringVar(&cfg.QueuePolicy, "queue-policy", QueueBlock, "When the record queue is full: block|drop-newest|drop-oldest")
+	fs.BoolVar(&cfg.Align, "align", false, "Align polls and ticks to wall-clock multiples of the interval")
+	fs.BoolVar(&cfg.Adaptive, "adaptive", false, "Slow down collectors whose poll time approaches their interval")
+	fs.StringVar(&cfg.StalePolicy, "stale-policy", StaleSkip, "Handling of sections not refreshed since the last tick: skip|flag|drop")
+	fs.IntVar(&cfg.StaleAfter, "stale-after", 3, "Intervals after which a section counts as stale")
+	fs.IntVar(&cfg.PollTimeout, "poll-timeout", 0, "Per-poll deadline in milliseconds (0 = 5x the collector's interval)")
+	fs.IntVar(&cfg.DegradedAfter, "degraded-after", 3, "Consecutive failed polls before a collector is marked degraded")
+	fs.IntVar(&cfg.InitRetry, "init-retry", 30000, "Retry failed collector init every N milliseconds (0 = never)")
+	fs.Func("trigger", "Capture a window around records matching [LABEL:]EXPR to its own file (repeatable)", func(s string) error {
+		t, err := ParseTrigger(s, len(cfg.Triggers)+1)
+		if err != nil {
+			return err
+		}
+		cfg.Triggers = append(cfg.Triggers, t)
+		return nil
+	})
+	fs.IntVar(&cfg.TriggerPre, "trigger-pre", 30, "Seconds of history written before a trigger")
+	fs.IntVar(&cfg.TriggerPost, "trigger-post", 30, "Seconds written after the last trigger of a capture")
+	fs.BoolVar(&cfg.TriggerOnly, "trigger-only", false, "Write only triggered captures, not the continuous stream")
+	var alerts string
+	fs.StringVar(&alerts, "alerts", "", "JSON file of alert rules evaluated on every record")
+	fs.StringVar(&cfg.AlertWebhook, "alert-webhook", "", "POST alert events to this URL (overrides the rules file's webhook)")
+	fs.IntVar(&cfg.Duration, "duration", 0, "Stop the run after N seconds (0 = no limit)")
+	fs.IntVar(&cfg.MaxSamples, "max-samples", 0, "Stop the run after N records (0 = no limit)")
+	fs.Func("max-bytes", "Stop the run once its output reaches this size, e.g. 500M or 2G (0 = no limit)", func(s string) error {
+		n, err := ParseSize(s)
+		cfg.MaxBytes = n
+		return err
+	})
+	fs.IntVar(&cfg.IdleStop, "idle-stop", 0, "Stop the run once vLLM has had no running or waiting requests for N seconds (0 = never)")
+	fs.Func("min-free", "Refuse to start, or stop, a run when the output filesystem has less free space than this, e.g. 5G", func(s string) error {
+		n, err := ParseSize(s)
+		cfg.MinFree = n
+		return err
+	})
+	fs.DurationVar(&cfg.RetainAge, "retain-age", 0, "Delete runs older than this from the output directory, e.g. 168h (server mode, 0 = keep)")
+	fs.Func("retain-bytes", "Delete the oldest runs while the output directory holds more than this, e.g. 50G (server mode)", func(s string) error {
+		n, err := ParseSize(s)
+		cfg.RetainBytes = n
+		return err
+	})
+	fs.IntVar(&cfg.RetainRuns, "retain-runs", 0, "Keep only the newest N runs in the output directory (server mode, 0 = all)")
+	fs.StringVar(&cfg.MarkerFIFO, "marker-fifo", "", "Create a FIFO at this path; each line written to it adds a marker to the run (continuous mode)")
+	applyToggles := collectorFlags(fs, collectors, cfg)
 	fs.StringVar(&cfg.Pprof, "pprof", "", "Enable pprof profiling on the given address")
 	fs.IntVar(&cfg.ServerPort, "port", 8888, "HTTP port (server mode)")
+	fs.StringVar(&cfg.Bind, "bind", "0.0.0.0", "Address to listen on (server mode)")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", "", "Serve HTTPS with this PEM certificate (server mode)")
+	fs.StringVar(&cfg.TLSKey, "tls-key", "", "PEM private key for -tls-cert")
+	fs.StringVar(&cfg.TLSClientCA, "tls-client-ca", "", "Require client certificates signed by this PEM CA (mTLS)")
+	fs.StringVar(&cfg.Token, "token", "", "Bearer token granting read and control access (server mode)")
+	fs.StringVar(&cfg.ReadToken, "read-token", "", "Bearer token granting read-only access (server mode)")
+	fs.BoolVar(&cfg.AllowUnsafeSpecs, "allow-unsafe-specs", false, "Let run specs set exec options, NVML fakes and alert_webhook (server mode)")
 	fs.BoolVar(&cfg.Debug, "debug", false, "Enable verbose debug logging")
 
-	var disabled string
-	fs.StringVar(&disabled, "disabled", "", "Comma-separated list of collectors to disable (vm,container,process,nvidia,vllm,vllm-hist)")
+	var disabled, enabled string
+	fs.StringVar(&disabled, "disabled", "", "Comma-separated list of collectors to disable")
+	fs.StringVar(&enabled, "enabled", "", "Comma-separated list of collectors to enable")
 
 	if err := fs.Parse(args); err != nil {
 		log.Fatalf("Failed to parse args: %v", err)
 	}
 
-	applyEnv(fs)
+	ApplyEnv(fs)
 
 	if cfg.Debug {
 		SetDebug(true)
 	}
 
-	if cfg.Interval <= 0 {
-		log.Fatalf("Invalid interval: %d", cfg.Interval)
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
 	}
 
-	applyDisabled(disabled, cfg)
+	if alerts != "" {
+		rules, webhook, err := LoadAlerts(alerts)
+		if err != nil {
+			log.Fatalf("Invalid alerts: %v", err)
+		}
+		cfg.Alerts = rules
+		if cfg.AlertWebhook == "" {
+			cfg.AlertWebhook = webhook
+		}
+	}
 
-	Debugf("config: mode=%s uuid=%s interval=%dms output=%q flatten=%v port=%d",
-		cfg.Mode, cfg.UUID, cfg.Interval, cfg.OutputDir, cfg.Flatten, cfg.ServerPort)
-	Debugf("config: disabled vm=%v container=%v process=%v nvidia=%v vllm=%v vllm-hist=%v",
-		cfg.DisableVM, cfg.DisableContainer, cfg.DisableProcess,
-		cfg.DisableNvidia, cfg.DisableVLLM, cfg.DisableVLLMHistograms)
-	Debugf("config: vllm-endpoint=%s pprof=%q", cfg.VLLMEndpoint, cfg.Pprof)
+	applyToggles()
+	applyCollectorList(disabled, false, collectors, cfg)
+	applyCollectorList(enabled, true, collectors, cfg)
+
+	var err error
+	if cfg.Intervals, err = ParseIntervals(intervals, collectors); err != nil {
+		log.Fatalf("Invalid intervals: %v", err)
+	}
+
+	Debugf("config: mode=%s uuid=%s interval=%dms output=%q flatten=%v bind=%s port=%d",
+		cfg.Mode, cfg.UUID, cfg.Interval, cfg.OutputDir, cfg.Flatten, cfg.Bind, cfg.ServerPort)
+	Debugf("config: min-free=%d retain-age=%v retain-bytes=%d retain-runs=%d",
+		cfg.MinFree, cfg.RetainAge, cfg.RetainBytes, cfg.RetainRuns)
+	Debugf("config: tls=%v mtls=%v token=%v read-token=%v",
+		cfg.TLSCert != "", cfg.TLSClientCA != "", cfg.Token != "", cfg.ReadToken != "")
+	Debugf("config: intervals=%v adaptive=%v align=%v stale-policy=%s stale-after=%d",
+		cfg.Intervals, cfg.Adaptive, cfg.Align, cfg.StalePolicy, cfg.StaleAfter)
+	Debugf("config: poll-timeout=%dms degraded-after=%d init-retry=%dms queue=%d queue-policy=%s",
+		cfg.PollTimeout, cfg.DegradedAfter, cfg.InitRetry, cfg.QueueSize, cfg.QueuePolicy)
+	Debugf("config: duration=%ds max-samples=%d max-bytes=%d idle-stop=%ds",
+		cfg.Duration, cfg.MaxSamples, cfg.MaxBytes, cfg.IdleStop)
+	Debugf("config: disabled=%v pprof=%q", cfg.Disabled, cfg.Pprof)
+	for _, r := range cfg.Alerts {
+		Debugf("config: alert %s: %s for %v (%s)", r.Name, r.Expr, r.For, r.Severity)
+	}
+	for _, t := range cfg.Triggers {
+		Debugf("config: trigger %s (pre=%ds post=%ds only=%v)", t, cfg.TriggerPre, cfg.TriggerPost, cfg.TriggerOnly)
+	}
+	for name, opts := range cfg.Options {
+		Debugf("config: %s options %+v", name, opts)
+	}
 
 	return cfg
 }
 
-func applyEnv(fs *flag.FlagSet) {
+// CheckUUID rejects run ids that would reach outside the output directory
+// or match other runs' files once joined into a path or glob pattern.
+func CheckUUID(uuid string) error {
+	if uuid == "" || uuid == "." || uuid == ".." || strings.ContainsAny(uuid, `/\*?[`) {
+		return fmt.Errorf("invalid uuid: %q", uuid)
+	}
+	return nil
+}
+
+// Validate checks the settings ParseArgs and run specs can get wrong.
+func (cfg *Config) Validate() error {
+	if err := CheckUUID(cfg.UUID); err != nil {
+		return err
+	}
+	if cfg.Interval <= 0 {
+		return fmt.Errorf("invalid interval: %d", cfg.Interval)
+	}
+	switch cfg.StalePolicy {
+	case StaleSkip, StaleFlag, StaleDrop:
+	default:
+		return fmt.Errorf("invalid stale policy: %q (want skip, flag or drop)", cfg.StalePolicy)
+	}
+	if cfg.StaleAfter <= 0 {
+		return fmt.Errorf("invalid stale-after: %d", cfg.StaleAfter)
+	}
+	if cfg.PollTimeout < 0 {
+		return fmt.Errorf("invalid poll-timeout: %d", cfg.PollTimeout)
+	}
+	if cfg.DegradedAfter <= 0 {
+		return fmt.Errorf("invalid degraded-after: %d", cfg.DegradedAfter)
+	}
+	if cfg.InitRetry < 0 {
+		return fmt.Errorf("invalid init-retry: %d", cfg.InitRetry)
+	}
+	if cfg.QueueSize <= 0 {
+		return fmt.Errorf("invalid queue size: %d", cfg.QueueSize)
+	}
+	switch cfg.QueuePolicy {
+	case QueueBlock, QueueDropNewest, QueueDropOldest:
+	default:
+		return fmt.Errorf("invalid queue policy: %q (want block, drop-newest or drop-oldest)", cfg.QueuePolicy)
+	}
+	if cfg.TriggerPre < 0 || cfg.TriggerPost < 0 {
+		return fmt.Errorf("invalid trigger window: pre=%d post=%d", cfg.TriggerPre, cfg.TriggerPost)
+	}
+	if len(cfg.Triggers) > 0 && cfg.OutputDir == "" {
+		return fmt.Errorf("triggers need an output directory for the capture files")
+	}
+	if cfg.TriggerOnly && len(cfg.Triggers) == 0 {
+		return fmt.Errorf("trigger-only needs at least one trigger")
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return fmt.Errorf("tls-cert and tls-key must be given together")
+	}
+	if cfg.TLSClientCA != "" && cfg.TLSCert == "" {
+		return fmt.Errorf("tls-client-ca needs tls-cert and tls-key")
+	}
+	if cfg.Token != "" && cfg.Token == cfg.ReadToken {
+		return fmt.Errorf("token and read-token must differ")
+	}
+	if cfg.RetainAge < 0 || cfg.RetainRuns < 0 {
+		return fmt.Errorf("invalid retention: retain-age=%v retain-runs=%d", cfg.RetainAge, cfg.RetainRuns)
+	}
+	if cfg.Duration < 0 || cfg.MaxSamples < 0 || cfg.MaxBytes < 0 || cfg.IdleStop < 0 {
+		return fmt.Errorf("invalid run limits: duration=%d max-samples=%d max-bytes=%d idle-stop=%d",
+			cfg.Duration, cfg.MaxSamples, cfg.MaxBytes, cfg.IdleStop)
+	}
+	return nil
+}
+
+// ApplyEnv sets every flag of fs not given on the command line from its
+// INFPRO_<NAME> environment variable, if set.
+func ApplyEnv(fs *flag.FlagSet) {
 	set := make(map[string]bool)
 	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
 
@@ -102,29 +302,59 @@ func applyEnv(fs *flag.FlagSet) {
 	})
 }
 
-func applyDisabled(disabled string, cfg *Config) {
-	if disabled == "" {
-		return
-	}
-	lookup := map[string]*bool{
-		"vm":        &cfg.DisableVM,
-		"container": &cfg.DisableContainer,
-		"process":   &cfg.DisableProcess,
-		"nvidia":    &cfg.DisableNvidia,
-		"vllm":      &cfg.DisableVLLM,
-		"vllm-hist": &cfg.DisableVLLMHistograms,
-	}
-	for _, name := range strings.Split(disabled, ",") {
-		name = strings.TrimSpace(name)
-		if name == "" {
+// ParseIntervals parses "name=ms,..." where name is a registered collector or
+// "collector.domain" for collectors that poll domains at independent rates.
+func ParseIntervals(spec string, collectors []CollectorSpec) (map[string]int, error) {
+	out := make(map[string]int)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
 			continue
 		}
-		ptr, ok := lookup[name]
+		name, value, ok := strings.Cut(item, "=")
 		if !ok {
-			log.Fatalf("Invalid disabled collector: %s", name)
+			return nil, fmt.Errorf("%q: expected name=ms", item)
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		ms, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%q: interval must be a positive number of milliseconds", item)
+		}
+		if err := checkInterval(name, ms, collectors); err != nil {
+			return nil, fmt.Errorf("%q: %w", item, err)
+		}
+		out[name] = ms
+	}
+	return out, nil
+}
+
+// ParseSize parses a byte count with an optional K, M, G or T suffix (powers
+// of 1024, optionally followed by B), e.g. "500M" or "2GB".
+func ParseSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	shift := 0
+	if n := len(num); n > 0 {
+		if i := strings.IndexByte("KMGT", num[n-1]); i >= 0 {
+			shift = 10 * (i + 1)
+			num = num[:n-1]
 		}
-		*ptr = true
 	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n << shift, nil
+}
+
+func checkInterval(name string, ms int, collectors []CollectorSpec) error {
+	collector, _, _ := strings.Cut(name, ".")
+	if !slices.ContainsFunc(collectors, func(s CollectorSpec) bool { return s.Name == collector }) {
+		return fmt.Errorf("unknown collector %q", collector)
+	}
+	if ms <= 0 {
+		return fmt.Errorf("interval must be a positive number of milliseconds")
+	}
+	return nil
 }
 
 func parseMode(args []string) (string, []string) {
@@ -139,7 +369,7 @@ func parseMode(args []string) (string, []string) {
 	case "ser", "server":
 		return "server", args[1:]
 	default:
-		log.Fatalf("Unknown command: %q (must be continuous|c, snapshot|s, server|ser)", args[0])
+		log.Fatalf("Unknown command: %q (must be continuous|c, snapshot|s, server|ser, controller|ctl)", args[0])
 		return "", nil
 	}
 }
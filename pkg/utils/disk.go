@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"syscall"
+)
+
+// ErrLowDisk is returned by CheckFreeSpace when the output filesystem is
+// below -min-free.
+var ErrLowDisk = errors.New("not enough free disk space")
+
+// FreeSpace returns the bytes available to unprivileged users on the
+// filesystem holding path. A path that does not exist yet is measured at
+// its nearest existing parent.
+func FreeSpace(path string) (int64, error) {
+	var st syscall.Statfs_t
+	for {
+		err := syscall.Statfs(path, &st)
+		if err == nil {
+			return int64(st.Bavail) * int64(st.Bsize), nil
+		}
+		parent := filepath.Dir(path)
+		if !errors.Is(err, syscall.ENOENT) || parent == path {
+			return 0, err
+		}
+		path = parent
+	}
+}
+
+// CheckFreeSpace returns ErrLowDisk, with the numbers, when dir has less
+// than min bytes free. It passes when min is 0 or dir is "" (stdout).
+func CheckFreeSpace(dir string, min int64) error {
+	if min <= 0 || dir == "" {
+		return nil
+	}
+	free, err := FreeSpace(dir)
+	if err != nil {
+		return fmt.Errorf("checking free space on %s: %w", dir, err)
+	}
+	if free < min {
+		return fmt.Errorf("%w: %s free on %s, below -min-free %s", ErrLowDisk, FormatSize(free), dir, FormatSize(min))
+	}
+	return nil
+}
+
+// FormatSize formats a byte count the way ParseSize reads it, e.g. "1.5G".
+func FormatSize(n int64) string {
+	const units = "KMGT"
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	v, i := float64(n)/1024, 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%c", v, units[i])
+}
@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+)
+
+// RunSpec is the body of PUT /collect. Every field is optional and overrides
+// the server's startup flag of the same name for one run. Options holds
+// collector options keyed by collector, using the JSON names of their
+// fields, e.g. {"vllm": {"endpoint": "http://127.0.0.1:8001/metrics"}}.
+//
+// Options other than those tagged `spec:"safe"` and AlertWebhook may only
+// repeat the server's own values unless it runs with -allow-unsafe-specs.
+//
+// Config.Spec returns a run's effective config in the same shape, with every
+// field set, so it can be replayed as is.
+type RunSpec struct {
+	UUID          string                     `json:"uuid,omitempty"`
+	Interval      *int                       `json:"interval,omitempty"`
+	Intervals     map[string]int             `json:"intervals,omitempty"`
+	Flatten       *bool                      `json:"flatten,omitempty"`
+	Adaptive      *bool                      `json:"adaptive,omitempty"`
+	Align         *bool                      `json:"align,omitempty"`
+	StalePolicy   *string                    `json:"stale_policy,omitempty"`
+	StaleAfter    *int                       `json:"stale_after,omitempty"`
+	PollTimeout   *int                       `json:"poll_timeout,omitempty"`
+	DegradedAfter *int                       `json:"degraded_after,omitempty"`
+	QueueSize     *int                       `json:"queue,omitempty"`
+	QueuePolicy   *string                    `json:"queue_policy,omitempty"`
+	Disabled      []string                   `json:"disabled,omitempty"`
+	Enabled       []string                   `json:"enabled,omitempty"`
+	Options       map[string]json.RawMessage `json:"options,omitempty"`
+	Triggers      []string                   `json:"triggers,omitempty"`
+	TriggerPre    *int                       `json:"trigger_pre,omitempty"`
+	TriggerPost   *int                       `json:"trigger_post,omitempty"`
+	TriggerOnly   *bool                      `json:"trigger_only,omitempty"`
+	Alerts        []AlertSpec                `json:"alerts,omitempty"`
+	AlertWebhook  *string                    `json:"alert_webhook,omitempty"`
+	Duration      *int                       `json:"duration,omitempty"`
+	MaxSamples    *int                       `json:"max_samples,omitempty"`
+	MaxBytes      *int64                     `json:"max_bytes,omitempty"`
+	IdleStop      *int                       `json:"idle_stop,omitempty"`
+}
+
+// Apply returns a copy of cfg with spec's overrides, or an error naming the
+// first invalid one. cfg is not modified.
+func (cfg *Config) Apply(spec RunSpec, collectors []CollectorSpec) (*Config, error) {
+	out := *cfg
+	out.Disabled = maps.Clone(cfg.Disabled)
+	out.Intervals = maps.Clone(cfg.Intervals)
+	out.Options = make(map[string]any, len(cfg.Options))
+	for name, opts := range cfg.Options {
+		v := reflect.New(reflect.TypeOf(opts).Elem())
+		v.Elem().Set(reflect.ValueOf(opts).Elem())
+		out.Options[name] = v.Interface()
+	}
+
+	if spec.UUID != "" {
+		out.UUID = spec.UUID
+	}
+	if !cfg.AllowUnsafeSpecs && spec.AlertWebhook != nil && *spec.AlertWebhook != cfg.AlertWebhook {
+		return nil, errors.New("alert_webhook: not settable by a run spec without -allow-unsafe-specs")
+	}
+	override(&out.Interval, spec.Interval)
+	override(&out.Flatten, spec.Flatten)
+	override(&out.Adaptive, spec.Adaptive)
+	override(&out.Align, spec.Align)
+	override(&out.StalePolicy, spec.StalePolicy)
+	override(&out.StaleAfter, spec.StaleAfter)
+	override(&out.PollTimeout, spec.PollTimeout)
+	override(&out.DegradedAfter, spec.DegradedAfter)
+	override(&out.QueueSize, spec.QueueSize)
+	override(&out.QueuePolicy, spec.QueuePolicy)
+	override(&out.TriggerPre, spec.TriggerPre)
+	override(&out.TriggerPost, spec.TriggerPost)
+	override(&out.TriggerOnly, spec.TriggerOnly)
+	override(&out.AlertWebhook, spec.AlertWebhook)
+	override(&out.Duration, spec.Duration)
+	override(&out.MaxSamples, spec.MaxSamples)
+	override(&out.MaxBytes, spec.MaxBytes)
+	override(&out.IdleStop, spec.IdleStop)
+
+	if spec.Intervals != nil {
+		out.Intervals = make(map[string]int, len(spec.Intervals))
+		for name, ms := range spec.Intervals {
+			if err := checkInterval(name, ms, collectors); err != nil {
+				return nil, fmt.Errorf("intervals: %q: %w", name, err)
+			}
+			out.Intervals[name] = ms
+		}
+	}
+
+	// Options first, so a disable tag in the lists below wins over them.
+	for name, raw := range spec.Options {
+		opts, ok := out.Options[name]
+		if !ok {
+			return nil, fmt.Errorf("options: no collector %q with options", name)
+		}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(opts); err != nil {
+			return nil, fmt.Errorf("options: %s: %w", name, err)
+		}
+		if cfg.AllowUnsafeSpecs {
+			continue
+		}
+		if field := unsafeChange(cfg.Options[name], opts); field != "" {
+			return nil, fmt.Errorf("options: %s.%s: not settable by a run spec without -allow-unsafe-specs", name, field)
+		}
+	}
+	for _, name := range spec.Disabled {
+		if err := setCollectorToggle(name, false, collectors, &out); err != nil {
+			return nil, fmt.Errorf("disabled: %w", err)
+		}
+	}
+	for _, name := range spec.Enabled {
+		if err := setCollectorToggle(name, true, collectors, &out); err != nil {
+			return nil, fmt.Errorf("enabled: %w", err)
+		}
+	}
+
+	if spec.Triggers != nil {
+		out.Triggers = nil
+		for i, s := range spec.Triggers {
+			t, err := ParseTrigger(s, i+1)
+			if err != nil {
+				return nil, fmt.Errorf("triggers: %w", err)
+			}
+			out.Triggers = append(out.Triggers, t)
+		}
+	}
+	if spec.Alerts != nil {
+		rules, err := ParseAlerts(spec.Alerts)
+		if err != nil {
+			return nil, fmt.Errorf("alerts: %w", err)
+		}
+		out.Alerts = rules
+	}
+
+	if err := out.Validate(); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func override[T any](dst *T, v *T) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+// Spec returns cfg as a RunSpec with every field set.
+func (cfg *Config) Spec() RunSpec {
+	spec := RunSpec{
+		UUID:          cfg.UUID,
+		Interval:      &cfg.Interval,
+		Intervals:     cfg.Intervals,
+		Flatten:       &cfg.Flatten,
+		Adaptive:      &cfg.Adaptive,
+		Align:         &cfg.Align,
+		StalePolicy:   &cfg.StalePolicy,
+		StaleAfter:    &cfg.StaleAfter,
+		PollTimeout:   &cfg.PollTimeout,
+		DegradedAfter: &cfg.DegradedAfter,
+		QueueSize:     &cfg.QueueSize,
+		QueuePolicy:   &cfg.QueuePolicy,
+		Disabled:      []string{},
+		Enabled:       []string{},
+		Options:       make(map[string]json.RawMessage, len(cfg.Options)),
+		Triggers:      []string{},
+		TriggerPre:    &cfg.TriggerPre,
+		TriggerPost:   &cfg.TriggerPost,
+		TriggerOnly:   &cfg.TriggerOnly,
+		Alerts:        []AlertSpec{},
+		AlertWebhook:  &cfg.AlertWebhook,
+		Duration:      &cfg.Duration,
+		MaxSamples:    &cfg.MaxSamples,
+		MaxBytes:      &cfg.MaxBytes,
+		IdleStop:      &cfg.IdleStop,
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Disabled)) {
+		if cfg.Disabled[name] {
+			spec.Disabled = append(spec.Disabled, name)
+		} else {
+			spec.Enabled = append(spec.Enabled, name)
+		}
+	}
+	for name, opts := range cfg.Options {
+		if data, err := json.Marshal(opts); err == nil {
+			spec.Options[name] = data
+		}
+	}
+	for _, t := range cfg.Triggers {
+		spec.Triggers = append(spec.Triggers, t.String())
+	}
+	for _, r := range cfg.Alerts {
+		spec.Alerts = append(spec.Alerts, r.Spec())
+	}
+	return spec
+}
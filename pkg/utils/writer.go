@@ -4,10 +4,12 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 )
 
 type Writer struct {
@@ -21,6 +23,7 @@ type Writer struct {
 	staticData    map[string]any
 	dynamicData   map[string]any
 	staticFlushed bool
+	size          atomic.Int64
 }
 
 func NewWriter(cfg *Config) *Writer {
@@ -31,30 +34,64 @@ func NewWriter(cfg *Config) *Writer {
 	}
 
 	if cfg.OutputDir != "" {
-		Debugf("writer: creating output dir=%q uuid=%q", cfg.OutputDir, cfg.UUID)
-		if err := os.MkdirAll(cfg.OutputDir, 0755); err != nil {
-			log.Printf("writer: failed to create output dir %s: %v", cfg.OutputDir, err)
-		}
-
 		path := filepath.Join(cfg.OutputDir, fmt.Sprintf("%s.jsonl", cfg.UUID))
-		f, err := os.Create(path)
-		if err != nil {
-			log.Printf("writer: failed to create %s: %v. Falling back to stdout.", path, err)
+		if err := w.create(path); err != nil {
+			log.Printf("writer: %v. Falling back to stdout.", err)
 			w.buf = bufio.NewWriter(os.Stdout)
-		} else {
-			w.file = f
-			w.buf = bufio.NewWriter(f)
-			log.Printf("writer: output %s", path)
 		}
 	} else {
 		Debugf("writer: no output dir, writing to stdout")
 		w.buf = bufio.NewWriter(os.Stdout)
 	}
 
-	w.enc = json.NewEncoder(w.buf)
+	w.enc = json.NewEncoder(sizeCounter{w.buf, &w.size})
 	return w
 }
 
+// NewFileWriter writes to path instead of the run's default output. Records
+// have the same format as NewWriter's.
+func NewFileWriter(cfg *Config, path string) (*Writer, error) {
+	w := &Writer{
+		cfg:         cfg,
+		staticData:  make(map[string]any),
+		dynamicData: make(map[string]any),
+	}
+	if err := w.create(path); err != nil {
+		return nil, err
+	}
+	w.enc = json.NewEncoder(sizeCounter{w.buf, &w.size})
+	return w, nil
+}
+
+// Size returns the bytes written so far, including buffered ones.
+func (w *Writer) Size() int64 { return w.size.Load() }
+
+type sizeCounter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c sizeCounter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func (w *Writer) create(path string) error {
+	Debugf("writer: creating output dir=%q", filepath.Dir(path))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Printf("writer: failed to create output dir %s: %v", filepath.Dir(path), err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	w.file = f
+	w.buf = bufio.NewWriter(f)
+	log.Printf("writer: output %s", path)
+	return nil
+}
+
 func (w *Writer) Static(name string, data any) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -113,6 +150,7 @@ func (w *Writer) Flush() error {
 func (w *Writer) writeStatic() {
 	static := make(map[string]any)
 	static["uuid"] = w.cfg.UUID
+	static["config"] = plainJSON(w.cfg.Spec())
 	static["timestamp"] = GetTimestamp()
 	for name, data := range w.staticData {
 		static[name] = data
@@ -140,6 +178,20 @@ func (w *Writer) writeStatic() {
 	log.Printf("writer: static metrics written")
 }
 
+// plainJSON converts v to maps and slices as encoding/json would write it, so
+// Flatten sees embedded JSON (collector options) as fields, not bytes.
+func plainJSON(v any) any {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var out any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil
+	}
+	return out
+}
+
 func (w *Writer) Close() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()